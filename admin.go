@@ -0,0 +1,359 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+var ErrUnknownSetting = errors.New("unknown setting")
+var ErrInvalidSetting = errors.New("invalid setting")
+
+const AuditSetSetting = "set_setting"
+const AuditUnsetSetting = "unset_setting"
+const AuditResetCursor = "reset_cursor"
+const AuditQueueRescan = "queue_rescan"
+const AuditCancelRescan = "cancel_rescan"
+const AuditPauseScraper = "pause_scraper"
+const AuditResumeScraper = "resume_scraper"
+const AuditAddWhitelist = "add_whitelist"
+const AuditUpdateWhitelist = "update_whitelist"
+const AuditDeleteWhitelist = "delete_whitelist"
+
+const DefaultAuditLimit = 100
+const MaxAuditLimit = 1000
+
+// ScraperSetting is a tunable of the scrapers kept in kv. The scrapers read it on every run
+// and fall back to Default when it isn't set.
+type ScraperSetting struct {
+	Key     KVKey  `json:"key"`
+	Default int    `json:"default"`
+	Min     int    `json:"min"`
+	Max     int    `json:"max"`
+	Usage   string `json:"usage"`
+}
+
+var ScraperSettings = []*ScraperSetting{
+	{KeyScrapeRangeEth, DefaultScrapeRangeEth, 1, 10000, "Blocks of native transfers scraped per run"},
+	{KeyScrapeRangeLookbackEth, DefaultScrapeRangeLookbackEth, 0, 1000, "Blocks behind the cursor scraped again for native transfers, to catch reorgs"},
+	{KeyScrapeConcurrencyEth, DefaultScrapeConcurrencyEth, 1, 64, "Block batches of native transfers fetched at once"},
+	{KeyScrapeBatchSizeEth, DefaultScrapeBatchSizeEth, 1, 1000, "Blocks per JSON-RPC batch of native transfers"},
+	{KeyScrapeRangeSups, DefaultScrapeRangeSups, MinLogRange, MaxLogRange, "Most blocks of token transfers scraped per run and per eth_getLogs call; the range learned for each token stays under it"},
+	{KeyScrapeRangeLookbackSups, DefaultScrapeRangeLookbackSups, 0, 10000, "Blocks behind the cursor scraped again for token transfers, to catch reorgs"},
+}
+
+func LookupScraperSetting(key string) (*ScraperSetting, error) {
+	for _, setting := range ScraperSettings {
+		if string(setting.Key) == key {
+			return setting, nil
+		}
+	}
+	return nil, fmt.Errorf("%w %q", ErrUnknownSetting, key)
+}
+
+func (s *ScraperSetting) Validate(value int) error {
+	if value < s.Min || value > s.Max {
+		return fmt.Errorf("%w: %s must be between %d and %d", ErrInvalidSetting, s.Key, s.Min, s.Max)
+	}
+	return nil
+}
+
+// ScraperSettingValue is the current value of a setting. Set is false when the scrapers use the default.
+type ScraperSettingValue struct {
+	*ScraperSetting
+	Value int  `json:"value"`
+	Set   bool `json:"set"`
+}
+
+// AuditEntry records a change made through the admin API or CLI. Values are nil when there was none.
+type AuditEntry struct {
+	ID        int64     `json:"id"`
+	Actor     string    `json:"actor"`
+	Action    string    `json:"action"`
+	Target    string    `json:"target"`
+	OldValue  *string   `json:"old_value"`
+	NewValue  *string   `json:"new_value"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// CLIActor names the user running an admin CLI command in the audit log.
+func CLIActor() string {
+	user := os.Getenv("USER")
+	if user == "" {
+		user = "unknown"
+	}
+	return "cli:" + user
+}
+
+// validBearer reports whether an Authorization value carries token as a bearer token. No token is never valid.
+func validBearer(token string, auth string) bool {
+	const scheme = "Bearer "
+	return token != "" && len(auth) > len(scheme) && strings.EqualFold(auth[:len(scheme)], scheme) &&
+		subtle.ConstantTimeCompare([]byte(auth[len(scheme):]), []byte(token)) == 1
+}
+
+// AdminAuth only lets through requests with "Authorization: Bearer <token>". Without a token every request is refused.
+func AdminAuth(token string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		fn := func(w http.ResponseWriter, r *http.Request) {
+			if !validBearer(token, r.Header.Get("Authorization")) {
+				w.Header().Set("WWW-Authenticate", `Bearer realm="admin"`)
+				http.Error(w, "unauthorized", http.StatusUnauthorized)
+				return
+			}
+			next.ServeHTTP(w, r)
+		}
+		return http.HandlerFunc(fn)
+	}
+}
+
+// MaxAdminActorNote bounds the X-Admin-Actor annotation kept in the audit log.
+const MaxAdminActorNote = 64
+
+// adminActor names who made an admin request in the audit log: always the client's address, followed by the
+// X-Admin-Actor header in parentheses when it is given. Anyone with the token can set the header,
+// so it only annotates the address.
+func adminActor(r *http.Request) string {
+	actor := "api:" + r.RemoteAddr
+	note := strings.TrimSpace(r.Header.Get("X-Admin-Actor"))
+	if len(note) > MaxAdminActorNote {
+		note = note[:MaxAdminActorNote]
+	}
+	if note != "" {
+		actor += " (" + note + ")"
+	}
+	return actor
+}
+
+// SettingRequest sets a scraper setting.
+type SettingRequest struct {
+	Value *int `json:"value"`
+}
+
+// CursorRequest moves the cursor of an asset to a block. The scraper goes on from the block after it.
+type CursorRequest struct {
+	Block *int64 `json:"block"`
+}
+
+type CursorResponse struct {
+	Chain       int64  `json:"chain"`
+	Symbol      string `json:"symbol"`
+	Key         KVKey  `json:"key"`
+	LastBlock   int64  `json:"last_block"`
+	BlockHeight int64  `json:"block_height"`
+}
+
+type PauseRequest struct {
+	Reason string `json:"reason"`
+}
+
+// Settings lists the scraper settings with their current values.
+func (c *Controller) Settings(w http.ResponseWriter, r *http.Request) {
+	result, err := ScraperSettingValues()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	err = json.NewEncoder(w).Encode(result)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+// SetSetting changes a scraper setting. The scrapers pick it up on their next run.
+func (c *Controller) SetSetting(w http.ResponseWriter, r *http.Request) {
+	setting, err := LookupScraperSetting(chi.URLParam(r, "key"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	req := &SettingRequest{}
+	err = json.NewDecoder(r.Body).Decode(req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if req.Value == nil {
+		http.Error(w, "missing value", http.StatusBadRequest)
+		return
+	}
+	err = setting.Validate(*req.Value)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	value := strconv.Itoa(*req.Value)
+	_, err = SetKVAudited(r.Context(), adminActor(r), AuditSetSetting, setting.Key, &value)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	err = json.NewEncoder(w).Encode(&ScraperSettingValue{setting, *req.Value, true})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+// UnsetSetting puts a scraper setting back to its default.
+func (c *Controller) UnsetSetting(w http.ResponseWriter, r *http.Request) {
+	setting, err := LookupScraperSetting(chi.URLParam(r, "key"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	_, err = SetKVAudited(r.Context(), adminActor(r), AuditUnsetSetting, setting.Key, nil)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Cursors lists the cursor of every asset with its chain's block height.
+func (c *Controller) Cursors(w http.ResponseWriter, r *http.Request) {
+	result := []*CursorResponse{}
+	for _, chain := range c.Chains.Chains {
+		blockHeight, err := GetInt(KeyBlockHeight(chain.ID), int(chain.BaseBlock))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		for _, asset := range chain.Assets {
+			key := KeyLastBlock(chain.ID, asset.Symbol)
+			lastBlock, err := GetInt(key, int(chain.BaseBlock))
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			result = append(result, &CursorResponse{chain.ID, asset.Symbol, key, int64(lastBlock), int64(blockHeight)})
+		}
+	}
+	err := json.NewEncoder(w).Encode(result)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+// ResetCursor moves the cursor of an asset to a block at or below the chain's block height.
+// A scrape running meanwhile fails instead of moving it on, and the next one starts from the new cursor.
+func (c *Controller) ResetCursor(w http.ResponseWriter, r *http.Request) {
+	chain, asset, err := c.Chains.LookupAsset(chi.URLParam(r, "chain"), chi.URLParam(r, "symbol"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	req := &CursorRequest{}
+	err = json.NewDecoder(r.Body).Decode(req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	blockHeight, err := GetInt(KeyBlockHeight(chain.ID), int(chain.BaseBlock))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if req.Block == nil || *req.Block < 0 || *req.Block > int64(blockHeight) {
+		http.Error(w, "block must be between 0 and the block height", http.StatusBadRequest)
+		return
+	}
+	key := KeyLastBlock(chain.ID, asset.Symbol)
+	value := strconv.FormatInt(*req.Block, 10)
+	_, err = SetKVAudited(r.Context(), adminActor(r), AuditResetCursor, key, &value)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	err = json.NewEncoder(w).Encode(&CursorResponse{chain.ID, asset.Symbol, key, *req.Block, int64(blockHeight)})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+// PauseScraper stops the leader from running a scraper, after its current run, until it is resumed.
+func (c *Controller) PauseScraper(w http.ResponseWriter, r *http.Request) {
+	name, ok := c.scraperName(w, r)
+	if !ok {
+		return
+	}
+	req := &PauseRequest{}
+	err := json.NewDecoder(r.Body).Decode(req)
+	if err != nil && !errors.Is(err, io.EOF) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	reason := req.Reason
+	if reason == "" {
+		reason = "paused"
+	}
+	_, err = SetKVAudited(r.Context(), adminActor(r), AuditPauseScraper, KeyScraperPaused(name), &reason)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (c *Controller) ResumeScraper(w http.ResponseWriter, r *http.Request) {
+	name, ok := c.scraperName(w, r)
+	if !ok {
+		return
+	}
+	_, err := SetKVAudited(r.Context(), adminActor(r), AuditResumeScraper, KeyScraperPaused(name), nil)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// scraperName checks {name} is one of the scheduler's scrapers, writing the error if it isn't.
+func (c *Controller) scraperName(w http.ResponseWriter, r *http.Request) (string, bool) {
+	name := chi.URLParam(r, "name")
+	for _, status := range c.Scheduler.Statuses() {
+		if status.Name == name {
+			return name, true
+		}
+	}
+	http.Error(w, "unknown scraper", http.StatusNotFound)
+	return "", false
+}
+
+// Audit lists the newest admin changes, ?limit= at a time.
+func (c *Controller) Audit(w http.ResponseWriter, r *http.Request) {
+	limit := DefaultAuditLimit
+	if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
+		var err error
+		limit, err = strconv.Atoi(limitStr)
+		if err != nil || limit < 1 {
+			http.Error(w, "invalid limit", http.StatusBadRequest)
+			return
+		}
+		if limit > MaxAuditLimit {
+			limit = MaxAuditLimit
+		}
+	}
+	result, err := AuditLog(r.Context(), limit)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	err = json.NewEncoder(w).Encode(result)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"xsyn-pricefeed/erc20"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/go-chi/chi/v5"
+	"github.com/shopspring/decimal"
+)
+
+var approvalTopic = common.HexToHash("0x8c5be1e5ebec7d5bd14f71427d1e84f3dd0314c0f7b2291e5b200ac8c7c3b925") // Approval(address,address,uint256)
+
+type Approval struct {
+	Block          uint64
+	BlockHash      common.Hash
+	LogIndex       uint
+	ChainID        int64
+	Contract       common.Address
+	Symbol         string
+	Decimals       int
+	TxID           common.Hash
+	OwnerAddress   common.Address
+	SpenderAddress common.Address
+	Amount         decimal.Decimal
+	CreatedAt      uint64
+}
+
+// ScrapeApprovals collects the Approval events of the asset in [fromBlock, toBlock] granted by one of owners.
+func ScrapeApprovals(ctx context.Context, client *Node, fromBlock int64, toBlock int64, chainID int64, asset *Asset, owners []common.Address) ([]*Approval, error) {
+	tokenAddr := asset.ContractAddress()
+	approvals := []*Approval{}
+	if len(owners) == 0 {
+		return approvals, nil
+	}
+	filterer, err := erc20.NewErc20Filterer(tokenAddr, client)
+	if err != nil {
+		return nil, fmt.Errorf("erc20 filterer: %w", err)
+	}
+	query := ethereum.FilterQuery{
+		Addresses: []common.Address{tokenAddr},
+		Topics:    [][]common.Hash{{approvalTopic}, addressTopics(owners)},
+	}
+	logs, err := FilterLogsAdaptive(ctx, client, query, fromBlock, toBlock, chainID, tokenAddr)
+	if err != nil {
+		return nil, fmt.Errorf("filter logs: %w", err)
+	}
+
+	hashes := []common.Hash{}
+	for _, vLog := range logs {
+		hashes = append(hashes, vLog.BlockHash)
+	}
+	times, err := blockTimes.Times(ctx, client, chainID, hashes)
+	if err != nil {
+		return nil, fmt.Errorf("get block times: %w", err)
+	}
+
+	for _, vLog := range logs {
+		ev, err := filterer.ParseApproval(vLog)
+		if err != nil {
+			return nil, fmt.Errorf("parse approval: %w", err)
+		}
+		approvals = append(approvals, &Approval{
+			Block:          vLog.BlockNumber,
+			BlockHash:      vLog.BlockHash,
+			LogIndex:       vLog.Index,
+			ChainID:        chainID,
+			Contract:       tokenAddr,
+			Symbol:         asset.Symbol,
+			Decimals:       asset.Decimals,
+			TxID:           vLog.TxHash,
+			OwnerAddress:   ev.Owner,
+			SpenderAddress: ev.Spender,
+			Amount:         decimal.NewFromBigInt(ev.Value, 0),
+			CreatedAt:      times[vLog.BlockHash],
+		})
+	}
+	return approvals, nil
+}
+
+type AllowanceResponse struct {
+	Chain               int64  `json:"chain"`
+	ContractAddress     string `json:"contract_address"`
+	Symbol              string `json:"symbol"`
+	OwnerAddress        string `json:"owner_address"`
+	SpenderAddress      string `json:"spender_address"`
+	ApprovedValue       string `json:"approved_value"`
+	ApprovedValueInt    string `json:"approved_value_int"`
+	ApprovedBlockNumber uint64 `json:"approved_block_number"`
+	ApprovedTxHash      string `json:"approved_tx_hash"`
+	ApprovedTimestamp   int64  `json:"approved_timestamp"`
+	Value               string `json:"value"`
+	ValueInt            string `json:"value_int"`
+	ValueDecimals       int    `json:"value_decimals"`
+	// Matches is false when the allowance changed without an Approval event, e.g. spent by transferFrom.
+	Matches bool `json:"matches"`
+}
+
+// Allowances lists the spenders owner has approved on each token of the chain,
+// pairing the last indexed Approval with the current on-chain allowance.
+func (c *Controller) Allowances(w http.ResponseWriter, r *http.Request) {
+	chain, ok := c.Chains.Lookup(chi.URLParam(r, "chain"))
+	if !ok {
+		http.Error(w, "unknown chain", http.StatusNotFound)
+		return
+	}
+	ownerStr := chi.URLParam(r, "owner")
+	if !common.IsHexAddress(ownerStr) {
+		http.Error(w, "invalid owner address", http.StatusBadRequest)
+		return
+	}
+	owner := common.HexToAddress(ownerStr)
+
+	approvals, err := LatestApprovals(int(chain.ID), owner)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	result := []*AllowanceResponse{}
+	for _, approval := range approvals {
+		token, err := erc20.NewErc20Caller(common.HexToAddress(approval.Contract), chain.Node)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		current, err := token.Allowance(&bind.CallOpts{Context: r.Context()}, owner, common.HexToAddress(approval.SpenderAddress))
+		if err != nil {
+			http.Error(w, fmt.Sprintf("get allowance: %s", err), http.StatusInternalServerError)
+			return
+		}
+		value := decimal.NewFromBigInt(current, 0)
+		result = append(result, &AllowanceResponse{
+			Chain:               approval.ChainID,
+			ContractAddress:     approval.Contract,
+			Symbol:              approval.Symbol,
+			OwnerAddress:        approval.OwnerAddress,
+			SpenderAddress:      approval.SpenderAddress,
+			ApprovedValue:       approval.Amount.Shift(-int32(approval.Decimals)).String(),
+			ApprovedValueInt:    approval.Amount.String(),
+			ApprovedBlockNumber: approval.Block,
+			ApprovedTxHash:      approval.TxID,
+			ApprovedTimestamp:   approval.Timestamp,
+			Value:               value.Shift(-int32(approval.Decimals)).String(),
+			ValueInt:            value.String(),
+			ValueDecimals:       approval.Decimals,
+			Matches:             value.Equal(approval.Amount),
+		})
+	}
+
+	err = json.NewEncoder(w).Encode(result)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
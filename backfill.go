@@ -0,0 +1,168 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"golang.org/x/sync/errgroup"
+)
+
+// BackfillChunk is an inclusive block range scraped and committed as a unit.
+type BackfillChunk struct {
+	FromBlock int64
+	ToBlock   int64
+}
+
+// Backfill scrapes [FromBlock, ToBlock] in chunks spread over Concurrency workers.
+// Every chunk is committed together with a record of its completion under Job,
+// so rerunning an interrupted backfill with the same Job skips the finished chunks.
+type Backfill struct {
+	Job         string
+	FromBlock   int64
+	ToBlock     int64
+	ChunkSize   int64
+	Concurrency int
+	// Scrape collects the transfers and approvals of an inclusive block range.
+	Scrape func(ctx context.Context, fromBlock int64, toBlock int64) ([]*Transfer, []*Approval, error)
+}
+
+// BackfillJob names a backfill after everything that decides what it scrapes, so the same command resumes
+// the same job while a different index mode or set of watched addresses starts a new one.
+// The watched addresses only count in watched mode; in all mode editing the whitelist resumes the same job.
+func BackfillJob(chainID int64, symbol string, mode IndexMode, watched []common.Address, fromBlock int64, toBlock int64, chunkSize int64) string {
+	if mode != IndexModeWatched {
+		watched = nil
+	}
+	return fmt.Sprintf("%d_%s_%s_%s_%d_%d_%d", chainID, symbol, mode, addressSetHash(watched), fromBlock, toBlock, chunkSize)
+}
+
+// addressSetHash is a short digest of a set of addresses that doesn't depend on their order or case.
+func addressSetHash(addresses []common.Address) string {
+	sorted := make([]string, 0, len(addresses))
+	for _, address := range addresses {
+		sorted = append(sorted, strings.ToLower(address.Hex()))
+	}
+	sort.Strings(sorted)
+	sum := sha256.Sum256([]byte(strings.Join(sorted, ",")))
+	return hex.EncodeToString(sum[:6])
+}
+
+func (b *Backfill) Chunks() []BackfillChunk {
+	chunks := []BackfillChunk{}
+	for from := b.FromBlock; from <= b.ToBlock; from += b.ChunkSize {
+		to := from + b.ChunkSize - 1
+		if to > b.ToBlock {
+			to = b.ToBlock
+		}
+		chunks = append(chunks, BackfillChunk{from, to})
+	}
+	return chunks
+}
+
+func (b *Backfill) Run(ctx context.Context) error {
+	if b.ChunkSize < 1 {
+		return fmt.Errorf("chunk size must be positive")
+	}
+	if b.Concurrency < 1 {
+		b.Concurrency = 1
+	}
+	completed, err := CompletedBackfillChunks(b.Job)
+	if err != nil {
+		return fmt.Errorf("get completed chunks: %w", err)
+	}
+	pending := []BackfillChunk{}
+	for _, chunk := range b.Chunks() {
+		if completed[chunk.FromBlock] {
+			continue
+		}
+		pending = append(pending, chunk)
+	}
+
+	log.Info().
+		Str("job", b.Job).
+		Int64("from_block", b.FromBlock).
+		Int64("to_block", b.ToBlock).
+		Int("chunks", len(b.Chunks())).
+		Int("pending", len(pending)).
+		Msg("start backfill")
+	if len(pending) == 0 {
+		return nil
+	}
+
+	var doneChunks, doneBlocks, total int64
+	pendingBlocks := int64(0)
+	for _, chunk := range pending {
+		pendingBlocks += chunk.ToBlock - chunk.FromBlock + 1
+	}
+	start := time.Now()
+	progress := func() {
+		blocks := atomic.LoadInt64(&doneBlocks)
+		elapsed := time.Since(start)
+		ev := log.Info().
+			Str("job", b.Job).
+			Int64("chunks_done", atomic.LoadInt64(&doneChunks)).
+			Int("chunks_pending", len(pending)).
+			Int64("blocks_done", blocks).
+			Int64("blocks_pending", pendingBlocks).
+			Int64("transfers", atomic.LoadInt64(&total)).
+			Str("elapsed", elapsed.Round(time.Second).String())
+		if blocks > 0 {
+			rate := float64(blocks) / elapsed.Seconds()
+			eta := time.Duration(float64(pendingBlocks-blocks) / rate * float64(time.Second))
+			ev = ev.Float64("blocks_per_second", rate).Str("eta", eta.Round(time.Second).String())
+		}
+		ev.Msg("backfill progress")
+	}
+
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		ticker := time.NewTicker(10 * time.Second)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				progress()
+			}
+		}
+	}()
+
+	g, ctx := errgroup.WithContext(ctx)
+	g.SetLimit(b.Concurrency)
+	for _, chunk := range pending {
+		chunk := chunk
+		if ctx.Err() != nil {
+			break
+		}
+		g.Go(func() error {
+			transfers, approvals, err := b.Scrape(ctx, chunk.FromBlock, chunk.ToBlock)
+			if err != nil {
+				return fmt.Errorf("scrape chunk %d-%d: %w", chunk.FromBlock, chunk.ToBlock, err)
+			}
+			inserted, err := CommitBackfillChunk(b.Job, chunk, transfers, approvals)
+			if err != nil {
+				return fmt.Errorf("commit chunk %d-%d: %w", chunk.FromBlock, chunk.ToBlock, err)
+			}
+			atomic.AddInt64(&total, int64(inserted))
+			atomic.AddInt64(&doneBlocks, chunk.ToBlock-chunk.FromBlock+1)
+			atomic.AddInt64(&doneChunks, 1)
+			return nil
+		})
+	}
+	err = g.Wait()
+	progress()
+	if err != nil {
+		return err
+	}
+	log.Info().Str("job", b.Job).Msg("backfill finished")
+	return nil
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+func TestBackfillJob(t *testing.T) {
+	a := common.HexToAddress("0x48e6f3e175C58181086AEC640f21815C5EbF4fC0")
+	b := common.HexToAddress("0x0f3FB0E3800927Fa4757eAF9BeBD9982c5534CC3")
+	job := BackfillJob(1, "SUPS", IndexModeWatched, []common.Address{a, b}, 100, 200, 10)
+	tests := []struct {
+		name string
+		job  string
+		same bool
+	}{
+		{"same addresses in another order", BackfillJob(1, "SUPS", IndexModeWatched, []common.Address{b, a}, 100, 200, 10), true},
+		{"other index mode", BackfillJob(1, "SUPS", IndexModeAll, []common.Address{a, b}, 100, 200, 10), false},
+		{"fewer addresses", BackfillJob(1, "SUPS", IndexModeWatched, []common.Address{a}, 100, 200, 10), false},
+		{"no addresses", BackfillJob(1, "SUPS", IndexModeWatched, nil, 100, 200, 10), false},
+		{"other range", BackfillJob(1, "SUPS", IndexModeWatched, []common.Address{a, b}, 100, 300, 10), false},
+		{"other chain", BackfillJob(5, "SUPS", IndexModeWatched, []common.Address{a, b}, 100, 200, 10), false},
+	}
+	for _, tt := range tests {
+		if (tt.job == job) != tt.same {
+			t.Errorf("%s: job %s, first job %s, want same %t", tt.name, tt.job, job, tt.same)
+		}
+	}
+
+	job = BackfillJob(1, "SUPS", IndexModeAll, []common.Address{a, b}, 100, 200, 10)
+	tests = []struct {
+		name string
+		job  string
+		same bool
+	}{
+		{"all mode with fewer addresses", BackfillJob(1, "SUPS", IndexModeAll, []common.Address{a}, 100, 200, 10), true},
+		{"all mode with no addresses", BackfillJob(1, "SUPS", IndexModeAll, nil, 100, 200, 10), true},
+		{"all mode with other range", BackfillJob(1, "SUPS", IndexModeAll, []common.Address{a, b}, 100, 300, 10), false},
+	}
+	for _, tt := range tests {
+		if (tt.job == job) != tt.same {
+			t.Errorf("%s: job %s, first job %s, want same %t", tt.name, tt.job, job, tt.same)
+		}
+	}
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/go-chi/chi/v5"
+	"github.com/shopspring/decimal"
+)
+
+// BalanceKey identifies a running balance. Balances are only kept for tokens:
+// native transfers are only indexed towards whitelisted addresses, so they can't add up to a balance.
+type BalanceKey struct {
+	ChainID int64
+	Symbol  string
+	Address common.Address
+}
+
+type BalanceDelta struct {
+	Amount decimal.Decimal
+	Block  uint64
+}
+
+// BalanceDeltas sums the effect of transfers on the balances of the addresses involved.
+// A sign of -1 reverses them. Mints and burns only move the balance of the other side.
+func BalanceDeltas(transfers []*Transfer, sign int64) map[BalanceKey]*BalanceDelta {
+	result := map[BalanceKey]*BalanceDelta{}
+	add := func(key BalanceKey, amount decimal.Decimal, block uint64) {
+		if key.Address == (common.Address{}) {
+			return
+		}
+		delta, ok := result[key]
+		if !ok {
+			delta = &BalanceDelta{Amount: decimal.Zero}
+			result[key] = delta
+		}
+		delta.Amount = delta.Amount.Add(amount)
+		if block > delta.Block {
+			delta.Block = block
+		}
+	}
+	for _, transfer := range transfers {
+		if transfer.Contract == (common.Address{}) {
+			continue
+		}
+		amount := transfer.Amount.Mul(decimal.NewFromInt(sign))
+		add(BalanceKey{transfer.ChainID, transfer.Symbol, transfer.FromAddress}, amount.Neg(), transfer.Block)
+		add(BalanceKey{transfer.ChainID, transfer.Symbol, transfer.ToAddress}, amount, transfer.Block)
+	}
+	return result
+}
+
+const DefaultHoldersLimit = 100
+
+const MaxHoldersLimit = 1000
+
+type BalanceResponse struct {
+	Chain         int64  `json:"chain"`
+	Symbol        string `json:"symbol"`
+	Address       string `json:"address"`
+	Value         string `json:"value"`
+	ValueInt      string `json:"value_int"`
+	ValueDecimals int    `json:"value_decimals"`
+	UpdatedBlock  uint64 `json:"updated_block"`
+}
+
+type HoldersResponse struct {
+	Chain   int64              `json:"chain"`
+	Symbol  string             `json:"symbol"`
+	Holders int                `json:"holders"`
+	Top     []*BalanceResponse `json:"top"`
+}
+
+func (c *Controller) balanceResponse(record *BalanceRecord) *BalanceResponse {
+	decimals := 18
+	chain, ok := c.Chains.ByID(record.ChainID)
+	if ok {
+		asset, ok := chain.Asset(record.Symbol)
+		if ok {
+			decimals = asset.Decimals
+		}
+	}
+	return &BalanceResponse{
+		Chain:         record.ChainID,
+		Symbol:        record.Symbol,
+		Address:       record.Address,
+		Value:         record.Balance.Shift(-int32(decimals)).String(),
+		ValueInt:      record.Balance.String(),
+		ValueDecimals: decimals,
+		UpdatedBlock:  record.UpdatedBlock,
+	}
+}
+
+// Holders returns the largest holders of a token (?limit=, 100 by default) and the number of holders.
+func (c *Controller) Holders(w http.ResponseWriter, r *http.Request) {
+	chain, ok := c.Chains.Lookup(chi.URLParam(r, "chain"))
+	if !ok {
+		http.Error(w, "unknown chain", http.StatusNotFound)
+		return
+	}
+	asset, ok := chain.Asset(chi.URLParam(r, "symbol"))
+	if !ok || asset.Native() {
+		http.Error(w, "unknown token", http.StatusNotFound)
+		return
+	}
+
+	limit := DefaultHoldersLimit
+	if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
+		var err error
+		limit, err = strconv.Atoi(limitStr)
+		if err != nil || limit < 1 {
+			http.Error(w, "invalid limit", http.StatusBadRequest)
+			return
+		}
+		if limit > MaxHoldersLimit {
+			limit = MaxHoldersLimit
+		}
+	}
+
+	records, count, err := Holders(chain.ID, asset.Symbol, limit)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	result := &HoldersResponse{chain.ID, asset.Symbol, count, []*BalanceResponse{}}
+	for _, record := range records {
+		result.Top = append(result.Top, c.balanceResponse(record))
+	}
+
+	err = json.NewEncoder(w).Encode(result)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+func (c *Controller) AddressBalances(w http.ResponseWriter, r *http.Request) {
+	addrStr := chi.URLParam(r, "addr")
+	if !common.IsHexAddress(addrStr) {
+		http.Error(w, "invalid address", http.StatusBadRequest)
+		return
+	}
+	records, err := AddressBalances(common.HexToAddress(addrStr))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	result := []*BalanceResponse{}
+	for _, record := range records {
+		result = append(result, c.balanceResponse(record))
+	}
+
+	err = json.NewEncoder(w).Encode(result)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
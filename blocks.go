@@ -0,0 +1,170 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"strconv"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/prometheus/client_golang/prometheus"
+	"golang.org/x/sync/errgroup"
+)
+
+var (
+	blocksFetchedCounter = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "xsyn_pricefeed_blocks_fetched_total",
+		Help: "How many full blocks were fetched for native transfer scraping, partitioned by chain.",
+	}, []string{"chain_id"})
+	blocksPerSecondGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "xsyn_pricefeed_blocks_per_second",
+		Help: "Block fetch throughput of the most recent native transfer scrape, partitioned by chain.",
+	}, []string{"chain_id"})
+)
+
+func init() {
+	prometheus.MustRegister(blocksFetchedCounter, blocksPerSecondGauge)
+}
+
+type BlockFetchOptions struct {
+	// Concurrency is the number of batches in flight at once.
+	Concurrency int
+	// BatchSize is the number of eth_getBlockByNumber calls sent in a single JSON-RPC batch.
+	BatchSize int
+}
+
+// Block is the subset of a full block needed to scrape native transfers.
+type Block struct {
+	Hash         common.Hash
+	Header       *types.Header
+	Transactions []*types.Transaction
+}
+
+func (b *Block) UnmarshalJSON(data []byte) error {
+	if string(data) == "null" {
+		return nil
+	}
+	header := &types.Header{}
+	err := json.Unmarshal(data, header)
+	if err != nil {
+		return fmt.Errorf("unmarshal header: %w", err)
+	}
+	body := struct {
+		Hash         common.Hash          `json:"hash"`
+		Transactions []*types.Transaction `json:"transactions"`
+	}{}
+	err = json.Unmarshal(data, &body)
+	if err != nil {
+		return fmt.Errorf("unmarshal transactions: %w", err)
+	}
+	b.Hash = body.Hash
+	b.Header = header
+	b.Transactions = body.Transactions
+	return nil
+}
+
+// FetchBlocks downloads the blocks in [fromBlock, toBlock) with batched eth_getBlockByNumber calls
+// spread over a bounded pool of workers. Blocks are returned in ascending order.
+func FetchBlocks(ctx context.Context, client *Node, fromBlock int64, toBlock int64, chainID int64, opts BlockFetchOptions) ([]*Block, error) {
+	if toBlock <= fromBlock {
+		return []*Block{}, nil
+	}
+	if opts.Concurrency < 1 {
+		opts.Concurrency = 1
+	}
+	if opts.BatchSize < 1 {
+		opts.BatchSize = 1
+	}
+
+	start := time.Now()
+	blocks := make([]*Block, toBlock-fromBlock)
+	g, ctx := errgroup.WithContext(ctx)
+	g.SetLimit(opts.Concurrency)
+	for batchStart := fromBlock; batchStart < toBlock; batchStart += int64(opts.BatchSize) {
+		batchStart := batchStart
+		batchEnd := batchStart + int64(opts.BatchSize)
+		if batchEnd > toBlock {
+			batchEnd = toBlock
+		}
+		g.Go(func() error {
+			elems := make([]rpc.BatchElem, 0, batchEnd-batchStart)
+			for n := batchStart; n < batchEnd; n++ {
+				blocks[n-fromBlock] = &Block{}
+				elems = append(elems, rpc.BatchElem{
+					Method: "eth_getBlockByNumber",
+					Args:   []interface{}{hexutil.EncodeBig(big.NewInt(n)), true},
+					Result: blocks[n-fromBlock],
+				})
+			}
+			err := client.BatchCallContextAt(ctx, uint64(batchEnd-1), elems)
+			if err != nil {
+				return fmt.Errorf("batch get blocks %d-%d: %w", batchStart, batchEnd-1, err)
+			}
+			for i, elem := range elems {
+				if elem.Error != nil {
+					return fmt.Errorf("get block %d: %w", batchStart+int64(i), elem.Error)
+				}
+				if blocks[batchStart-fromBlock+int64(i)].Header == nil {
+					return fmt.Errorf("get block %d: not found", batchStart+int64(i))
+				}
+			}
+			blocksFetchedCounter.WithLabelValues(strconv.FormatInt(chainID, 10)).Add(float64(len(elems)))
+			return nil
+		})
+	}
+	err := g.Wait()
+	if err != nil {
+		return nil, err
+	}
+
+	elapsed := time.Since(start).Seconds()
+	if elapsed > 0 {
+		blocksPerSecondGauge.WithLabelValues(strconv.FormatInt(chainID, 10)).Set(float64(len(blocks)) / elapsed)
+	}
+	return blocks, nil
+}
+
+type blockHeader struct {
+	Hash common.Hash `json:"hash"`
+}
+
+// BlockHashes returns the hash of the node's block at every height, read in a single JSON-RPC batch.
+// A height the node doesn't have fails the call.
+func BlockHashes(ctx context.Context, client *Node, heights []uint64) (map[uint64]common.Hash, error) {
+	result := map[uint64]common.Hash{}
+	if len(heights) == 0 {
+		return result, nil
+	}
+	headers := make([]*blockHeader, len(heights))
+	elems := make([]rpc.BatchElem, 0, len(heights))
+	highest := uint64(0)
+	for i, height := range heights {
+		if height > highest {
+			highest = height
+		}
+		elems = append(elems, rpc.BatchElem{
+			Method: "eth_getBlockByNumber",
+			Args:   []interface{}{hexutil.EncodeUint64(height), false},
+			Result: &headers[i],
+		})
+	}
+	err := client.BatchCallContextAt(ctx, highest, elems)
+	if err != nil {
+		return nil, fmt.Errorf("batch get block hashes: %w", err)
+	}
+	for i, elem := range elems {
+		if elem.Error != nil {
+			return nil, fmt.Errorf("get block %d: %w", heights[i], elem.Error)
+		}
+		if headers[i] == nil {
+			return nil, fmt.Errorf("get block %d: not found", heights[i])
+		}
+		result[heights[i]] = headers[i].Hash
+	}
+	return result, nil
+}
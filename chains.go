@@ -0,0 +1,245 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// Asset is something to index on a chain: the native coin when Contract is empty, an ERC-20 otherwise.
+// The symbol and decimals of an ERC-20 are read from its contract when the chain is dialled.
+type Asset struct {
+	Symbol    string    `json:"symbol"`
+	Contract  string    `json:"contract,omitempty"`
+	Decimals  int       `json:"decimals,omitempty"`
+	IndexMode IndexMode `json:"index_mode,omitempty"`
+	// SupplyExcluded are the addresses (treasury, vesting, burn) whose balances don't count towards the circulating supply.
+	SupplyExcluded []string `json:"supply_excluded,omitempty"`
+}
+
+func (a *Asset) Native() bool {
+	return a.Contract == ""
+}
+
+func (a *Asset) ContractAddress() common.Address {
+	return common.HexToAddress(a.Contract)
+}
+
+func (a *Asset) SupplyExcludedAddresses() []common.Address {
+	result := []common.Address{}
+	for _, addr := range a.SupplyExcluded {
+		result = append(result, common.HexToAddress(addr))
+	}
+	return result
+}
+
+type Chain struct {
+	ID   int64  `json:"id"`
+	Name string `json:"name"`
+	// RPCURLs are the node endpoints of the chain, in order of preference.
+	RPCURLs []string `json:"rpc_urls"`
+	// RPCRateLimit overrides --rpc_rate_limit for every endpoint of the chain, in requests per second.
+	RPCRateLimit float64  `json:"rpc_rate_limit,omitempty"`
+	BaseBlock    int64    `json:"base_block"`
+	Assets       []*Asset `json:"assets"`
+
+	Node *Node `json:"-"`
+}
+
+func (c *Chain) Asset(symbol string) (*Asset, bool) {
+	for _, asset := range c.Assets {
+		if strings.EqualFold(asset.Symbol, symbol) {
+			return asset, true
+		}
+	}
+	return nil, false
+}
+
+// KnownChains fills in the IDs and base blocks of chains configured by name only.
+var KnownChains = map[string]*Chain{
+	"mainnet": {ID: 1, Name: "mainnet", BaseBlock: BaseMainnetBlock},
+	"goerli":  {ID: 5, Name: "goerli", BaseBlock: BaseGoerliBlock},
+	"sepolia": {ID: 11155111, Name: "sepolia"},
+	"bsc":     {ID: 56, Name: "bsc"},
+	"polygon": {ID: 137, Name: "polygon"},
+}
+
+type ChainRegistry struct {
+	Chains []*Chain
+}
+
+// LoadChains reads the chain registry from a JSON file holding a list of chains.
+// Environment variables in the file are expanded, so RPC URLs can keep their keys out of it.
+func LoadChains(path string) (*ChainRegistry, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read chains: %w", err)
+	}
+	chains := []*Chain{}
+	err = json.Unmarshal([]byte(os.ExpandEnv(string(data))), &chains)
+	if err != nil {
+		return nil, fmt.Errorf("parse chains: %w", err)
+	}
+	return NewChainRegistry(chains)
+}
+
+func NewChainRegistry(chains []*Chain) (*ChainRegistry, error) {
+	r := &ChainRegistry{}
+	for _, chain := range chains {
+		known, ok := KnownChains[chain.Name]
+		if ok {
+			if chain.ID == 0 {
+				chain.ID = known.ID
+			}
+			if chain.BaseBlock == 0 {
+				chain.BaseBlock = known.BaseBlock
+			}
+		}
+		if chain.ID == 0 {
+			return nil, fmt.Errorf("chain %q: missing id", chain.Name)
+		}
+		if chain.Name == "" {
+			return nil, fmt.Errorf("chain %d: missing name", chain.ID)
+		}
+		if chain.BaseBlock == 0 {
+			return nil, fmt.Errorf("chain %s: missing base_block", chain.Name)
+		}
+		if len(chain.RPCURLs) == 0 {
+			return nil, fmt.Errorf("chain %s: missing rpc_urls", chain.Name)
+		}
+		if _, ok := r.ByID(chain.ID); ok {
+			return nil, fmt.Errorf("chain %s: duplicate id %d", chain.Name, chain.ID)
+		}
+		if _, ok := r.ByName(chain.Name); ok {
+			return nil, fmt.Errorf("chain %s: duplicate name", chain.Name)
+		}
+		for _, asset := range chain.Assets {
+			if asset.Native() && asset.Symbol == "" {
+				return nil, fmt.Errorf("chain %s: native asset missing symbol", chain.Name)
+			}
+			asset.Symbol = strings.ToUpper(asset.Symbol)
+			if asset.Native() && asset.Decimals == 0 {
+				asset.Decimals = 18
+			}
+			if asset.IndexMode == "" {
+				asset.IndexMode = IndexModeAll
+			}
+			_, err := ParseIndexMode(string(asset.IndexMode))
+			if err != nil {
+				return nil, fmt.Errorf("chain %s asset %s: %w", chain.Name, asset.Symbol, err)
+			}
+			if !asset.Native() && !common.IsHexAddress(asset.Contract) {
+				return nil, fmt.Errorf("chain %s asset %s: invalid contract %q", chain.Name, asset.Symbol, asset.Contract)
+			}
+			for _, addr := range asset.SupplyExcluded {
+				if !common.IsHexAddress(addr) {
+					return nil, fmt.Errorf("chain %s asset %s: invalid supply_excluded address %q", chain.Name, asset.Symbol, addr)
+				}
+			}
+		}
+		err := checkDuplicateAssets(chain)
+		if err != nil {
+			return nil, err
+		}
+		r.Chains = append(r.Chains, chain)
+	}
+	return r, nil
+}
+
+// checkDuplicateAssets fails on two assets of a chain with the same symbol, ignoring tokens not resolved yet.
+func checkDuplicateAssets(chain *Chain) error {
+	symbols := map[string]bool{}
+	for _, asset := range chain.Assets {
+		if asset.Symbol == "" {
+			continue
+		}
+		if symbols[asset.Symbol] {
+			return fmt.Errorf("chain %s: duplicate asset %s", chain.Name, asset.Symbol)
+		}
+		symbols[asset.Symbol] = true
+	}
+	return nil
+}
+
+// Dial connects every chain to its node and resolves the symbol and decimals of its tokens.
+func (r *ChainRegistry) Dial() error {
+	for _, chain := range r.Chains {
+		node, err := DialNode(chain.Name, chain.RPCURLs...)
+		if err != nil {
+			return fmt.Errorf("dial %s node: %w", chain.Name, err)
+		}
+		chain.Node = node
+		for _, asset := range chain.Assets {
+			err = ResolveAsset(context.Background(), node, chain.ID, asset)
+			if err != nil {
+				return fmt.Errorf("chain %s: %w", chain.Name, err)
+			}
+		}
+		err = checkDuplicateAssets(chain)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *ChainRegistry) ByID(id int64) (*Chain, bool) {
+	for _, chain := range r.Chains {
+		if chain.ID == id {
+			return chain, true
+		}
+	}
+	return nil, false
+}
+
+func (r *ChainRegistry) ByName(name string) (*Chain, bool) {
+	for _, chain := range r.Chains {
+		if chain.Name == name {
+			return chain, true
+		}
+	}
+	return nil, false
+}
+
+// Token finds the first chain indexing the ERC-20 symbol.
+func (r *ChainRegistry) Token(symbol string) (*Chain, *Asset, bool) {
+	for _, chain := range r.Chains {
+		asset, ok := chain.Asset(symbol)
+		if ok && !asset.Native() {
+			return chain, asset, true
+		}
+	}
+	return nil, nil, false
+}
+
+// Lookup finds a chain by name or by its decimal chain ID.
+func (r *ChainRegistry) Lookup(nameOrID string) (*Chain, bool) {
+	chain, ok := r.ByName(nameOrID)
+	if ok {
+		return chain, true
+	}
+	id, err := strconv.ParseInt(nameOrID, 10, 64)
+	if err != nil {
+		return nil, false
+	}
+	return r.ByID(id)
+}
+
+// LookupAsset finds an asset by its chain's name or decimal chain ID and its symbol,
+// failing with ErrUnknownChain or ErrUnknownSymbol.
+func (r *ChainRegistry) LookupAsset(chainNameOrID string, symbol string) (*Chain, *Asset, error) {
+	chain, ok := r.Lookup(chainNameOrID)
+	if !ok {
+		return nil, nil, ErrUnknownChain
+	}
+	asset, ok := chain.Asset(symbol)
+	if !ok {
+		return nil, nil, ErrUnknownSymbol
+	}
+	return chain, asset, nil
+}
@@ -2,8 +2,10 @@ package main
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
@@ -24,15 +26,41 @@ type KVKey string
 
 const KeyScrapeRangeLookbackEth KVKey = "scrape_range_lookback_eth"
 const KeyScrapeRangeLookbackSups KVKey = "scrape_range_lookback_sups"
+const KeyScrapeRangeSups KVKey = "scrape_range_sups"
 
 const KeyScrapeRangeEth KVKey = "scrape_range_eth"
-const KeyScrapeRangeSups KVKey = "scrape_range_sups"
-const KeyBlockHeightGoerli KVKey = "block_height_goerli"
-const KeyLastBlockGoerliSups KVKey = "last_block_goerli_sups"
-const KeyLastBlockGoerliEth KVKey = "last_block_goerli_eth"
-const KeyBlockHeightMainnet KVKey = "block_height_mainnet"
-const KeyLastBlockMainnetSups KVKey = "last_block_mainnet_sups"
-const KeyLastBlockMainnetEth KVKey = "last_block_mainnet_eth"
+const KeyScrapeConcurrencyEth KVKey = "scrape_concurrency_eth"
+const KeyScrapeBatchSizeEth KVKey = "scrape_batch_size_eth"
+
+const DefaultScrapeRangeLookbackEth = 5
+const DefaultScrapeRangeLookbackSups = 50
+const DefaultScrapeRangeEth = 500
+const DefaultScrapeConcurrencyEth = 4
+const DefaultScrapeBatchSizeEth = 20
+const DefaultScrapeRangeSups = DefaultLogRange
+
+// ErrCursorMoved fails a scrape whose cursor was changed, by an admin reset, while it ran.
+var ErrCursorMoved = errors.New("cursor moved")
+
+// KeyBlockHeight holds the latest block number seen on a chain.
+func KeyBlockHeight(chainID int64) KVKey {
+	return KVKey(fmt.Sprintf("block_height_%d", chainID))
+}
+
+// KeyLogRange holds the eth_getLogs block range learned for a token on a chain.
+func KeyLogRange(chainID int64, tokenAddr common.Address) KVKey {
+	return KVKey(fmt.Sprintf("log_range_%d_%s", chainID, strings.ToLower(tokenAddr.Hex())))
+}
+
+// KeyScraperPaused is set while a scraper is paused. Its value is the reason.
+func KeyScraperPaused(name string) KVKey {
+	return KVKey(fmt.Sprintf("scraper_paused_%s", name))
+}
+
+// KeyLastBlock is the scrape cursor of an asset on a chain.
+func KeyLastBlock(chainID int64, symbol string) KVKey {
+	return KVKey(fmt.Sprintf("last_block_%d_%s", chainID, strings.ToLower(symbol)))
+}
 
 func Connect(connString string) error {
 	var err error
@@ -44,20 +72,21 @@ func Connect(connString string) error {
 
 }
 
+// Exists reports whether key is set, without setting it to a default like Get does.
 func Exists(key KVKey) (bool, error) {
-	q := `SELECT COUNT id FROM kv WHERE key = $1`
+	q := `SELECT COUNT(*) FROM kv WHERE key = $1`
 	var result int
 	err := pgxscan.Get(context.TODO(), conn, &result, q, key)
 	if err != nil {
-		return false, fmt.Errorf("set block: %w", err)
+		return false, fmt.Errorf("exists %s: %w", key, err)
 	}
-	return true, nil
+	return result > 0, nil
 }
 
-func Set(key KVKey, value string) error {
-	q := `INSERT INTO kv (key, value) VALUES ($1, $2) ON CONFLICT (key) DO UPDATE SET value=EXCLUDED.value;`
+const setQuery = `INSERT INTO kv (key, value) VALUES ($1, $2) ON CONFLICT (key) DO UPDATE SET value=EXCLUDED.value;`
 
-	_, err := conn.Exec(context.TODO(), q, key, value)
+func Set(key KVKey, value string) error {
+	_, err := conn.Exec(context.TODO(), setQuery, key, value)
 	if err != nil {
 		return fmt.Errorf("set block: %w", err)
 	}
@@ -80,8 +109,9 @@ func SetInt(key KVKey, value int) error {
 	return Set(key, strconv.Itoa(value))
 }
 
+// WhitelistedAddresses returns the addresses of a chain that weren't deleted, whatever their block range.
 func WhitelistedAddresses(chainID int) ([]common.Address, error) {
-	q := `SELECT address FROM whitelisted_addresses WHERE chain_id = $1`
+	q := `SELECT address FROM whitelisted_addresses WHERE chain_id = $1 AND deleted_at IS NULL`
 	resultStr := []string{}
 	err := pgxscan.Select(context.TODO(), conn, &resultStr, q, chainID)
 	if err != nil {
@@ -95,6 +125,131 @@ func WhitelistedAddresses(chainID int) ([]common.Address, error) {
 	return result, nil
 }
 
+// WatchedAddresses returns the addresses of a chain that weren't deleted and are watched anywhere in the
+// inclusive block range. The whole range is scraped for them, so it may include a few blocks outside theirs.
+func WatchedAddresses(chainID int64, fromBlock int64, toBlock int64) ([]common.Address, error) {
+	q := `SELECT address FROM whitelisted_addresses WHERE chain_id = $1 AND deleted_at IS NULL AND (start_block IS NULL OR start_block <= $3) AND (end_block IS NULL OR end_block >= $2)`
+	resultStr := []string{}
+	err := pgxscan.Select(context.TODO(), conn, &resultStr, q, chainID, fromBlock, toBlock)
+	if err != nil {
+		return nil, fmt.Errorf("get watched addresses: %w", err)
+	}
+	result := []common.Address{}
+	for _, addrStr := range resultStr {
+		result = append(result, common.HexToAddress(addrStr))
+	}
+	return result, nil
+}
+
+const whitelistColumns = `id, address, chain_id, note, start_block, end_block, deleted_at, created_at, updated_at`
+
+// WhitelistedAddressRecords returns the whitelisted addresses of a chain, with the deleted ones when includeDeleted is set.
+func WhitelistedAddressRecords(ctx context.Context, chainID int64, includeDeleted bool) ([]*WhitelistedAddress, error) {
+	q := `SELECT ` + whitelistColumns + ` FROM whitelisted_addresses WHERE chain_id = $1`
+	if !includeDeleted {
+		q += ` AND deleted_at IS NULL`
+	}
+	q += ` ORDER BY created_at`
+	result := []*WhitelistedAddress{}
+	err := pgxscan.Select(ctx, conn, &result, q, chainID)
+	if err != nil {
+		return nil, fmt.Errorf("get whitelisted addresses: %w", err)
+	}
+	return result, nil
+}
+
+// WhitelistedAddressByAddress returns the whitelisted address of a chain, deleted or not, or nil.
+func WhitelistedAddressByAddress(ctx context.Context, chainID int64, address string) (*WhitelistedAddress, error) {
+	result := &WhitelistedAddress{}
+	err := pgxscan.Get(ctx, conn, result, `SELECT `+whitelistColumns+` FROM whitelisted_addresses WHERE chain_id = $1 AND address = $2`, chainID, address)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("get whitelisted address: %w", err)
+	}
+	return result, nil
+}
+
+// AddWhitelistedAddress whitelists an address, or restores it if it was deleted, and queues rescans and
+// records the change in admin_audit in the same transaction. It returns nil if the address is already whitelisted.
+func AddWhitelistedAddress(ctx context.Context, actor string, address *WhitelistedAddress, rescans []*Rescan) (*WhitelistedAddress, error) {
+	q := `INSERT INTO whitelisted_addresses (address, chain_id, note, start_block, end_block) VALUES ($1, $2, $3, $4, $5)
+	ON CONFLICT (address, chain_id) DO UPDATE SET note = EXCLUDED.note, start_block = EXCLUDED.start_block, end_block = EXCLUDED.end_block, deleted_at = NULL, updated_at = NOW()
+	WHERE whitelisted_addresses.deleted_at IS NOT NULL
+	RETURNING ` + whitelistColumns
+	var result *WhitelistedAddress
+	err := pgx.BeginFunc(ctx, conn, func(tx pgx.Tx) error {
+		rows := []*WhitelistedAddress{}
+		err := pgxscan.Select(ctx, tx, &rows, q, address.Address, address.ChainID, address.Note, address.StartBlock, address.EndBlock)
+		if err != nil {
+			return err
+		}
+		if len(rows) == 0 {
+			return nil
+		}
+		result = rows[0]
+		_, err = tx.Exec(ctx, insertAuditQuery, actor, AuditAddWhitelist, result.AuditTarget(), nil, result.AuditValue())
+		if err != nil {
+			return err
+		}
+		return InsertRescans(ctx, tx, rescans)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("add whitelisted address: %w", err)
+	}
+	return result, nil
+}
+
+// UpdateWhitelistedAddress saves the note and block range of an address that wasn't deleted, recording
+// the change in admin_audit, or returns nil.
+func UpdateWhitelistedAddress(ctx context.Context, actor string, address *WhitelistedAddress) (*WhitelistedAddress, error) {
+	q := `UPDATE whitelisted_addresses SET note = $3, start_block = $4, end_block = $5, updated_at = NOW() WHERE chain_id = $1 AND address = $2 AND deleted_at IS NULL RETURNING ` + whitelistColumns
+	var result *WhitelistedAddress
+	err := pgx.BeginFunc(ctx, conn, func(tx pgx.Tx) error {
+		old := &WhitelistedAddress{}
+		err := pgxscan.Get(ctx, tx, old, `SELECT `+whitelistColumns+` FROM whitelisted_addresses WHERE chain_id = $1 AND address = $2 AND deleted_at IS NULL FOR UPDATE`, address.ChainID, address.Address)
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		result = &WhitelistedAddress{}
+		err = pgxscan.Get(ctx, tx, result, q, address.ChainID, address.Address, address.Note, address.StartBlock, address.EndBlock)
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(ctx, insertAuditQuery, actor, AuditUpdateWhitelist, result.AuditTarget(), old.AuditValue(), result.AuditValue())
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("update whitelisted address: %w", err)
+	}
+	return result, nil
+}
+
+// DeleteWhitelistedAddress soft-deletes an address, so it is no longer scraped, recording the change in admin_audit.
+// Its transfers are kept.
+func DeleteWhitelistedAddress(ctx context.Context, actor string, chainID int64, address string) (bool, error) {
+	q := `UPDATE whitelisted_addresses SET deleted_at = NOW(), updated_at = NOW() WHERE chain_id = $1 AND address = $2 AND deleted_at IS NULL RETURNING ` + whitelistColumns
+	deleted := false
+	err := pgx.BeginFunc(ctx, conn, func(tx pgx.Tx) error {
+		rows := []*WhitelistedAddress{}
+		err := pgxscan.Select(ctx, tx, &rows, q, chainID, address)
+		if err != nil || len(rows) == 0 {
+			return err
+		}
+		deleted = true
+		_, err = tx.Exec(ctx, insertAuditQuery, actor, AuditDeleteWhitelist, rows[0].AuditTarget(), rows[0].AuditValue(), nil)
+		return err
+	})
+	if err != nil {
+		return false, fmt.Errorf("delete whitelisted address: %w", err)
+	}
+	return deleted, nil
+}
+
 func Get(key KVKey, defaultValue ...int) (string, error) {
 	q := `SELECT value FROM kv WHERE key = $1 LIMIT 1`
 	var result string
@@ -109,72 +264,1270 @@ func Get(key KVKey, defaultValue ...int) (string, error) {
 	return result, nil
 }
 
+// AddPrice records prices and logs them for /api/stream in a single transaction.
 func AddPrice(price *PriceResponse) error {
 	if price.SUPSUSD == decimal.Zero {
-		price.SUPSUSD = decimal.NewFromFloat(0.8)
+		price.SUPSUSD = DefaultSUPSUSDCents
+	}
+	payload, err := json.Marshal(price)
+	if err != nil {
+		return fmt.Errorf("encode price: %w", err)
 	}
-	q := `INSERT INTO prices (sups_price_cents, eth_price_cents, bnb_price_cents) VALUES ($1, $2, $3)`
-	_, err := conn.Exec(context.TODO(), q, price.SUPSUSD, price.ETHUSD, price.BNBUSD)
+	err = pgx.BeginFunc(context.TODO(), conn, func(tx pgx.Tx) error {
+		err := LockStreamEvents(context.TODO(), tx)
+		if err != nil {
+			return err
+		}
+		q := `INSERT INTO prices (sups_price_cents, eth_price_cents, bnb_price_cents) VALUES ($1, $2, $3)`
+		_, err = tx.Exec(context.TODO(), q, price.SUPSUSD, price.ETHUSD, price.BNBUSD)
+		if err != nil {
+			return err
+		}
+		return InsertStreamEvents(context.TODO(), tx, []*StreamEvent{{Kind: StreamEventPrice, Payload: payload}})
+	})
 	if err != nil {
 		return fmt.Errorf("add price: %w", err)
 	}
 	return nil
 }
-func AddTransfer(transfer *Transfer) error {
-	q := `INSERT INTO transfers	(block, log_index, chain_id, contract, symbol, decimals, tx_id, from_address, to_address, amount, timestamp) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)`
-
-	_, err := conn.Exec(context.TODO(), q,
-		transfer.Block,
-		transfer.LogIndex,
-		transfer.ChainID,
-		transfer.Contract.Hex(),
-		transfer.Symbol,
-		transfer.Decimals,
-		transfer.TxID.Hex(),
-		transfer.FromAddress.Hex(),
-		transfer.ToAddress.Hex(),
-		transfer.Amount.String(),
-		transfer.CreatedAt,
+
+// PriceAt returns the last prices recorded at or before at, or nil when there are none.
+func PriceAt(ctx context.Context, at time.Time) (*PriceResponse, error) {
+	q := `SELECT sups_price_cents, eth_price_cents, bnb_price_cents, created_at FROM prices WHERE created_at <= $1 ORDER BY created_at DESC LIMIT 1`
+	var createdAt time.Time
+	result := &PriceResponse{}
+	err := conn.QueryRow(ctx, q, at).Scan(&result.SUPSUSD, &result.ETHUSD, &result.BNBUSD, &createdAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("get price at %s: %w", at, err)
+	}
+	result.Time = createdAt.Unix()
+	return result, nil
+}
+
+const insertTransferQuery = `INSERT INTO transfers (block, log_index, chain_id, contract, symbol, decimals, tx_id, from_address, to_address, amount, timestamp, block_hash) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12) ON CONFLICT (tx_id, log_index, block) DO NOTHING`
+
+// InsertTransfers batches transfers into tx, skipping rows that are already indexed,
+// applies the rows actually inserted to the balances and the open payment intents,
+// and writes their webhook deliveries and stream events. It returns those rows.
+func InsertTransfers(ctx context.Context, tx pgx.Tx, transfers []*Transfer) ([]*Transfer, error) {
+	if len(transfers) == 0 {
+		return nil, nil
+	}
+	batch := &pgx.Batch{}
+	for _, transfer := range transfers {
+		batch.Queue(insertTransferQuery,
+			transfer.Block,
+			transfer.LogIndex,
+			transfer.ChainID,
+			transfer.Contract.Hex(),
+			transfer.Symbol,
+			transfer.Decimals,
+			transfer.TxID.Hex(),
+			transfer.FromAddress.Hex(),
+			transfer.ToAddress.Hex(),
+			transfer.Amount.String(),
+			transfer.CreatedAt,
+			blockHash(transfer.BlockHash),
+		)
+	}
+	results := tx.SendBatch(ctx, batch)
+	defer results.Close()
+
+	inserted := []*Transfer{}
+	for _, transfer := range transfers {
+		tag, err := results.Exec()
+		if err != nil {
+			return nil, fmt.Errorf("insert transfer %s:%d: %w", transfer.TxID.Hex(), transfer.LogIndex, err)
+		}
+		if tag.RowsAffected() > 0 {
+			inserted = append(inserted, transfer)
+		}
+	}
+	err := results.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	err = ApplyBalanceDeltas(ctx, tx, BalanceDeltas(inserted, 1))
+	if err != nil {
+		return nil, fmt.Errorf("apply balances: %w", err)
+	}
+	_, err = MatchIntentTransfers(ctx, tx, inserted)
+	if err != nil {
+		return nil, fmt.Errorf("match intents: %w", err)
+	}
+	_, err = EnqueueWebhookDeliveries(ctx, tx, inserted)
+	if err != nil {
+		return nil, err
+	}
+	events := []*StreamEvent{}
+	for _, transfer := range inserted {
+		event, err := transferStreamEvent(transfer)
+		if err != nil {
+			return nil, fmt.Errorf("encode transfer event: %w", err)
+		}
+		events = append(events, event)
+	}
+	err = InsertStreamEvents(ctx, tx, events)
+	if err != nil {
+		return nil, err
+	}
+	return inserted, nil
+}
+
+const insertApprovalQuery = `INSERT INTO approvals (block, log_index, chain_id, contract, symbol, decimals, tx_id, owner_address, spender_address, amount, timestamp, block_hash) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12) ON CONFLICT (tx_id, log_index, block) DO NOTHING`
+
+// InsertApprovals batches approvals into tx, skipping rows that are already indexed.
+func InsertApprovals(ctx context.Context, tx pgx.Tx, approvals []*Approval) (int, error) {
+	if len(approvals) == 0 {
+		return 0, nil
+	}
+	batch := &pgx.Batch{}
+	for _, approval := range approvals {
+		batch.Queue(insertApprovalQuery,
+			approval.Block,
+			approval.LogIndex,
+			approval.ChainID,
+			approval.Contract.Hex(),
+			approval.Symbol,
+			approval.Decimals,
+			approval.TxID.Hex(),
+			approval.OwnerAddress.Hex(),
+			approval.SpenderAddress.Hex(),
+			approval.Amount.String(),
+			approval.CreatedAt,
+			blockHash(approval.BlockHash),
+		)
+	}
+	results := tx.SendBatch(ctx, batch)
+	defer results.Close()
+
+	total := 0
+	for _, approval := range approvals {
+		tag, err := results.Exec()
+		if err != nil {
+			return 0, fmt.Errorf("insert approval %s:%d: %w", approval.TxID.Hex(), approval.LogIndex, err)
+		}
+		total += int(tag.RowsAffected())
+	}
+	return total, results.Close()
+}
+
+// CommitTransfers writes transfers and approvals and advances the cursor key to lastBlock in a single transaction,
+// so a crash can never leave the cursor ahead of (or behind) the rows it covers.
+// Transfers and approvals already indexed inside scraped whose block is no longer canonical were orphaned by a reorg and are removed.
+// The cursor only moves if it is still at prevBlock, where the scrape started; otherwise nothing is written.
+func CommitTransfers(transfers []*Transfer, approvals []*Approval, scraped *ScrapedRange, key KVKey, prevBlock int, lastBlock int) (int, error) {
+	total := 0
+	err := pgx.BeginFunc(context.TODO(), conn, func(tx pgx.Tx) error {
+		err := LockStreamEvents(context.TODO(), tx)
+		if err != nil {
+			return err
+		}
+		if scraped != nil {
+			orphaned, err := DeleteOrphanedTransfers(context.TODO(), tx, scraped)
+			if err != nil {
+				return err
+			}
+			if orphaned > 0 {
+				log.Warn().
+					Int64("chain_id", scraped.ChainID).
+					Str("symbol", scraped.Symbol).
+					Int64("from_block", scraped.FromBlock).
+					Int64("to_block", scraped.ToBlock).
+					Int("orphaned", orphaned).
+					Msg("removed reorged transfers and approvals")
+			}
+		}
+		inserted, err := InsertTransfers(context.TODO(), tx, transfers)
+		if err != nil {
+			return err
+		}
+		total = len(inserted)
+		_, err = InsertApprovals(context.TODO(), tx, approvals)
+		if err != nil {
+			return err
+		}
+		tag, err := tx.Exec(context.TODO(), `UPDATE kv SET value = $2 WHERE key = $1 AND value = $3`, key, strconv.Itoa(lastBlock), strconv.Itoa(prevBlock))
+		if err != nil {
+			return fmt.Errorf("set cursor %s: %w", key, err)
+		}
+		if tag.RowsAffected() == 0 {
+			return fmt.Errorf("set cursor %s: %w", key, ErrCursorMoved)
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("commit transfers: %w", err)
+	}
+	return total, nil
+}
+
+// ScrapedRange is the inclusive block range a scrape covered for one asset.
+type ScrapedRange struct {
+	ChainID   int64
+	Symbol    string
+	FromBlock int64
+	ToBlock   int64
+	// Canonical is the canonical block hash at the heights of the range that have indexed transfers or approvals.
+	Canonical map[uint64]common.Hash
+}
+
+func blockHash(hash common.Hash) *string {
+	if hash == (common.Hash{}) {
+		return nil
+	}
+	result := hash.Hex()
+	return &result
+}
+
+// IndexedBlocks returns the heights inside scraped that have indexed transfers or approvals with a stored block hash.
+func IndexedBlocks(ctx context.Context, scraped *ScrapedRange) ([]uint64, error) {
+	q := `SELECT block FROM transfers WHERE chain_id = $1 AND symbol = $2 AND block >= $3 AND block <= $4 AND block_hash IS NOT NULL
+	UNION
+	SELECT block FROM approvals WHERE chain_id = $1 AND symbol = $2 AND block >= $3 AND block <= $4 AND block_hash IS NOT NULL
+	ORDER BY block`
+	result := []uint64{}
+	err := pgxscan.Select(ctx, conn, &result, q, scraped.ChainID, scraped.Symbol, scraped.FromBlock, scraped.ToBlock)
+	if err != nil {
+		return nil, fmt.Errorf("get indexed blocks: %w", err)
+	}
+	return result, nil
+}
+
+// Orphaned reports whether a row indexed at block with the stored hash belongs to a block that is no longer canonical.
+// Rows without a stored hash, or at a height missing from Canonical, are never orphaned.
+func (s *ScrapedRange) Orphaned(block uint64, hash *string) bool {
+	if hash == nil {
+		return false
+	}
+	canonical, ok := s.Canonical[block]
+	return ok && common.HexToHash(*hash) != canonical
+}
+
+// OrphanedTransfers picks the records of indexed that scraped shows were reorged out.
+func (s *ScrapedRange) OrphanedTransfers(indexed []*TransferRecord) []*TransferRecord {
+	result := []*TransferRecord{}
+	for _, record := range indexed {
+		if s.Orphaned(record.Block, record.BlockHash) {
+			result = append(result, record)
+		}
+	}
+	return result
+}
+
+// OrphanedApprovals picks the records of indexed that scraped shows were reorged out.
+func (s *ScrapedRange) OrphanedApprovals(indexed []*ApprovalRecord) []*ApprovalRecord {
+	result := []*ApprovalRecord{}
+	for _, record := range indexed {
+		if s.Orphaned(record.Block, record.BlockHash) {
+			result = append(result, record)
+		}
+	}
+	return result
+}
+
+// DeleteOrphanedTransfers removes the transfers and approvals indexed inside scraped whose stored block hash differs from
+// the canonical one at their height, reversing the effect of the transfers on the balances and payment intents.
+// Rows a scrape merely didn't return are kept: a node that is behind or drops logs isn't a reorg.
+// Rows without a stored hash, or at a height missing from scraped.Canonical, are kept too.
+// It returns the number of rows removed.
+func DeleteOrphanedTransfers(ctx context.Context, tx pgx.Tx, scraped *ScrapedRange) (int, error) {
+	if len(scraped.Canonical) == 0 {
+		return 0, nil
+	}
+	q := `SELECT * FROM approvals WHERE chain_id = $1 AND symbol = $2 AND block >= $3 AND block <= $4 AND block_hash IS NOT NULL`
+	approvals := []*ApprovalRecord{}
+	err := pgxscan.Select(ctx, tx, &approvals, q, scraped.ChainID, scraped.Symbol, scraped.FromBlock, scraped.ToBlock)
+	if err != nil {
+		return 0, fmt.Errorf("get indexed approvals: %w", err)
+	}
+	approvalIDs := []uuid.UUID{}
+	for _, record := range scraped.OrphanedApprovals(approvals) {
+		approvalIDs = append(approvalIDs, record.ID)
+	}
+	if len(approvalIDs) > 0 {
+		_, err = tx.Exec(ctx, `DELETE FROM approvals WHERE id = ANY($1)`, approvalIDs)
+		if err != nil {
+			return 0, fmt.Errorf("delete orphaned approvals: %w", err)
+		}
+	}
+
+	q = `SELECT * FROM transfers WHERE chain_id = $1 AND symbol = $2 AND block >= $3 AND block <= $4 AND block_hash IS NOT NULL`
+	indexed := []*TransferRecord{}
+	err = pgxscan.Select(ctx, tx, &indexed, q, scraped.ChainID, scraped.Symbol, scraped.FromBlock, scraped.ToBlock)
+	if err != nil {
+		return 0, fmt.Errorf("get indexed transfers: %w", err)
+	}
+
+	orphaned := []*Transfer{}
+	ids := []uuid.UUID{}
+	for _, record := range scraped.OrphanedTransfers(indexed) {
+		ids = append(ids, record.ID)
+		orphaned = append(orphaned, record.Transfer())
+	}
+	if len(ids) == 0 {
+		return len(approvalIDs), nil
+	}
+
+	err = UnmatchIntentTransfers(ctx, tx, ids)
+	if err != nil {
+		return 0, err
+	}
+	err = CancelWebhookDeliveries(ctx, tx, ids)
+	if err != nil {
+		return 0, err
+	}
+	_, err = tx.Exec(ctx, `DELETE FROM transfers WHERE id = ANY($1)`, ids)
+	if err != nil {
+		return 0, fmt.Errorf("delete orphaned transfers: %w", err)
+	}
+	err = ApplyBalanceDeltas(ctx, tx, BalanceDeltas(orphaned, -1))
+	if err != nil {
+		return 0, fmt.Errorf("reverse balances: %w", err)
+	}
+	return len(ids) + len(approvalIDs), nil
+}
+
+// AddTransfers writes transfers in a single transaction without touching any cursor.
+func AddTransfers(transfers []*Transfer) (int, error) {
+	total := 0
+	err := pgx.BeginFunc(context.TODO(), conn, func(tx pgx.Tx) error {
+		err := LockStreamEvents(context.TODO(), tx)
+		if err != nil {
+			return err
+		}
+		inserted, err := InsertTransfers(context.TODO(), tx, transfers)
+		total = len(inserted)
+		return err
+	})
+	if err != nil {
+		return 0, fmt.Errorf("add transfers: %w", err)
+	}
+	return total, nil
+}
+
+const intentColumns = `id, reference, chain_id, symbol, decimals, payer_address, recipient_address, min_amount, max_amount, received, status, expires_at, paid_at, created_at, updated_at`
+
+// CreateIntent stores a new pending payment intent and returns it with its ID.
+func CreateIntent(intent *PaymentIntent) (*PaymentIntent, error) {
+	q := `INSERT INTO payment_intents (reference, chain_id, symbol, decimals, payer_address, recipient_address, min_amount, max_amount, expires_at)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9) RETURNING ` + intentColumns
+	result := &PaymentIntent{}
+	err := pgxscan.Get(context.TODO(), conn, result, q,
+		intent.Reference,
+		intent.ChainID,
+		intent.Symbol,
+		intent.Decimals,
+		intent.PayerAddress,
+		intent.RecipientAddress,
+		intent.MinAmount.String(),
+		intent.MaxAmount.String(),
+		intent.ExpiresAt,
 	)
 	if err != nil {
-		return fmt.Errorf("insert transfer: %w", err)
+		return nil, fmt.Errorf("create intent: %w", err)
+	}
+	return result, nil
+}
+
+// Intent returns a payment intent, or nil when there is none with that ID.
+func Intent(id uuid.UUID) (*PaymentIntent, error) {
+	result := &PaymentIntent{}
+	err := pgxscan.Get(context.TODO(), conn, result, `SELECT `+intentColumns+` FROM payment_intents WHERE id = $1`, id)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("get intent: %w", err)
+	}
+	return result, nil
+}
+
+// IntentFilter narrows Intents down. Zero fields match everything.
+type IntentFilter struct {
+	ChainID   int64
+	Payer     string
+	Status    IntentStatus
+	Reference string
+	Limit     int
+}
+
+// Intents returns the newest payment intents matching filter.
+func Intents(filter IntentFilter) ([]*PaymentIntent, error) {
+	q := `SELECT ` + intentColumns + ` FROM payment_intents WHERE TRUE`
+	args := []interface{}{}
+	arg := func(value interface{}) string {
+		args = append(args, value)
+		return fmt.Sprintf("$%d", len(args))
+	}
+	if filter.ChainID != 0 {
+		q += ` AND chain_id = ` + arg(filter.ChainID)
+	}
+	if filter.Payer != "" {
+		q += ` AND payer_address = ` + arg(filter.Payer)
+	}
+	if filter.Status != "" {
+		q += ` AND status = ` + arg(string(filter.Status))
+	}
+	if filter.Reference != "" {
+		q += ` AND reference = ` + arg(filter.Reference)
+	}
+	q += ` ORDER BY created_at DESC LIMIT ` + arg(filter.Limit)
+	result := []*PaymentIntent{}
+	err := pgxscan.Select(context.TODO(), conn, &result, q, args...)
+	if err != nil {
+		return nil, fmt.Errorf("get intents: %w", err)
+	}
+	return result, nil
+}
+
+// IntentTransfers returns the transfers that paid towards an intent, in chain order.
+func IntentTransfers(id uuid.UUID) ([]*TransferRecord, error) {
+	q := `SELECT transfers.* FROM transfers JOIN payment_intent_transfers ON payment_intent_transfers.transfer_id = transfers.id
+	WHERE payment_intent_transfers.intent_id = $1 ORDER BY transfers.block, transfers.log_index`
+	result := []*TransferRecord{}
+	err := pgxscan.Select(context.TODO(), conn, &result, q, id)
+	if err != nil {
+		return nil, fmt.Errorf("get intent transfers: %w", err)
+	}
+	return result, nil
+}
+
+const updateIntentQuery = `UPDATE payment_intents SET received = $2, status = $3, paid_at = $4, updated_at = NOW() WHERE id = $1`
+
+// MatchIntentTransfers matches freshly inserted transfers to the payment intents of their payers inside tx,
+// recording which transfer paid which intent and updating the intents. It returns the number of transfers matched.
+func MatchIntentTransfers(ctx context.Context, tx pgx.Tx, transfers []*Transfer) (int, error) {
+	if len(transfers) == 0 {
+		return 0, nil
+	}
+	chainIDs := []int64{}
+	payers := []string{}
+	for _, transfer := range transfers {
+		chainIDs = append(chainIDs, transfer.ChainID)
+		payers = append(payers, transfer.FromAddress.Hex())
+	}
+	q := `SELECT ` + intentColumns + ` FROM payment_intents WHERE status <> $1 AND chain_id = ANY($2) AND payer_address = ANY($3) ORDER BY created_at FOR UPDATE`
+	intents := []*PaymentIntent{}
+	err := pgxscan.Select(ctx, tx, &intents, q, string(IntentPaid), chainIDs, payers)
+	if err != nil {
+		return 0, fmt.Errorf("get open intents: %w", err)
+	}
+	if len(intents) == 0 {
+		return 0, nil
+	}
+
+	matches := MatchIntents(intents, transfers, time.Now())
+	if len(matches) == 0 {
+		return 0, nil
+	}
+	batch := &pgx.Batch{}
+	updated := map[uuid.UUID]*PaymentIntent{}
+	for _, match := range matches {
+		batch.Queue(`INSERT INTO payment_intent_transfers (intent_id, transfer_id, amount)
+		SELECT $1, id, amount FROM transfers WHERE tx_id = $2 AND log_index = $3 AND block = $4`,
+			match.Intent.ID,
+			match.Transfer.TxID.Hex(),
+			match.Transfer.LogIndex,
+			match.Transfer.Block,
+		)
+		updated[match.Intent.ID] = match.Intent
+	}
+	for _, intent := range updated {
+		batch.Queue(updateIntentQuery, intent.ID, intent.Received.String(), string(intent.Status), intent.PaidAt)
+	}
+	err = tx.SendBatch(ctx, batch).Close()
+	if err != nil {
+		return 0, fmt.Errorf("update intents: %w", err)
+	}
+	return len(matches), nil
+}
+
+// UnmatchIntentTransfers detaches transfers about to be deleted from the intents they paid towards
+// and recomputes those intents from the transfers they have left.
+func UnmatchIntentTransfers(ctx context.Context, tx pgx.Tx, transferIDs []uuid.UUID) error {
+	ids := []uuid.UUID{}
+	err := pgxscan.Select(ctx, tx, &ids, `DELETE FROM payment_intent_transfers WHERE transfer_id = ANY($1) RETURNING intent_id`, transferIDs)
+	if err != nil {
+		return fmt.Errorf("detach intent transfers: %w", err)
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+	q := `SELECT ` + intentColumns + ` FROM payment_intents WHERE id = ANY($1) FOR UPDATE`
+	intents := []*PaymentIntent{}
+	err = pgxscan.Select(ctx, tx, &intents, q, ids)
+	if err != nil {
+		return fmt.Errorf("get unmatched intents: %w", err)
+	}
+	batch := &pgx.Batch{}
+	for _, intent := range intents {
+		received := decimal.Zero
+		err = pgxscan.Get(ctx, tx, &received, `SELECT COALESCE(SUM(amount), 0) FROM payment_intent_transfers WHERE intent_id = $1`, intent.ID)
+		if err != nil {
+			return fmt.Errorf("sum intent transfers: %w", err)
+		}
+		intent.Recount(received, time.Now())
+		batch.Queue(updateIntentQuery, intent.ID, intent.Received.String(), string(intent.Status), intent.PaidAt)
+	}
+	err = tx.SendBatch(ctx, batch).Close()
+	if err != nil {
+		return fmt.Errorf("update unmatched intents: %w", err)
 	}
 	return nil
+}
+
+// ExpireIntents marks the open intents past their expiry as expired and returns how many it marked.
+func ExpireIntents(ctx context.Context) (int, error) {
+	q := `UPDATE payment_intents SET status = $1, updated_at = NOW() WHERE status IN ($2, $3) AND expires_at < NOW()`
+	tag, err := conn.Exec(ctx, q, string(IntentExpired), string(IntentPending), string(IntentUnderpaid))
+	if err != nil {
+		return 0, fmt.Errorf("expire intents: %w", err)
+	}
+	return int(tag.RowsAffected()), nil
+}
 
+const webhookColumns = `id, url, secret, chain_id, symbol, address, min_confirmations, created_at, deleted_at`
+const deliveryColumns = `id, webhook_id, transfer_id, event, chain_id, block, payload, status, attempts, next_attempt_at, last_status_code, last_error, delivered_at, created_at`
+
+func CreateWebhook(webhook *Webhook) (*Webhook, error) {
+	q := `INSERT INTO webhooks (url, secret, chain_id, symbol, address, min_confirmations) VALUES ($1, $2, $3, $4, $5, $6) RETURNING ` + webhookColumns
+	result := &Webhook{}
+	err := pgxscan.Get(context.TODO(), conn, result, q, webhook.URL, webhook.Secret, webhook.ChainID, webhook.Symbol, webhook.Address, webhook.MinConfirmations)
+	if err != nil {
+		return nil, fmt.Errorf("create webhook: %w", err)
+	}
+	return result, nil
 }
-func Transfers(symbol string, blockHeight int, sinceBlock int, chainID int) ([]*TransferAPIResponse, error) {
-	q := `SELECT * FROM transfers WHERE block > $1 AND chain_id = $2 AND symbol = $3 ORDER BY block DESC`
-	resultDB := []*TransferRecord{}
-	err := pgxscan.Select(context.TODO(), conn, &resultDB, q, sinceBlock, chainID, strings.ToUpper(symbol))
-	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
-		return nil, fmt.Errorf("set block: %w", err)
+
+// Webhooks returns the webhooks that weren't deleted.
+func Webhooks() ([]*Webhook, error) {
+	result := []*Webhook{}
+	err := pgxscan.Select(context.TODO(), conn, &result, `SELECT `+webhookColumns+` FROM webhooks WHERE deleted_at IS NULL ORDER BY created_at`)
+	if err != nil {
+		return nil, fmt.Errorf("get webhooks: %w", err)
+	}
+	return result, nil
+}
+
+// WebhookByID returns a webhook that wasn't deleted, or nil.
+func WebhookByID(id uuid.UUID) (*Webhook, error) {
+	result := &Webhook{}
+	err := pgxscan.Get(context.TODO(), conn, result, `SELECT `+webhookColumns+` FROM webhooks WHERE id = $1 AND deleted_at IS NULL`, id)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("get webhook: %w", err)
+	}
+	return result, nil
+}
+
+// DeleteWebhook marks a webhook deleted and cancels its pending deliveries. Its delivery log is kept.
+func DeleteWebhook(id uuid.UUID) (bool, error) {
+	deleted := false
+	err := pgx.BeginFunc(context.TODO(), conn, func(tx pgx.Tx) error {
+		tag, err := tx.Exec(context.TODO(), `UPDATE webhooks SET deleted_at = NOW() WHERE id = $1 AND deleted_at IS NULL`, id)
+		if err != nil {
+			return err
+		}
+		deleted = tag.RowsAffected() > 0
+		_, err = tx.Exec(context.TODO(), `UPDATE webhook_deliveries SET status = $2 WHERE webhook_id = $1 AND status = $3`, id, string(DeliveryCancelled), string(DeliveryPending))
+		return err
+	})
+	if err != nil {
+		return false, fmt.Errorf("delete webhook: %w", err)
+	}
+	return deleted, nil
+}
+
+// EnqueueWebhookDeliveries writes a delivery to the outbox inside tx for every webhook matching
+// each freshly inserted transfer. It returns the number of deliveries written.
+func EnqueueWebhookDeliveries(ctx context.Context, tx pgx.Tx, transfers []*Transfer) (int, error) {
+	if len(transfers) == 0 {
+		return 0, nil
+	}
+	webhooks := []*Webhook{}
+	err := pgxscan.Select(ctx, tx, &webhooks, `SELECT `+webhookColumns+` FROM webhooks WHERE deleted_at IS NULL`)
+	if err != nil {
+		return 0, fmt.Errorf("get webhooks: %w", err)
+	}
+	if len(webhooks) == 0 {
+		return 0, nil
+	}
+
+	q := `INSERT INTO webhook_deliveries (webhook_id, transfer_id, event, chain_id, block, payload)
+	SELECT $1, id, $2, chain_id, block, $3 FROM transfers WHERE tx_id = $4 AND log_index = $5 AND block = $6`
+	batch := &pgx.Batch{}
+	for _, transfer := range transfers {
+		var payload []byte
+		for _, webhook := range webhooks {
+			if !webhook.Matches(transfer) {
+				continue
+			}
+			if payload == nil {
+				payload, err = json.Marshal(transferPayload(transfer))
+				if err != nil {
+					return 0, fmt.Errorf("encode payload: %w", err)
+				}
+			}
+			batch.Queue(q, webhook.ID, WebhookEventTransfer, string(payload), transfer.TxID.Hex(), transfer.LogIndex, transfer.Block)
+		}
+	}
+	if batch.Len() == 0 {
+		return 0, nil
+	}
+	err = tx.SendBatch(ctx, batch).Close()
+	if err != nil {
+		return 0, fmt.Errorf("enqueue webhook deliveries: %w", err)
+	}
+	return batch.Len(), nil
+}
+
+// CancelWebhookDeliveries cancels the pending deliveries of transfers about to be deleted.
+func CancelWebhookDeliveries(ctx context.Context, tx pgx.Tx, transferIDs []uuid.UUID) error {
+	q := `UPDATE webhook_deliveries SET status = $2 WHERE transfer_id = ANY($1) AND status = $3`
+	_, err := tx.Exec(ctx, q, transferIDs, string(DeliveryCancelled), string(DeliveryPending))
+	if err != nil {
+		return fmt.Errorf("cancel webhook deliveries: %w", err)
+	}
+	return nil
+}
+
+// DueWebhookDeliveries returns up to limit pending deliveries on a chain that are due for an attempt
+// and whose block has the webhook's minimum confirmations at height, oldest first.
+func DueWebhookDeliveries(ctx context.Context, chainID int64, height uint64, limit int) ([]*DueWebhookDelivery, error) {
+	q := `SELECT d.id, d.webhook_id, d.transfer_id, d.event, d.chain_id, d.block, d.payload, d.status, d.attempts, d.next_attempt_at,
+		d.last_status_code, d.last_error, d.delivered_at, d.created_at, w.url, w.secret
+	FROM webhook_deliveries d JOIN webhooks w ON w.id = d.webhook_id
+	WHERE d.status = $1 AND d.chain_id = $2 AND d.next_attempt_at <= NOW() AND d.block + w.min_confirmations <= $3
+	ORDER BY d.next_attempt_at, d.created_at LIMIT $4`
+	result := []*DueWebhookDelivery{}
+	err := pgxscan.Select(ctx, conn, &result, q, string(DeliveryPending), chainID, height, limit)
+	if err != nil {
+		return nil, fmt.Errorf("get due webhook deliveries: %w", err)
+	}
+	return result, nil
+}
+
+// RecordWebhookAttempt logs an attempt and saves the resulting state of its delivery.
+func RecordWebhookAttempt(ctx context.Context, delivery *WebhookDelivery, attempt *WebhookAttempt) error {
+	err := pgx.BeginFunc(ctx, conn, func(tx pgx.Tx) error {
+		_, err := tx.Exec(ctx, `INSERT INTO webhook_attempts (delivery_id, attempt, status_code, error, duration_ms) VALUES ($1, $2, $3, $4, $5)`,
+			attempt.DeliveryID, attempt.Attempt, attempt.StatusCode, attempt.Error, attempt.DurationMS)
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(ctx, `UPDATE webhook_deliveries SET status = $2, attempts = $3, next_attempt_at = $4, last_status_code = $5, last_error = $6, delivered_at = $7 WHERE id = $1`,
+			delivery.ID, string(delivery.Status), delivery.Attempts, delivery.NextAttemptAt, delivery.LastStatusCode, delivery.LastError, delivery.DeliveredAt)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("record webhook attempt: %w", err)
+	}
+	return nil
+}
+
+// WebhookDeliveries returns the newest deliveries of a webhook, optionally only those with status.
+func WebhookDeliveries(webhookID uuid.UUID, status DeliveryStatus, limit int) ([]*WebhookDelivery, error) {
+	q := `SELECT ` + deliveryColumns + ` FROM webhook_deliveries WHERE webhook_id = $1 AND ($2 = '' OR status = $2) ORDER BY created_at DESC LIMIT $3`
+	result := []*WebhookDelivery{}
+	err := pgxscan.Select(context.TODO(), conn, &result, q, webhookID, string(status), limit)
+	if err != nil {
+		return nil, fmt.Errorf("get webhook deliveries: %w", err)
+	}
+	return result, nil
+}
+
+// WebhookDeliveryByID returns a delivery of a webhook, or nil.
+func WebhookDeliveryByID(webhookID uuid.UUID, id uuid.UUID) (*WebhookDelivery, error) {
+	result := &WebhookDelivery{}
+	err := pgxscan.Get(context.TODO(), conn, result, `SELECT `+deliveryColumns+` FROM webhook_deliveries WHERE webhook_id = $1 AND id = $2`, webhookID, id)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("get webhook delivery: %w", err)
+	}
+	return result, nil
+}
+
+func WebhookAttempts(deliveryID uuid.UUID) ([]*WebhookAttempt, error) {
+	result := []*WebhookAttempt{}
+	err := pgxscan.Select(context.TODO(), conn, &result, `SELECT delivery_id, attempt, status_code, error, duration_ms, created_at FROM webhook_attempts WHERE delivery_id = $1 ORDER BY attempt`, deliveryID)
+	if err != nil {
+		return nil, fmt.Errorf("get webhook attempts: %w", err)
+	}
+	return result, nil
+}
+
+// RedeliverWebhook queues a dead or delivered delivery to be sent again straight away, with a fresh set of attempts.
+func RedeliverWebhook(webhookID uuid.UUID, id uuid.UUID) (bool, error) {
+	q := `UPDATE webhook_deliveries SET status = $3, attempts = 0, next_attempt_at = NOW() WHERE webhook_id = $1 AND id = $2 AND status IN ($4, $5)`
+	tag, err := conn.Exec(context.TODO(), q, webhookID, id, string(DeliveryPending), string(DeliveryDead), string(DeliveryDelivered))
+	if err != nil {
+		return false, fmt.Errorf("redeliver webhook: %w", err)
+	}
+	return tag.RowsAffected() > 0, nil
+}
+
+const rescanColumns = `id, chain_id, symbol, from_block, to_block, addresses, reason, status, error, attempts, created_at, started_at, finished_at`
+
+// InsertRescans queues rescans inside tx.
+func InsertRescans(ctx context.Context, tx pgx.Tx, rescans []*Rescan) error {
+	if len(rescans) == 0 {
+		return nil
+	}
+	q := `INSERT INTO rescans (chain_id, symbol, from_block, to_block, addresses, reason) VALUES ($1, $2, $3, $4, $5, $6) RETURNING id, status, created_at`
+	for _, rescan := range rescans {
+		err := tx.QueryRow(ctx, q, rescan.ChainID, rescan.Symbol, rescan.FromBlock, rescan.ToBlock, rescan.Addresses, rescan.Reason).Scan(&rescan.ID, &rescan.Status, &rescan.CreatedAt)
+		if err != nil {
+			return fmt.Errorf("insert rescan: %w", err)
+		}
+	}
+	return nil
+}
+
+// NextRescan marks the oldest unfinished rescan running and returns it, or nil when there is none.
+func NextRescan(ctx context.Context) (*Rescan, error) {
+	q := `UPDATE rescans SET status = $1, started_at = COALESCE(started_at, NOW())
+	WHERE id = (SELECT id FROM rescans WHERE status IN ($1, $2) ORDER BY created_at LIMIT 1)
+	RETURNING ` + rescanColumns
+	result := &Rescan{}
+	err := pgxscan.Get(ctx, conn, result, q, string(RescanRunning), string(RescanPending))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("get next rescan: %w", err)
 	}
+	return result, nil
+}
 
-	result := []*TransferAPIResponse{}
+// UpdateRescan saves the status, error and attempts of a running rescan, and when it finished unless it is still running.
+// A rescan cancelled while it ran stays cancelled.
+func UpdateRescan(ctx context.Context, rescan *Rescan) error {
+	q := `UPDATE rescans SET status = $2, error = $3, attempts = $4, finished_at = CASE WHEN $2 <> $5 THEN NOW() END WHERE id = $1 AND status = $5`
+	_, err := conn.Exec(ctx, q, rescan.ID, string(rescan.Status), rescan.Error, rescan.Attempts, string(RescanRunning))
+	if err != nil {
+		return fmt.Errorf("update rescan: %w", err)
+	}
+	return nil
+}
 
-	for _, record := range resultDB {
-		confirmations := blockHeight - int(record.Block)
-		result = append(result, &TransferAPIResponse{
-			TxHash:          record.TxID,
-			LogIndex:        record.LogIndex,
-			Time:            record.CreatedAt.Unix(),
-			Chain:           record.ChainID,
-			BlockNumber:     record.Block,
-			Confirmations:   confirmations,
-			FromAddress:     record.FromAddress,
-			ToAddress:       record.ToAddress,
-			ContractAddress: record.Contract,
-			Value:           record.Amount.Shift(-18).String(),
-			ValueInt:        record.Amount.String(),
-			Timestamp:       record.Timestamp,
-			ValueDecimals:   record.Decimals,
-			Symbol:          record.Symbol,
-		})
+// CancelRescan cancels a pending or running rescan and records it in admin_audit in the same transaction.
+// A running rescan stops after its current run. It returns nil when there is no unfinished rescan with that ID.
+func CancelRescan(ctx context.Context, actor string, id uuid.UUID) (*Rescan, error) {
+	var result *Rescan
+	err := pgx.BeginFunc(ctx, conn, func(tx pgx.Tx) error {
+		rescans := []*Rescan{}
+		err := pgxscan.Select(ctx, tx, &rescans, `SELECT `+rescanColumns+` FROM rescans WHERE id = $1 AND status IN ($2, $3) FOR UPDATE`, id, string(RescanPending), string(RescanRunning))
+		if err != nil {
+			return err
+		}
+		if len(rescans) == 0 {
+			return nil
+		}
+		result = rescans[0]
+		old := string(result.Status)
+		err = pgxscan.Get(ctx, tx, result, `UPDATE rescans SET status = $2, finished_at = NOW() WHERE id = $1 RETURNING `+rescanColumns, id, string(RescanCancelled))
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(ctx, insertAuditQuery, actor, AuditCancelRescan, "rescan_"+id.String(), old, string(RescanCancelled))
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("cancel rescan: %w", err)
+	}
+	return result, nil
+}
 
+// Rescans returns the newest rescans, only those with status when it is set.
+func Rescans(ctx context.Context, status RescanStatus, limit int) ([]*Rescan, error) {
+	q := `SELECT ` + rescanColumns + ` FROM rescans WHERE ($1 = '' OR status = $1) ORDER BY created_at DESC LIMIT $2`
+	result := []*Rescan{}
+	err := pgxscan.Select(ctx, conn, &result, q, string(status), limit)
+	if err != nil {
+		return nil, fmt.Errorf("get rescans: %w", err)
 	}
 	return result, nil
 }
 
+// StreamEventsLockKey is the advisory lock that serializes the transactions writing stream events.
+const StreamEventsLockKey = 7359165
+
+// LockStreamEvents holds StreamEventsLockKey until tx ends. Event IDs are taken from a sequence when the rows
+// are inserted, not when they commit, so without it a slow transaction could commit an ID below one the
+// broker already polled past, and the event would never be sent. Holding the lock from the insert until the
+// commit makes IDs become visible in order. Transactions that write events take it before anything else,
+// so they can't deadlock on row locks taken meanwhile.
+func LockStreamEvents(ctx context.Context, tx pgx.Tx) error {
+	_, err := tx.Exec(ctx, `SELECT pg_advisory_xact_lock($1)`, StreamEventsLockKey)
+	if err != nil {
+		return fmt.Errorf("lock stream events: %w", err)
+	}
+	return nil
+}
+
+// InsertStreamEvents appends events to the stream_events log inside tx, holding LockStreamEvents until it ends.
+func InsertStreamEvents(ctx context.Context, tx pgx.Tx, events []*StreamEvent) error {
+	if len(events) == 0 {
+		return nil
+	}
+	err := LockStreamEvents(ctx, tx)
+	if err != nil {
+		return err
+	}
+	q := `INSERT INTO stream_events (kind, chain_id, from_address, to_address, payload) VALUES ($1, $2, $3, $4, $5)`
+	batch := &pgx.Batch{}
+	for _, event := range events {
+		batch.Queue(q, event.Kind, event.ChainID, event.FromAddress, event.ToAddress, string(event.Payload))
+	}
+	err = tx.SendBatch(ctx, batch).Close()
+	if err != nil {
+		return fmt.Errorf("insert stream events: %w", err)
+	}
+	return nil
+}
+
+func LatestStreamEventID(ctx context.Context) (int64, error) {
+	result := int64(0)
+	err := pgxscan.Get(ctx, conn, &result, `SELECT COALESCE(MAX(id), 0) FROM stream_events`)
+	if err != nil {
+		return 0, fmt.Errorf("get latest stream event: %w", err)
+	}
+	return result, nil
+}
+
+// StreamEventsAfter returns up to limit logged events after id, oldest first, matching filter unless it is nil.
+func StreamEventsAfter(ctx context.Context, id int64, filter *StreamFilter, limit int) ([]*StreamEvent, error) {
+	q := `SELECT id, kind, chain_id, from_address, to_address, payload, created_at FROM stream_events WHERE id > $1`
+	args := []interface{}{id}
+	if filter != nil {
+		kinds := []string{}
+		if filter.Prices {
+			kinds = append(kinds, StreamEventPrice)
+		}
+		if filter.Transfers {
+			kinds = append(kinds, StreamEventTransfer)
+		}
+		addrs := []string{}
+		for _, addr := range filter.Addresses {
+			addrs = append(addrs, addr.Hex())
+		}
+		q += ` AND kind = ANY($2) AND (kind <> $3 OR (
+			(cardinality($4::bigint[]) = 0 OR chain_id = ANY($4)) AND
+			(cardinality($5::text[]) = 0 OR from_address = ANY($5) OR to_address = ANY($5))))`
+		args = append(args, kinds, StreamEventTransfer, append([]int64{}, filter.Chains...), addrs)
+	}
+	q += fmt.Sprintf(` ORDER BY id LIMIT %d`, limit)
+	result := []*StreamEvent{}
+	err := pgxscan.Select(ctx, conn, &result, q, args...)
+	if err != nil {
+		return nil, fmt.Errorf("get stream events: %w", err)
+	}
+	return result, nil
+}
+
+// PruneStreamEvents deletes the events logged before a time. Clients can't resume from before it.
+func PruneStreamEvents(ctx context.Context, before time.Time) (int, error) {
+	tag, err := conn.Exec(ctx, `DELETE FROM stream_events WHERE created_at < $1`, before)
+	if err != nil {
+		return 0, fmt.Errorf("prune stream events: %w", err)
+	}
+	return int(tag.RowsAffected()), nil
+}
+
+// ApplyBalanceDeltas adds deltas to the balances inside tx. Rows are updated in a fixed order
+// so concurrent transactions touching the same addresses can't deadlock.
+func ApplyBalanceDeltas(ctx context.Context, tx pgx.Tx, deltas map[BalanceKey]*BalanceDelta) error {
+	if len(deltas) == 0 {
+		return nil
+	}
+	keys := []BalanceKey{}
+	for key := range deltas {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].ChainID != keys[j].ChainID {
+			return keys[i].ChainID < keys[j].ChainID
+		}
+		if keys[i].Symbol != keys[j].Symbol {
+			return keys[i].Symbol < keys[j].Symbol
+		}
+		return keys[i].Address.Hex() < keys[j].Address.Hex()
+	})
+
+	q := `INSERT INTO balances (chain_id, symbol, address, balance, updated_block) VALUES ($1, $2, $3, $4, $5)
+	ON CONFLICT (chain_id, symbol, address) DO UPDATE SET balance = balances.balance + EXCLUDED.balance, updated_block = GREATEST(balances.updated_block, EXCLUDED.updated_block)`
+	batch := &pgx.Batch{}
+	for _, key := range keys {
+		delta := deltas[key]
+		batch.Queue(q, key.ChainID, key.Symbol, key.Address.Hex(), delta.Amount.String(), delta.Block)
+	}
+	err := tx.SendBatch(ctx, batch).Close()
+	if err != nil {
+		return fmt.Errorf("update balances: %w", err)
+	}
+	return nil
+}
+
+// RebuildBalances recomputes the balances of a token on a chain from its transfers.
+// The balances table is locked first, so transfers committed concurrently are either
+// included in the rebuild or applied on top of it once it commits.
+func RebuildBalances(chainID int64, symbol string) (int, error) {
+	total := 0
+	err := pgx.BeginFunc(context.TODO(), conn, func(tx pgx.Tx) error {
+		_, err := tx.Exec(context.TODO(), `LOCK TABLE balances IN EXCLUSIVE MODE`)
+		if err != nil {
+			return fmt.Errorf("lock balances: %w", err)
+		}
+		_, err = tx.Exec(context.TODO(), `DELETE FROM balances WHERE chain_id = $1 AND symbol = $2`, chainID, symbol)
+		if err != nil {
+			return fmt.Errorf("clear balances: %w", err)
+		}
+		q := `INSERT INTO balances (chain_id, symbol, address, balance, updated_block)
+		SELECT $1, $2, address, SUM(delta), MAX(block) FROM (
+			SELECT to_address AS address, amount AS delta, block FROM transfers WHERE chain_id = $1 AND symbol = $2 AND contract <> $3
+			UNION ALL
+			SELECT from_address AS address, -amount AS delta, block FROM transfers WHERE chain_id = $1 AND symbol = $2 AND contract <> $3
+		) deltas WHERE address <> $3 GROUP BY address`
+		tag, err := tx.Exec(context.TODO(), q, chainID, symbol, common.Address{}.Hex())
+		if err != nil {
+			return fmt.Errorf("sum transfers: %w", err)
+		}
+		total = int(tag.RowsAffected())
+		return nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("rebuild balances: %w", err)
+	}
+	return total, nil
+}
+
+// Holders returns the limit largest positive balances of a token and the number of addresses holding any.
+func Holders(chainID int64, symbol string, limit int) ([]*BalanceRecord, int, error) {
+	q := `SELECT * FROM balances WHERE chain_id = $1 AND symbol = $2 AND balance > 0 ORDER BY balance DESC, address LIMIT $3`
+	result := []*BalanceRecord{}
+	err := pgxscan.Select(context.TODO(), conn, &result, q, chainID, symbol, limit)
+	if err != nil {
+		return nil, 0, fmt.Errorf("get holders: %w", err)
+	}
+	count := 0
+	err = pgxscan.Get(context.TODO(), conn, &count, `SELECT COUNT(*) FROM balances WHERE chain_id = $1 AND symbol = $2 AND balance > 0`, chainID, symbol)
+	if err != nil {
+		return nil, 0, fmt.Errorf("count holders: %w", err)
+	}
+	return result, count, nil
+}
+
+// AddressBalances returns the non-zero balances of an address on every chain.
+func AddressBalances(addr common.Address) ([]*BalanceRecord, error) {
+	q := `SELECT * FROM balances WHERE address = $1 AND balance <> 0 ORDER BY chain_id, symbol`
+	result := []*BalanceRecord{}
+	err := pgxscan.Select(context.TODO(), conn, &result, q, addr.Hex())
+	if err != nil {
+		return nil, fmt.Errorf("get balances: %w", err)
+	}
+	return result, nil
+}
+
+// CompletedBackfillChunks returns the from blocks of the chunks of job that are already committed.
+func CompletedBackfillChunks(job string) (map[int64]bool, error) {
+	q := `SELECT from_block FROM backfill_chunks WHERE job = $1`
+	fromBlocks := []int64{}
+	err := pgxscan.Select(context.TODO(), conn, &fromBlocks, q, job)
+	if err != nil {
+		return nil, fmt.Errorf("get backfill chunks: %w", err)
+	}
+	result := map[int64]bool{}
+	for _, fromBlock := range fromBlocks {
+		result[fromBlock] = true
+	}
+	return result, nil
+}
+
+// CommitBackfillChunk writes the transfers and approvals of a chunk and marks it completed in a single transaction.
+func CommitBackfillChunk(job string, chunk BackfillChunk, transfers []*Transfer, approvals []*Approval) (int, error) {
+	total := 0
+	err := pgx.BeginFunc(context.TODO(), conn, func(tx pgx.Tx) error {
+		err := LockStreamEvents(context.TODO(), tx)
+		if err != nil {
+			return err
+		}
+		inserted, err := InsertTransfers(context.TODO(), tx, transfers)
+		if err != nil {
+			return err
+		}
+		total = len(inserted)
+		_, err = InsertApprovals(context.TODO(), tx, approvals)
+		if err != nil {
+			return err
+		}
+		q := `INSERT INTO backfill_chunks (job, from_block, to_block, transfers) VALUES ($1, $2, $3, $4) ON CONFLICT (job, from_block) DO NOTHING`
+		_, err = tx.Exec(context.TODO(), q, job, chunk.FromBlock, chunk.ToBlock, total)
+		if err != nil {
+			return fmt.Errorf("mark chunk: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("commit backfill chunk: %w", err)
+	}
+	return total, nil
+}
+
+// BlockTimestamps returns the stored timestamps of the given block hashes. Unknown hashes are left out.
+func BlockTimestamps(chainID int64, hashes []common.Hash) (map[common.Hash]uint64, error) {
+	hashStrs := []string{}
+	for _, hash := range hashes {
+		hashStrs = append(hashStrs, hash.Hex())
+	}
+	q := `SELECT hash, timestamp FROM blocks WHERE chain_id = $1 AND hash = ANY($2)`
+	rows := []struct {
+		Hash      string
+		Timestamp int64
+	}{}
+	err := pgxscan.Select(context.TODO(), conn, &rows, q, chainID, hashStrs)
+	if err != nil {
+		return nil, fmt.Errorf("get blocks: %w", err)
+	}
+	result := map[common.Hash]uint64{}
+	for _, row := range rows {
+		result[common.HexToHash(row.Hash)] = uint64(row.Timestamp)
+	}
+	return result, nil
+}
+
+func AddBlocks(chainID int64, blocks []*BlockTime) error {
+	if len(blocks) == 0 {
+		return nil
+	}
+	q := `INSERT INTO blocks (chain_id, hash, number, timestamp) VALUES ($1, $2, $3, $4) ON CONFLICT DO NOTHING`
+	batch := &pgx.Batch{}
+	for _, block := range blocks {
+		batch.Queue(q, chainID, block.Hash.Hex(), block.Number, block.Timestamp)
+	}
+	err := conn.SendBatch(context.TODO(), batch).Close()
+	if err != nil {
+		return fmt.Errorf("add blocks: %w", err)
+	}
+	return nil
+}
+
+// StoredTokenMetadata returns the stored metadata of a contract, or nil when it hasn't been resolved yet.
+func StoredTokenMetadata(chainID int64, contract common.Address) (*TokenMetadata, error) {
+	q := `SELECT chain_id, contract, name, symbol, decimals FROM tokens WHERE chain_id = $1 AND contract = $2`
+	result := &TokenMetadata{}
+	err := pgxscan.Get(context.TODO(), conn, result, q, chainID, contract.Hex())
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("get token: %w", err)
+	}
+	return result, nil
+}
+
+func AddTokenMetadata(meta *TokenMetadata) error {
+	q := `INSERT INTO tokens (chain_id, contract, name, symbol, decimals) VALUES ($1, $2, $3, $4, $5) ON CONFLICT DO NOTHING`
+	_, err := conn.Exec(context.TODO(), q, meta.ChainID, meta.Contract, meta.Name, meta.Symbol, meta.Decimals)
+	if err != nil {
+		return fmt.Errorf("add token: %w", err)
+	}
+	return nil
+}
+
+// LatestApprovals returns the most recent approval per token and spender granted by owner on a chain.
+func LatestApprovals(chainID int, owner common.Address) ([]*ApprovalRecord, error) {
+	q := `SELECT DISTINCT ON (contract, spender_address) * FROM approvals WHERE chain_id = $1 AND owner_address = $2 ORDER BY contract, spender_address, block DESC, log_index DESC`
+	result := []*ApprovalRecord{}
+	err := pgxscan.Select(context.TODO(), conn, &result, q, chainID, owner.Hex())
+	if err != nil {
+		return nil, fmt.Errorf("get approvals: %w", err)
+	}
+	return result, nil
+}
+
+// TransferCursor is the position of a transfer in chain order, to continue a listing after it.
+type TransferCursor struct {
+	Block    uint64
+	LogIndex uint
+}
+
+// TransferFilter selects the transfers of an asset. Zero fields match everything; MinAmount is in base units
+// and SinceTime (inclusive) and UntilTime (exclusive) are block timestamps. Rows come in chain order,
+// newest first unless Ascending, starting after After.
+type TransferFilter struct {
+	ChainID    int64
+	Symbol     string
+	SinceBlock int64
+	UntilBlock int64
+	From       string
+	To         string
+	Address    string
+	MinAmount  *decimal.Decimal
+	SinceTime  int64
+	UntilTime  int64
+	Ascending  bool
+	After      *TransferCursor
+	Limit      int
+}
+
+func (f *TransferFilter) query() (string, []interface{}) {
+	q := `SELECT * FROM transfers WHERE chain_id = $1 AND symbol = $2`
+	args := []interface{}{f.ChainID, strings.ToUpper(f.Symbol)}
+	arg := func(value interface{}) string {
+		args = append(args, value)
+		return fmt.Sprintf("$%d", len(args))
+	}
+	if f.SinceBlock > 0 {
+		q += ` AND block > ` + arg(f.SinceBlock)
+	}
+	if f.UntilBlock > 0 {
+		q += ` AND block <= ` + arg(f.UntilBlock)
+	}
+	if f.From != "" {
+		q += ` AND from_address = ` + arg(f.From)
+	}
+	if f.To != "" {
+		q += ` AND to_address = ` + arg(f.To)
+	}
+	if f.Address != "" {
+		n := arg(f.Address)
+		q += ` AND (from_address = ` + n + ` OR to_address = ` + n + `)`
+	}
+	if f.MinAmount != nil {
+		q += ` AND amount >= ` + arg(f.MinAmount.String())
+	}
+	if f.SinceTime > 0 {
+		q += ` AND timestamp >= ` + arg(f.SinceTime)
+	}
+	if f.UntilTime > 0 {
+		q += ` AND timestamp < ` + arg(f.UntilTime)
+	}
+	order := "DESC"
+	if f.Ascending {
+		order = "ASC"
+	}
+	if f.After != nil {
+		cmp := "<"
+		if f.Ascending {
+			cmp = ">"
+		}
+		q += fmt.Sprintf(` AND (block, log_index) %s (%s, %s)`, cmp, arg(f.After.Block), arg(f.After.LogIndex))
+	}
+	q += fmt.Sprintf(` ORDER BY block %s, log_index %s`, order, order)
+	if f.Limit > 0 {
+		q += ` LIMIT ` + arg(f.Limit)
+	}
+	return q, args
+}
+
+// TransferRecords returns the transfers matching filter.
+func TransferRecords(ctx context.Context, filter *TransferFilter) ([]*TransferRecord, error) {
+	q, args := filter.query()
+	result := []*TransferRecord{}
+	err := pgxscan.Select(ctx, conn, &result, q, args...)
+	if err != nil {
+		return nil, fmt.Errorf("get transfers: %w", err)
+	}
+	return result, nil
+}
+
+// UnvaluedTransfers returns the oldest transfers of an asset that have no valuation yet.
+func UnvaluedTransfers(ctx context.Context, chainID int64, symbol string, limit int) ([]*TransferRecord, error) {
+	q := `SELECT * FROM transfers WHERE chain_id = $1 AND symbol = $2 AND price_source IS NULL ORDER BY block, log_index LIMIT $3`
+	result := []*TransferRecord{}
+	err := pgxscan.Select(ctx, conn, &result, q, chainID, strings.ToUpper(symbol), limit)
+	if err != nil {
+		return nil, fmt.Errorf("get unvalued transfers: %w", err)
+	}
+	return result, nil
+}
+
+// SetTransferValuations stores valuations of transfers that have none. A stored valuation is never overwritten.
+func SetTransferValuations(ctx context.Context, valuations []*TransferValuation) error {
+	q := `UPDATE transfers SET usd_price = $2, usd_value = $3, price_source = $4, valued_at = NOW() WHERE id = $1 AND price_source IS NULL`
+	batch := &pgx.Batch{}
+	for _, valuation := range valuations {
+		batch.Queue(q, valuation.TransferID, decimalString(valuation.Price), decimalString(valuation.Value), valuation.Source)
+	}
+	err := conn.SendBatch(ctx, batch).Close()
+	if err != nil {
+		return fmt.Errorf("set transfer valuations: %w", err)
+	}
+	return nil
+}
+
+const TransferExportPageSize = 1000
+
+// StreamTransferRecords calls fn with every transfer matching filter, up to filter.Limit when it is set,
+// reading them a keyset page at a time so the whole result is never held in memory. No connection or
+// transaction is held while fn runs, so a slow client only slows its own export down.
+func StreamTransferRecords(ctx context.Context, filter *TransferFilter, fn func(*TransferRecord) error) error {
+	page := *filter
+	remaining := filter.Limit
+	for {
+		page.Limit = TransferExportPageSize
+		if filter.Limit > 0 && remaining < page.Limit {
+			page.Limit = remaining
+		}
+		records, err := TransferRecords(ctx, &page)
+		if err != nil {
+			return fmt.Errorf("stream transfers: %w", err)
+		}
+		for _, record := range records {
+			err = fn(record)
+			if err != nil {
+				return err
+			}
+		}
+		remaining -= len(records)
+		if len(records) < page.Limit || (filter.Limit > 0 && remaining <= 0) {
+			return nil
+		}
+		last := records[len(records)-1]
+		page.After = &TransferCursor{last.Block, last.LogIndex}
+	}
+}
+
+// Response formats a transfer for the API, counting its confirmations at blockHeight.
+func (r *TransferRecord) Response(blockHeight int) *TransferAPIResponse {
+	return &TransferAPIResponse{
+		TxHash:          r.TxID,
+		LogIndex:        r.LogIndex,
+		Time:            r.CreatedAt.Unix(),
+		Chain:           r.ChainID,
+		BlockNumber:     r.Block,
+		Confirmations:   blockHeight - int(r.Block),
+		FromAddress:     r.FromAddress,
+		ToAddress:       r.ToAddress,
+		ContractAddress: r.Contract,
+		Value:           r.Amount.Shift(-int32(r.Decimals)).String(),
+		ValueInt:        r.Amount.String(),
+		Timestamp:       r.Timestamp,
+		ValueDecimals:   r.Decimals,
+		Symbol:          r.Symbol,
+		USDPrice:        decimalString(r.USDPrice),
+		USDValue:        decimalString(r.USDValue),
+		PriceSource:     r.PriceSource,
+	}
+}
+
+func decimalString(d *decimal.Decimal) *string {
+	if d == nil {
+		return nil
+	}
+	result := d.String()
+	return &result
+}
+
 type TransferRecord struct {
 	ID          uuid.UUID
 	Block       uint64
@@ -189,20 +1542,158 @@ type TransferRecord struct {
 	Amount      decimal.Decimal
 	Timestamp   int64
 	CreatedAt   time.Time
+	BlockHash   *string
+	USDPrice    *decimal.Decimal `db:"usd_price"`
+	USDValue    *decimal.Decimal `db:"usd_value"`
+	PriceSource *string
+	ValuedAt    *time.Time
+}
+type ApprovalRecord struct {
+	ID             uuid.UUID
+	Block          uint64
+	LogIndex       uint
+	ChainID        int64
+	Contract       string
+	Symbol         string
+	Decimals       int
+	TxID           string
+	OwnerAddress   string
+	SpenderAddress string
+	Amount         decimal.Decimal
+	Timestamp      int64
+	BlockHash      *string
+	CreatedAt      time.Time
 }
+
+func (r *TransferRecord) Transfer() *Transfer {
+	result := &Transfer{
+		Block:       r.Block,
+		LogIndex:    r.LogIndex,
+		ChainID:     r.ChainID,
+		Contract:    common.HexToAddress(r.Contract),
+		Symbol:      r.Symbol,
+		Decimals:    r.Decimals,
+		TxID:        common.HexToHash(r.TxID),
+		FromAddress: common.HexToAddress(r.FromAddress),
+		ToAddress:   common.HexToAddress(r.ToAddress),
+		Amount:      r.Amount,
+		CreatedAt:   uint64(r.Timestamp),
+	}
+	if r.BlockHash != nil {
+		result.BlockHash = common.HexToHash(*r.BlockHash)
+	}
+	return result
+}
+
+type BalanceRecord struct {
+	ChainID      int64
+	Symbol       string
+	Address      string
+	Balance      decimal.Decimal
+	UpdatedBlock uint64
+}
+
 type TransferAPIResponse struct {
-	TxHash          string `json:"tx_hash"`
-	LogIndex        uint   `json:"log_index"`
-	Time            int64  `json:"time"`
-	Chain           int64  `json:"chain"`
-	BlockNumber     uint64 `json:"block_number"`
-	Confirmations   int    `json:"confirmations"`
-	FromAddress     string `json:"from_address"`
-	ToAddress       string `json:"to_address"`
-	ContractAddress string `json:"contract_address"`
-	Value           string `json:"value"`
-	ValueInt        string `json:"value_int"`
-	Timestamp       int64  `json:"timestamp"`
-	ValueDecimals   int    `json:"value_decimals"`
-	Symbol          string `json:"symbol"`
+	TxHash          string  `json:"tx_hash"`
+	LogIndex        uint    `json:"log_index"`
+	Time            int64   `json:"time"`
+	Chain           int64   `json:"chain"`
+	BlockNumber     uint64  `json:"block_number"`
+	Confirmations   int     `json:"confirmations"`
+	FromAddress     string  `json:"from_address"`
+	ToAddress       string  `json:"to_address"`
+	ContractAddress string  `json:"contract_address"`
+	Value           string  `json:"value"`
+	ValueInt        string  `json:"value_int"`
+	Timestamp       int64   `json:"timestamp"`
+	ValueDecimals   int     `json:"value_decimals"`
+	Symbol          string  `json:"symbol"`
+	USDPrice        *string `json:"usd_price"`
+	USDValue        *string `json:"usd_value"`
+	PriceSource     *string `json:"price_source"`
+}
+
+const insertAuditQuery = `INSERT INTO admin_audit (actor, action, target, old_value, new_value) VALUES ($1, $2, $3, $4, $5)`
+
+// SetKVAudited sets key to value, or unsets it when value is nil, and records the change in admin_audit
+// in the same transaction. It returns the previous value, nil if it wasn't set.
+func SetKVAudited(ctx context.Context, actor string, action string, key KVKey, value *string) (*string, error) {
+	var old *string
+	err := pgx.BeginFunc(ctx, conn, func(tx pgx.Tx) error {
+		rows := []string{}
+		err := pgxscan.Select(ctx, tx, &rows, `SELECT value FROM kv WHERE key = $1 FOR UPDATE`, key)
+		if err != nil {
+			return err
+		}
+		if len(rows) > 0 {
+			old = &rows[0]
+		}
+		if value != nil {
+			_, err = tx.Exec(ctx, setQuery, key, *value)
+		} else {
+			_, err = tx.Exec(ctx, `DELETE FROM kv WHERE key = $1`, key)
+		}
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(ctx, insertAuditQuery, actor, action, string(key), old, value)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("%s %s: %w", action, key, err)
+	}
+	return old, nil
+}
+
+// ScraperSettingValues returns the current value of every scraper setting.
+func ScraperSettingValues() ([]*ScraperSettingValue, error) {
+	result := []*ScraperSettingValue{}
+	for _, setting := range ScraperSettings {
+		set, err := Exists(setting.Key)
+		if err != nil {
+			return nil, err
+		}
+		value := &ScraperSettingValue{ScraperSetting: setting, Value: setting.Default, Set: set}
+		if set {
+			value.Value, err = GetInt(setting.Key, setting.Default)
+			if err != nil {
+				return nil, err
+			}
+		}
+		result = append(result, value)
+	}
+	return result, nil
+}
+
+// ScraperPaused reports whether a scraper was paused.
+func ScraperPaused(name string) (bool, error) {
+	return Exists(KeyScraperPaused(name))
+}
+
+// QueueRescan queues a rescan and records it in admin_audit in the same transaction.
+func QueueRescan(ctx context.Context, actor string, rescan *Rescan) error {
+	err := pgx.BeginFunc(ctx, conn, func(tx pgx.Tx) error {
+		err := InsertRescans(ctx, tx, []*Rescan{rescan})
+		if err != nil {
+			return err
+		}
+		target := fmt.Sprintf("%d_%s", rescan.ChainID, rescan.Symbol)
+		value := fmt.Sprintf("%d-%d", rescan.FromBlock, rescan.ToBlock)
+		_, err = tx.Exec(ctx, insertAuditQuery, actor, AuditQueueRescan, target, nil, value)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("queue rescan: %w", err)
+	}
+	return nil
+}
+
+// AuditLog returns the newest admin changes.
+func AuditLog(ctx context.Context, limit int) ([]*AuditEntry, error) {
+	result := []*AuditEntry{}
+	err := pgxscan.Select(ctx, conn, &result, `SELECT id, actor, action, target, old_value, new_value, created_at FROM admin_audit ORDER BY id DESC LIMIT $1`, limit)
+	if err != nil {
+		return nil, fmt.Errorf("get audit log: %w", err)
+	}
+	return result, nil
 }
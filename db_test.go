@@ -0,0 +1,130 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/gofrs/uuid"
+	"github.com/shopspring/decimal"
+)
+
+func TestTransferFilterQuery(t *testing.T) {
+	minAmount := decimal.RequireFromString("1000000000000000000")
+	tests := []struct {
+		name   string
+		filter TransferFilter
+		query  string
+		args   []interface{}
+	}{
+		{
+			name:   "asset only",
+			filter: TransferFilter{ChainID: 1, Symbol: "sups"},
+			query:  `SELECT * FROM transfers WHERE chain_id = $1 AND symbol = $2 ORDER BY block DESC, log_index DESC`,
+			args:   []interface{}{int64(1), "SUPS"},
+		},
+		{
+			name:   "ascending with limit",
+			filter: TransferFilter{ChainID: 1, Symbol: "ETH", Ascending: true, Limit: 50},
+			query:  `SELECT * FROM transfers WHERE chain_id = $1 AND symbol = $2 ORDER BY block ASC, log_index ASC LIMIT $3`,
+			args:   []interface{}{int64(1), "ETH", 50},
+		},
+		{
+			name:   "descending after cursor",
+			filter: TransferFilter{ChainID: 1, Symbol: "SUPS", After: &TransferCursor{100, 7}, Limit: 10},
+			query:  `SELECT * FROM transfers WHERE chain_id = $1 AND symbol = $2 AND (block, log_index) < ($3, $4) ORDER BY block DESC, log_index DESC LIMIT $5`,
+			args:   []interface{}{int64(1), "SUPS", uint64(100), uint(7), 10},
+		},
+		{
+			name:   "ascending after cursor",
+			filter: TransferFilter{ChainID: 1, Symbol: "SUPS", Ascending: true, After: &TransferCursor{100, 7}, Limit: 10},
+			query:  `SELECT * FROM transfers WHERE chain_id = $1 AND symbol = $2 AND (block, log_index) > ($3, $4) ORDER BY block ASC, log_index ASC LIMIT $5`,
+			args:   []interface{}{int64(1), "SUPS", uint64(100), uint(7), 10},
+		},
+		{
+			name: "every filter",
+			filter: TransferFilter{
+				ChainID:    56,
+				Symbol:     "BNB",
+				SinceBlock: 10,
+				UntilBlock: 20,
+				From:       "0xfrom",
+				To:         "0xto",
+				Address:    "0xaddress",
+				MinAmount:  &minAmount,
+				SinceTime:  1700000000,
+				UntilTime:  1800000000,
+				Ascending:  true,
+				After:      &TransferCursor{15, 2},
+				Limit:      100,
+			},
+			query: `SELECT * FROM transfers WHERE chain_id = $1 AND symbol = $2` +
+				` AND block > $3 AND block <= $4 AND from_address = $5 AND to_address = $6` +
+				` AND (from_address = $7 OR to_address = $7) AND amount >= $8 AND timestamp >= $9 AND timestamp < $10` +
+				` AND (block, log_index) > ($11, $12) ORDER BY block ASC, log_index ASC LIMIT $13`,
+			args: []interface{}{int64(56), "BNB", int64(10), int64(20), "0xfrom", "0xto", "0xaddress", "1000000000000000000",
+				int64(1700000000), int64(1800000000), uint64(15), uint(2), 100},
+		},
+		{
+			name:   "address shares one placeholder",
+			filter: TransferFilter{ChainID: 1, Symbol: "SUPS", Address: "0xaddress", UntilTime: 1800000000},
+			query: `SELECT * FROM transfers WHERE chain_id = $1 AND symbol = $2` +
+				` AND (from_address = $3 OR to_address = $3) AND timestamp < $4 ORDER BY block DESC, log_index DESC`,
+			args: []interface{}{int64(1), "SUPS", "0xaddress", int64(1800000000)},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			query, args := tt.filter.query()
+			if query != tt.query {
+				t.Errorf("query() =\n%s\nwant\n%s", query, tt.query)
+			}
+			if !reflect.DeepEqual(args, tt.args) {
+				t.Errorf("query() args = %#v, want %#v", args, tt.args)
+			}
+		})
+	}
+}
+
+func TestScrapedRangeOrphaned(t *testing.T) {
+	hash := func(s string) *string {
+		result := common.HexToHash(s).Hex()
+		return &result
+	}
+	scraped := &ScrapedRange{
+		ChainID:   1,
+		Symbol:    "SUPS",
+		FromBlock: 100,
+		ToBlock:   110,
+		Canonical: map[uint64]common.Hash{
+			100: common.HexToHash("0xa0"),
+			105: common.HexToHash("0xa5"),
+		},
+	}
+	transfers := []*TransferRecord{
+		{ID: uuid.UUID{1}, Block: 100, BlockHash: hash("0xa0")},
+		{ID: uuid.UUID{2}, Block: 105, BlockHash: hash("0xb5")},
+		{ID: uuid.UUID{3}, Block: 107, BlockHash: hash("0xb7")},
+		{ID: uuid.UUID{4}, Block: 105},
+	}
+	approvals := []*ApprovalRecord{
+		{ID: uuid.UUID{5}, Block: 100, BlockHash: hash("0xa0")},
+		{ID: uuid.UUID{6}, Block: 105, BlockHash: hash("0xb5")},
+		{ID: uuid.UUID{7}, Block: 105},
+	}
+
+	gotTransfers := []uuid.UUID{}
+	for _, record := range scraped.OrphanedTransfers(transfers) {
+		gotTransfers = append(gotTransfers, record.ID)
+	}
+	if want := []uuid.UUID{{2}}; !reflect.DeepEqual(gotTransfers, want) {
+		t.Errorf("OrphanedTransfers() = %v, want %v", gotTransfers, want)
+	}
+	gotApprovals := []uuid.UUID{}
+	for _, record := range scraped.OrphanedApprovals(approvals) {
+		gotApprovals = append(gotApprovals, record.ID)
+	}
+	if want := []uuid.UUID{{6}}; !reflect.DeepEqual(gotApprovals, want) {
+		t.Errorf("OrphanedApprovals() = %v, want %v", gotApprovals, want)
+	}
+}
@@ -10,6 +10,12 @@ interface IERC20 {
         uint256 value
     );
 
+    function name() external view returns (string memory);
+
+    function symbol() external view returns (string memory);
+
+    function decimals() external view returns (uint8);
+
     function totalSupply() external view returns (uint256);
 
     function balanceOf(address account) external view returns (uint256);
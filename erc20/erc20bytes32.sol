@@ -0,0 +1,9 @@
+// SPDX-License-Identifier: UNLICENSED
+pragma solidity ^0.8.0;
+
+// Tokens that predate string returns, such as MKR, answer name() and symbol() with bytes32.
+interface IERC20Bytes32 {
+    function name() external view returns (bytes32);
+
+    function symbol() external view returns (bytes32);
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+const FormatJSON = "json"
+const FormatCSV = "csv"
+const FormatNDJSON = "ndjson"
+
+// ExportFlushRows is how many rows an export writes between flushes to the client.
+const ExportFlushRows = 500
+
+// TransferCSVHeader lists the columns of a transfers CSV export, in order.
+var TransferCSVHeader = []string{
+	"chain",
+	"symbol",
+	"contract_address",
+	"block_number",
+	"log_index",
+	"tx_hash",
+	"timestamp",
+	"time",
+	"from_address",
+	"to_address",
+	"value",
+	"value_int",
+	"value_decimals",
+	"confirmations",
+	"usd_price",
+	"usd_value",
+	"price_source",
+}
+
+// TransferCSVRow is a transfer in the columns of TransferCSVHeader. value is in whole tokens.
+func TransferCSVRow(transfer *TransferAPIResponse) []string {
+	return []string{
+		strconv.FormatInt(transfer.Chain, 10),
+		transfer.Symbol,
+		transfer.ContractAddress,
+		strconv.FormatUint(transfer.BlockNumber, 10),
+		strconv.FormatUint(uint64(transfer.LogIndex), 10),
+		transfer.TxHash,
+		strconv.FormatInt(transfer.Timestamp, 10),
+		time.Unix(transfer.Timestamp, 0).UTC().Format(time.RFC3339),
+		transfer.FromAddress,
+		transfer.ToAddress,
+		transfer.Value,
+		transfer.ValueInt,
+		strconv.Itoa(transfer.ValueDecimals),
+		strconv.Itoa(transfer.Confirmations),
+		stringOrEmpty(transfer.USDPrice),
+		stringOrEmpty(transfer.USDValue),
+		stringOrEmpty(transfer.PriceSource),
+	}
+}
+
+func stringOrEmpty(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
+
+// responseFormat picks json, csv or ndjson from ?format=, then from the Accept header. JSON is the default.
+func responseFormat(r *http.Request) (string, bool) {
+	switch r.URL.Query().Get("format") {
+	case "":
+	case FormatJSON:
+		return FormatJSON, true
+	case FormatCSV:
+		return FormatCSV, true
+	case FormatNDJSON:
+		return FormatNDJSON, true
+	default:
+		return "", false
+	}
+	for _, accept := range strings.Split(r.Header.Get("Accept"), ",") {
+		mediaType, _, err := mime.ParseMediaType(strings.TrimSpace(accept))
+		if err != nil {
+			continue
+		}
+		switch mediaType {
+		case "text/csv":
+			return FormatCSV, true
+		case "application/x-ndjson", "application/ndjson":
+			return FormatNDJSON, true
+		case "application/json":
+			return FormatJSON, true
+		}
+	}
+	return FormatJSON, true
+}
+
+// exportTransfers streams the transfers matching query as CSV or NDJSON. Once rows are sent the status
+// can't change, so a failure midway cuts the response short and is only logged.
+func (c *Controller) exportTransfers(w http.ResponseWriter, r *http.Request, format string, query *TransferQuery) {
+	chainName := chi.URLParam(r, "chain")
+	symbol := chi.URLParam(r, "symbol")
+	_, _, err := c.TransferFilter(chainName, symbol, query)
+	if err != nil {
+		serviceError(w, err)
+		return
+	}
+	flusher, _ := w.(http.Flusher)
+	flush := func() {
+		if flusher != nil {
+			flusher.Flush()
+		}
+	}
+
+	var write func(*TransferAPIResponse) error
+	var csvWriter *csv.Writer
+	switch format {
+	case FormatCSV:
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="transfers-%s-%s.csv"`, chainName, strings.ToLower(symbol)))
+		csvWriter = csv.NewWriter(w)
+		err = csvWriter.Write(TransferCSVHeader)
+		if err != nil {
+			return
+		}
+		write = func(transfer *TransferAPIResponse) error {
+			return csvWriter.Write(TransferCSVRow(transfer))
+		}
+	case FormatNDJSON:
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		encoder := json.NewEncoder(w)
+		write = func(transfer *TransferAPIResponse) error {
+			return encoder.Encode(transfer)
+		}
+	}
+
+	rows := 0
+	err = c.ExportTransfers(r.Context(), chainName, symbol, query, func(transfer *TransferAPIResponse) error {
+		err := write(transfer)
+		if err != nil {
+			return err
+		}
+		rows++
+		if rows%ExportFlushRows == 0 {
+			if csvWriter != nil {
+				csvWriter.Flush()
+			}
+			flush()
+		}
+		return nil
+	})
+	if csvWriter != nil {
+		csvWriter.Flush()
+	}
+	flush()
+	if err != nil {
+		log.Warn().Err(err).Str("chain", chainName).Str("symbol", symbol).Int("rows", rows).Msg("export transfers")
+	}
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+
+	"xsyn-pricefeed/pricefeedpb"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/shopspring/decimal"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// GRPCServer adapts the Service to the Pricefeed gRPC service in pricefeedpb.
+type GRPCServer struct {
+	pricefeedpb.UnimplementedPricefeedServer
+	Service *Service
+}
+
+// ServeGRPC serves the Pricefeed gRPC service on port until it fails or ctx is done, when it stops gracefully.
+// Every call must carry the "authorization: Bearer <token>" metadata; without a token every call is refused.
+func ServeGRPC(ctx context.Context, service *Service, port int, token string) error {
+	lis, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
+	if err != nil {
+		return fmt.Errorf("listen grpc: %w", err)
+	}
+	server := grpc.NewServer(
+		grpc.UnaryInterceptor(func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+			err := grpcAuth(ctx, token)
+			if err != nil {
+				return nil, err
+			}
+			return handler(ctx, req)
+		}),
+		grpc.StreamInterceptor(func(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+			err := grpcAuth(stream.Context(), token)
+			if err != nil {
+				return err
+			}
+			return handler(srv, stream)
+		}),
+	)
+	pricefeedpb.RegisterPricefeedServer(server, &GRPCServer{Service: service})
+	go func() {
+		<-ctx.Done()
+		stopped := make(chan struct{})
+		go func() {
+			server.GracefulStop()
+			close(stopped)
+		}()
+		select {
+		case <-stopped:
+		case <-time.After(ShutdownTimeout):
+			server.Stop()
+		}
+	}()
+	log.Info().Int("port", port).Msg("Running grpc server")
+	err = server.Serve(lis)
+	if err != nil {
+		return fmt.Errorf("serve grpc: %w", err)
+	}
+	return nil
+}
+
+// grpcAuth checks the bearer token of a call like AdminAuth does for HTTP requests.
+func grpcAuth(ctx context.Context, token string) error {
+	md, _ := metadata.FromIncomingContext(ctx)
+	for _, auth := range md.Get("authorization") {
+		if validBearer(token, auth) {
+			return nil
+		}
+	}
+	return status.Error(codes.Unauthenticated, "unauthorized")
+}
+
+func grpcError(err error) error {
+	switch {
+	case errors.Is(err, ErrUnknownChain), errors.Is(err, ErrUnknownSymbol), errors.Is(err, ErrNoPrice):
+		return status.Error(codes.NotFound, err.Error())
+	case errors.Is(err, ErrInvalidAddress), errors.Is(err, ErrInvalidCursor), errors.Is(err, ErrCursorMismatch):
+		return status.Error(codes.InvalidArgument, err.Error())
+	case errors.Is(err, ErrStreamDropped):
+		return status.Error(codes.ResourceExhausted, err.Error())
+	case errors.Is(err, context.Canceled):
+		return status.Error(codes.Canceled, err.Error())
+	case errors.Is(err, context.DeadlineExceeded):
+		return status.Error(codes.DeadlineExceeded, err.Error())
+	default:
+		return status.Error(codes.Internal, err.Error())
+	}
+}
+
+func pbPrices(price *PriceResponse) *pricefeedpb.Prices {
+	return &pricefeedpb.Prices{
+		Time:         price.Time,
+		SupsUsdCents: price.SUPSUSD.String(),
+		EthUsdCents:  price.ETHUSD.String(),
+		BnbUsdCents:  price.BNBUSD.String(),
+	}
+}
+
+func pbTransfer(transfer *TransferAPIResponse) *pricefeedpb.Transfer {
+	return &pricefeedpb.Transfer{
+		TxHash:          transfer.TxHash,
+		LogIndex:        uint32(transfer.LogIndex),
+		Time:            transfer.Time,
+		Chain:           transfer.Chain,
+		BlockNumber:     transfer.BlockNumber,
+		Confirmations:   int64(transfer.Confirmations),
+		FromAddress:     transfer.FromAddress,
+		ToAddress:       transfer.ToAddress,
+		ContractAddress: transfer.ContractAddress,
+		Value:           transfer.Value,
+		ValueInt:        transfer.ValueInt,
+		Timestamp:       transfer.Timestamp,
+		ValueDecimals:   int32(transfer.ValueDecimals),
+		Symbol:          transfer.Symbol,
+		UsdPrice:        transfer.USDPrice,
+		UsdValue:        transfer.USDValue,
+		PriceSource:     transfer.PriceSource,
+	}
+}
+
+func (s *GRPCServer) GetPrices(ctx context.Context, req *pricefeedpb.GetPricesRequest) (*pricefeedpb.Prices, error) {
+	result, err := s.Service.Prices(ctx)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	return pbPrices(result), nil
+}
+
+func (s *GRPCServer) GetPriceAt(ctx context.Context, req *pricefeedpb.GetPriceAtRequest) (*pricefeedpb.Prices, error) {
+	result, err := s.Service.PriceAt(ctx, time.Unix(req.Time, 0))
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	return pbPrices(result), nil
+}
+
+func (s *GRPCServer) ListTransfers(ctx context.Context, req *pricefeedpb.ListTransfersRequest) (*pricefeedpb.ListTransfersResponse, error) {
+	query := &TransferQuery{
+		SinceBlock: int64(req.SinceBlock),
+		UntilBlock: int64(req.UntilBlock),
+		SinceTime:  req.SinceTime,
+		UntilTime:  req.UntilTime,
+		Ascending:  req.Ascending,
+		Limit:      int(req.Limit),
+		Cursor:     req.Cursor,
+	}
+	addrs := []struct {
+		str   string
+		value **common.Address
+	}{
+		{req.FromAddress, &query.From},
+		{req.ToAddress, &query.To},
+		{req.Address, &query.Address},
+	}
+	for _, param := range addrs {
+		if param.str == "" {
+			continue
+		}
+		if !common.IsHexAddress(param.str) {
+			return nil, status.Errorf(codes.InvalidArgument, "invalid address %q", param.str)
+		}
+		addr := common.HexToAddress(param.str)
+		*param.value = &addr
+	}
+	if req.MinAmount != "" {
+		minAmount, err := decimal.NewFromString(req.MinAmount)
+		if err != nil {
+			return nil, status.Error(codes.InvalidArgument, "invalid min_amount")
+		}
+		query.MinAmount = &minAmount
+	}
+
+	page, err := s.Service.Transfers(ctx, req.Chain, req.Symbol, query)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	result := &pricefeedpb.ListTransfersResponse{NextCursor: page.NextCursor}
+	for _, transfer := range page.Transfers {
+		result.Transfers = append(result.Transfers, pbTransfer(transfer))
+	}
+	return result, nil
+}
+
+func (s *GRPCServer) WatchTransfers(req *pricefeedpb.WatchTransfersRequest, stream pricefeedpb.Pricefeed_WatchTransfersServer) error {
+	err := s.Service.WatchTransfers(stream.Context(), req.Chains, req.Addresses, req.LastEventId, func(id int64, transfer *TransferAPIResponse) error {
+		return stream.Send(&pricefeedpb.TransferEvent{Id: id, Transfer: pbTransfer(transfer)})
+	})
+	if err != nil {
+		return grpcError(err)
+	}
+	return nil
+}
+
+func (s *GRPCServer) WatchPrices(req *pricefeedpb.WatchPricesRequest, stream pricefeedpb.Pricefeed_WatchPricesServer) error {
+	err := s.Service.WatchPrices(stream.Context(), req.LastEventId, func(id int64, price *PriceResponse) error {
+		return stream.Send(&pricefeedpb.PriceEvent{Id: id, Prices: pbPrices(price)})
+	})
+	if err != nil {
+		return grpcError(err)
+	}
+	return nil
+}
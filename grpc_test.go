@@ -0,0 +1,41 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+func TestGRPCAuth(t *testing.T) {
+	tests := []struct {
+		name  string
+		token string
+		md    metadata.MD
+		ok    bool
+	}{
+		{"valid token", "secret", metadata.Pairs("authorization", "Bearer secret"), true},
+		{"lowercase scheme", "secret", metadata.Pairs("authorization", "bearer secret"), true},
+		{"wrong token", "secret", metadata.Pairs("authorization", "Bearer other"), false},
+		{"no scheme", "secret", metadata.Pairs("authorization", "secret"), false},
+		{"no metadata", "secret", nil, false},
+		{"no token configured", "", metadata.Pairs("authorization", "Bearer "), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			if tt.md != nil {
+				ctx = metadata.NewIncomingContext(ctx, tt.md)
+			}
+			err := grpcAuth(ctx, tt.token)
+			if tt.ok && err != nil {
+				t.Errorf("grpcAuth() = %v, want nil", err)
+			}
+			if !tt.ok && status.Code(err) != codes.Unauthenticated {
+				t.Errorf("grpcAuth() = %v, want Unauthenticated", err)
+			}
+		})
+	}
+}
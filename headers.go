@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// MaxCachedBlockTimes bounds the in-memory layer of the block time cache.
+// The blocks table keeps everything, so dropping the memory layer only costs a query.
+const MaxCachedBlockTimes = 100000
+
+// BlockTimes caches block timestamps by hash, in memory and in the blocks table,
+// so scraping logs fetches each block header at most once.
+type BlockTimes struct {
+	sync.Mutex
+	times map[int64]map[common.Hash]uint64
+	size  int
+}
+
+var blockTimes = &BlockTimes{times: map[int64]map[common.Hash]uint64{}}
+
+func (c *BlockTimes) remember(chainID int64, hash common.Hash, timestamp uint64) {
+	c.Lock()
+	defer c.Unlock()
+	if c.size >= MaxCachedBlockTimes {
+		c.times = map[int64]map[common.Hash]uint64{}
+		c.size = 0
+	}
+	if _, ok := c.times[chainID]; !ok {
+		c.times[chainID] = map[common.Hash]uint64{}
+	}
+	if _, ok := c.times[chainID][hash]; !ok {
+		c.size++
+	}
+	c.times[chainID][hash] = timestamp
+}
+
+func (c *BlockTimes) cached(chainID int64, hash common.Hash) (uint64, bool) {
+	c.Lock()
+	defer c.Unlock()
+	timestamp, ok := c.times[chainID][hash]
+	return timestamp, ok
+}
+
+// Remember adds a block that was fetched elsewhere to the memory layer.
+// Hashes come from the node: headers hashed locally don't match once forks add new header fields.
+func (c *BlockTimes) Remember(chainID int64, hash common.Hash, timestamp uint64) {
+	c.remember(chainID, hash, timestamp)
+}
+
+type BlockTime struct {
+	Hash      common.Hash
+	Number    uint64
+	Timestamp uint64
+}
+
+// Times resolves the timestamp of every hash, checking memory, then the blocks table,
+// then the node with HeaderByHash. Any failed lookup fails the whole call.
+func (c *BlockTimes) Times(ctx context.Context, client *Node, chainID int64, hashes []common.Hash) (map[common.Hash]uint64, error) {
+	result := map[common.Hash]uint64{}
+	missing := []common.Hash{}
+	for _, hash := range hashes {
+		if _, ok := result[hash]; ok {
+			continue
+		}
+		timestamp, ok := c.cached(chainID, hash)
+		if ok {
+			result[hash] = timestamp
+			continue
+		}
+		missing = append(missing, hash)
+	}
+	if len(missing) == 0 {
+		return result, nil
+	}
+
+	stored, err := BlockTimestamps(chainID, missing)
+	if err != nil {
+		return nil, fmt.Errorf("get stored block times: %w", err)
+	}
+	fetched := []*BlockTime{}
+	for _, hash := range missing {
+		if _, ok := result[hash]; ok {
+			continue
+		}
+		timestamp, ok := stored[hash]
+		if ok {
+			result[hash] = timestamp
+			c.remember(chainID, hash, timestamp)
+			continue
+		}
+		header, err := client.HeaderByHash(ctx, hash)
+		if err != nil {
+			return nil, fmt.Errorf("get header %s: %w", hash.Hex(), err)
+		}
+		fetched = append(fetched, &BlockTime{hash, header.Number.Uint64(), header.Time})
+		result[hash] = header.Time
+		c.remember(chainID, hash, header.Time)
+	}
+
+	err = AddBlocks(chainID, fetched)
+	if err != nil {
+		return nil, fmt.Errorf("save block times: %w", err)
+	}
+	return result, nil
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	headsCounter = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "xsyn_pricefeed_heads_total",
+		Help: "How many new heads were received, partitioned by chain and source (subscription or polling).",
+	}, []string{"chain_id", "source"})
+	headGapsCounter = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "xsyn_pricefeed_head_gaps_total",
+		Help: "How many blocks were skipped between consecutive heads, partitioned by chain.",
+	}, []string{"chain_id"})
+	headNumberGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "xsyn_pricefeed_head_number",
+		Help: "Number of the latest head seen, partitioned by chain.",
+	}, []string{"chain_id"})
+)
+
+func init() {
+	prometheus.MustRegister(headsCounter, headGapsCounter, headNumberGauge)
+}
+
+const MinHeadBackoff = time.Second
+const MaxHeadBackoff = time.Minute
+
+type Head struct {
+	Number uint64
+	// Gap is how many blocks were skipped since the previous head, because the subscription dropped them or polling was too slow.
+	Gap    uint64
+	Source string
+}
+
+// HeadTracker follows the heads of a chain and hands every subscriber each new block number once, in increasing order.
+// It subscribes to new heads and resubscribes with backoff when the subscription fails,
+// polling BlockNumber in the meantime. Nodes without subscriptions (HTTP endpoints) are polled for good.
+type HeadTracker struct {
+	Chain        *Chain
+	PollInterval time.Duration
+
+	mu   sync.Mutex
+	subs []chan *Head
+	last uint64
+}
+
+func NewHeadTracker(chain *Chain, pollInterval time.Duration) *HeadTracker {
+	return &HeadTracker{Chain: chain, PollInterval: pollInterval}
+}
+
+// Subscribe returns a channel of new heads. A subscriber that falls behind only misses older heads, never the latest one.
+func (t *HeadTracker) Subscribe() <-chan *Head {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	ch := make(chan *Head, 1)
+	t.subs = append(t.subs, ch)
+	return ch
+}
+
+func (t *HeadTracker) Run(ctx context.Context) {
+	log.Info().Str("chain", t.Chain.Name).Msg("start head tracker")
+	failures := 0
+	for ctx.Err() == nil {
+		start := time.Now()
+		err := t.subscribe(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		if time.Since(start) > MaxHeadBackoff {
+			failures = 0
+		}
+		if errors.Is(err, rpc.ErrNotificationsUnsupported) {
+			log.Info().Str("chain", t.Chain.Name).Msg("node doesn't support subscriptions, polling heads")
+			t.poll(ctx)
+			return
+		}
+		failures++
+		wait := MinHeadBackoff << (failures - 1)
+		if failures > 7 || wait > MaxHeadBackoff {
+			wait = MaxHeadBackoff
+		}
+		log.Err(err).Str("chain", t.Chain.Name).Int("failures", failures).Str("retry_in", wait.String()).Msg("head subscription failed, polling")
+		pollCtx, cancel := context.WithTimeout(ctx, wait)
+		t.poll(pollCtx)
+		cancel()
+	}
+}
+
+// subscribe follows the new heads subscription until it fails.
+func (t *HeadTracker) subscribe(ctx context.Context) error {
+	heads := make(chan *types.Header)
+	sub, err := t.Chain.Node.SubscribeNewHead(ctx, heads)
+	if err != nil {
+		return err
+	}
+	defer sub.Unsubscribe()
+	log.Info().Str("chain", t.Chain.Name).Msg("subscribed to heads")
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case err := <-sub.Err():
+			if err == nil {
+				err = errors.New("subscription closed")
+			}
+			return err
+		case head := <-heads:
+			t.publish(head.Number.Uint64(), "subscription")
+		}
+	}
+}
+
+// poll publishes the block number every PollInterval until ctx is done.
+func (t *HeadTracker) poll(ctx context.Context) {
+	ticker := time.NewTicker(t.PollInterval)
+	defer ticker.Stop()
+	for {
+		number, err := t.Chain.Node.BlockNumber(ctx)
+		if err != nil && ctx.Err() == nil {
+			log.Err(err).Str("chain", t.Chain.Name).Msg("poll block number")
+		}
+		if err == nil {
+			t.publish(number, "polling")
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// publish sends a head to every subscriber unless it was already seen, replacing any head they haven't read yet.
+func (t *HeadTracker) publish(number uint64, source string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if number <= t.last {
+		return
+	}
+	chainID := strconv.FormatInt(t.Chain.ID, 10)
+	head := &Head{Number: number, Source: source}
+	if t.last > 0 && number > t.last+1 {
+		head.Gap = number - t.last - 1
+		headGapsCounter.WithLabelValues(chainID).Add(float64(head.Gap))
+		log.Warn().Str("chain", t.Chain.Name).Uint64("from", t.last).Uint64("to", number).Uint64("gap", head.Gap).Msg("head gap")
+	}
+	t.last = number
+	headsCounter.WithLabelValues(chainID, source).Inc()
+	headNumberGauge.WithLabelValues(chainID).Set(float64(number))
+	for _, ch := range t.subs {
+		select {
+		case <-ch:
+		default:
+		}
+		ch <- head
+	}
+}
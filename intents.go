@@ -0,0 +1,441 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/go-chi/chi/v5"
+	"github.com/gofrs/uuid"
+	"github.com/shopspring/decimal"
+)
+
+type IntentStatus string
+
+const IntentPending IntentStatus = "pending"
+const IntentUnderpaid IntentStatus = "underpaid"
+const IntentPaid IntentStatus = "paid"
+const IntentExpired IntentStatus = "expired"
+
+// PaymentIntent expects between MinAmount and MaxAmount of an asset from Payer to Recipient before ExpiresAt.
+// Amounts are in base units, like the transfers they are matched to.
+type PaymentIntent struct {
+	ID               uuid.UUID
+	Reference        *string
+	ChainID          int64
+	Symbol           string
+	Decimals         int
+	PayerAddress     string
+	RecipientAddress string
+	MinAmount        decimal.Decimal
+	MaxAmount        decimal.Decimal
+	Received         decimal.Decimal
+	Status           IntentStatus
+	ExpiresAt        time.Time
+	PaidAt           *time.Time
+	CreatedAt        time.Time
+	UpdatedAt        time.Time
+}
+
+// NextStatus is the status of the intent given what it received so far, at now.
+// An intent is paid as soon as it received MinAmount; paying more than MaxAmount still pays it.
+func (i *PaymentIntent) NextStatus(now time.Time) IntentStatus {
+	switch {
+	case i.Received.GreaterThanOrEqual(i.MinAmount):
+		return IntentPaid
+	case now.After(i.ExpiresAt):
+		return IntentExpired
+	case i.Received.IsPositive():
+		return IntentUnderpaid
+	default:
+		return IntentPending
+	}
+}
+
+// Accepts reports whether transfer pays towards the intent: it must go from the payer to the recipient
+// in the intent's asset, in a block mined while the intent was open. Expired intents still accept transfers
+// mined before their expiry, since those may be indexed late.
+func (i *PaymentIntent) Accepts(transfer *Transfer) bool {
+	if i.Status == IntentPaid || transfer.ChainID != i.ChainID || transfer.Symbol != i.Symbol {
+		return false
+	}
+	if transfer.FromAddress.Hex() != i.PayerAddress || transfer.ToAddress.Hex() != i.RecipientAddress {
+		return false
+	}
+	minedAt := time.Now()
+	if transfer.CreatedAt != 0 {
+		minedAt = time.Unix(int64(transfer.CreatedAt), 0)
+	}
+	return !minedAt.Before(i.CreatedAt) && !minedAt.After(i.ExpiresAt)
+}
+
+// Recount sets what the intent received to received, after transfers were taken off it, and updates its status.
+// An intent that falls back under MinAmount is no longer paid.
+func (i *PaymentIntent) Recount(received decimal.Decimal, now time.Time) {
+	i.Received = received
+	i.Status = i.NextStatus(now)
+	if i.Status != IntentPaid {
+		i.PaidAt = nil
+	}
+}
+
+type IntentMatch struct {
+	Intent   *PaymentIntent
+	Transfer *Transfer
+}
+
+// MatchIntents assigns each transfer, in chain order, to the oldest intent accepting it and adds its amount
+// to the intent's Received and Status. Transfers matching no intent are left out.
+func MatchIntents(intents []*PaymentIntent, transfers []*Transfer, now time.Time) []*IntentMatch {
+	sort.SliceStable(intents, func(i, j int) bool { return intents[i].CreatedAt.Before(intents[j].CreatedAt) })
+	ordered := append([]*Transfer{}, transfers...)
+	sort.SliceStable(ordered, func(i, j int) bool {
+		if ordered[i].Block != ordered[j].Block {
+			return ordered[i].Block < ordered[j].Block
+		}
+		return ordered[i].LogIndex < ordered[j].LogIndex
+	})
+
+	result := []*IntentMatch{}
+	for _, transfer := range ordered {
+		for _, intent := range intents {
+			if !intent.Accepts(transfer) {
+				continue
+			}
+			intent.Received = intent.Received.Add(transfer.Amount)
+			intent.Status = intent.NextStatus(now)
+			if intent.Status == IntentPaid && intent.PaidAt == nil {
+				intent.PaidAt = &now
+			}
+			result = append(result, &IntentMatch{intent, transfer})
+			break
+		}
+	}
+	return result
+}
+
+const DefaultIntentExpiry = time.Hour
+
+const DefaultIntentsLimit = 100
+
+const MaxIntentsLimit = 1000
+
+const IntentStreamInterval = 2 * time.Second
+
+const IntentStreamHeartbeat = 15 * time.Second
+
+// CreateIntentRequest takes amounts in whole tokens. MaxAmount defaults to MinAmount, and the intent
+// expires at ExpiresAt, or ExpiresIn seconds from now, or in an hour.
+type CreateIntentRequest struct {
+	Chain     string     `json:"chain"`
+	Symbol    string     `json:"symbol"`
+	Payer     string     `json:"payer"`
+	Recipient string     `json:"recipient"`
+	MinAmount string     `json:"min_amount"`
+	MaxAmount string     `json:"max_amount"`
+	ExpiresAt *time.Time `json:"expires_at"`
+	ExpiresIn int        `json:"expires_in"`
+	Reference *string    `json:"reference"`
+}
+
+type IntentTransferResponse struct {
+	TxHash    string `json:"tx_hash"`
+	LogIndex  uint   `json:"log_index"`
+	Block     uint64 `json:"block_number"`
+	Value     string `json:"value"`
+	ValueInt  string `json:"value_int"`
+	Timestamp int64  `json:"timestamp"`
+}
+
+type IntentResponse struct {
+	ID            string                    `json:"id"`
+	Reference     *string                   `json:"reference"`
+	Chain         int64                     `json:"chain"`
+	Symbol        string                    `json:"symbol"`
+	Payer         string                    `json:"payer"`
+	Recipient     string                    `json:"recipient"`
+	MinAmount     string                    `json:"min_amount"`
+	MaxAmount     string                    `json:"max_amount"`
+	Received      string                    `json:"received"`
+	ValueDecimals int                       `json:"value_decimals"`
+	Status        IntentStatus              `json:"status"`
+	Overpaid      bool                      `json:"overpaid"`
+	ExpiresAt     time.Time                 `json:"expires_at"`
+	PaidAt        *time.Time                `json:"paid_at"`
+	CreatedAt     time.Time                 `json:"created_at"`
+	UpdatedAt     time.Time                 `json:"updated_at"`
+	Transfers     []*IntentTransferResponse `json:"transfers,omitempty"`
+}
+
+func intentResponse(intent *PaymentIntent) *IntentResponse {
+	exp := -int32(intent.Decimals)
+	return &IntentResponse{
+		ID:            intent.ID.String(),
+		Reference:     intent.Reference,
+		Chain:         intent.ChainID,
+		Symbol:        intent.Symbol,
+		Payer:         intent.PayerAddress,
+		Recipient:     intent.RecipientAddress,
+		MinAmount:     intent.MinAmount.Shift(exp).String(),
+		MaxAmount:     intent.MaxAmount.Shift(exp).String(),
+		Received:      intent.Received.Shift(exp).String(),
+		ValueDecimals: intent.Decimals,
+		Status:        intent.Status,
+		Overpaid:      intent.Received.GreaterThan(intent.MaxAmount),
+		ExpiresAt:     intent.ExpiresAt,
+		PaidAt:        intent.PaidAt,
+		CreatedAt:     intent.CreatedAt,
+		UpdatedAt:     intent.UpdatedAt,
+	}
+}
+
+// parseTokenAmount converts a whole token amount to base units, rejecting amounts finer than the token allows.
+func parseTokenAmount(amountStr string, decimals int) (decimal.Decimal, error) {
+	amount, err := decimal.NewFromString(amountStr)
+	if err != nil {
+		return decimal.Zero, err
+	}
+	amount = amount.Shift(int32(decimals))
+	if !amount.Equal(amount.Truncate(0)) {
+		return decimal.Zero, fmt.Errorf("more than %d decimals", decimals)
+	}
+	return amount, nil
+}
+
+// CreateIntent opens a payment intent expecting an asset from a payer to a recipient.
+// Every open intent is matched against every indexed transfer, so only the backend holding the admin token opens them.
+func (c *Controller) CreateIntent(w http.ResponseWriter, r *http.Request) {
+	req := &CreateIntentRequest{}
+	err := json.NewDecoder(r.Body).Decode(req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	chain, ok := c.Chains.Lookup(req.Chain)
+	if !ok {
+		http.Error(w, "unknown chain", http.StatusBadRequest)
+		return
+	}
+	asset, ok := chain.Asset(req.Symbol)
+	if !ok {
+		http.Error(w, "unknown symbol", http.StatusBadRequest)
+		return
+	}
+	if !common.IsHexAddress(req.Payer) || !common.IsHexAddress(req.Recipient) {
+		http.Error(w, "invalid payer or recipient", http.StatusBadRequest)
+		return
+	}
+	minAmount, err := parseTokenAmount(req.MinAmount, asset.Decimals)
+	if err != nil || !minAmount.IsPositive() {
+		http.Error(w, "invalid min_amount", http.StatusBadRequest)
+		return
+	}
+	maxAmount := minAmount
+	if req.MaxAmount != "" {
+		maxAmount, err = parseTokenAmount(req.MaxAmount, asset.Decimals)
+		if err != nil || maxAmount.LessThan(minAmount) {
+			http.Error(w, "invalid max_amount", http.StatusBadRequest)
+			return
+		}
+	}
+	expiresAt := time.Now().Add(DefaultIntentExpiry)
+	if req.ExpiresAt != nil {
+		expiresAt = *req.ExpiresAt
+	} else if req.ExpiresIn > 0 {
+		expiresAt = time.Now().Add(time.Duration(req.ExpiresIn) * time.Second)
+	}
+	if !expiresAt.After(time.Now()) {
+		http.Error(w, "expiry in the past", http.StatusBadRequest)
+		return
+	}
+
+	intent, err := CreateIntent(&PaymentIntent{
+		Reference:        req.Reference,
+		ChainID:          chain.ID,
+		Symbol:           asset.Symbol,
+		Decimals:         asset.Decimals,
+		PayerAddress:     common.HexToAddress(req.Payer).Hex(),
+		RecipientAddress: common.HexToAddress(req.Recipient).Hex(),
+		MinAmount:        minAmount,
+		MaxAmount:        maxAmount,
+		ExpiresAt:        expiresAt,
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	err = json.NewEncoder(w).Encode(intentResponse(intent))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+// intent looks up the intent named in the URL, writing the error response when it can't.
+func (c *Controller) intent(w http.ResponseWriter, r *http.Request) (*PaymentIntent, bool) {
+	id, err := uuid.FromString(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "invalid id", http.StatusBadRequest)
+		return nil, false
+	}
+	intent, err := Intent(id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return nil, false
+	}
+	if intent == nil {
+		http.Error(w, "unknown intent", http.StatusNotFound)
+		return nil, false
+	}
+	return intent, true
+}
+
+// Intent returns a payment intent and the transfers that paid towards it.
+func (c *Controller) Intent(w http.ResponseWriter, r *http.Request) {
+	intent, ok := c.intent(w, r)
+	if !ok {
+		return
+	}
+	records, err := IntentTransfers(intent.ID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	result := intentResponse(intent)
+	result.Transfers = []*IntentTransferResponse{}
+	for _, record := range records {
+		result.Transfers = append(result.Transfers, &IntentTransferResponse{
+			TxHash:    record.TxID,
+			LogIndex:  record.LogIndex,
+			Block:     record.Block,
+			Value:     record.Amount.Shift(-int32(record.Decimals)).String(),
+			ValueInt:  record.Amount.String(),
+			Timestamp: record.Timestamp,
+		})
+	}
+
+	err = json.NewEncoder(w).Encode(result)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+// Intents lists the newest payment intents, filtered by ?chain=, ?payer=, ?status= and ?reference=.
+// It lists every payer's intents, so it sits behind the admin token.
+func (c *Controller) Intents(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	filter := IntentFilter{
+		Status:    IntentStatus(query.Get("status")),
+		Reference: query.Get("reference"),
+		Limit:     DefaultIntentsLimit,
+	}
+	if chainStr := query.Get("chain"); chainStr != "" {
+		chain, ok := c.Chains.Lookup(chainStr)
+		if !ok {
+			http.Error(w, "unknown chain", http.StatusBadRequest)
+			return
+		}
+		filter.ChainID = chain.ID
+	}
+	if payer := query.Get("payer"); payer != "" {
+		if !common.IsHexAddress(payer) {
+			http.Error(w, "invalid payer", http.StatusBadRequest)
+			return
+		}
+		filter.Payer = common.HexToAddress(payer).Hex()
+	}
+	if limitStr := query.Get("limit"); limitStr != "" {
+		var err error
+		filter.Limit, err = strconv.Atoi(limitStr)
+		if err != nil || filter.Limit < 1 {
+			http.Error(w, "invalid limit", http.StatusBadRequest)
+			return
+		}
+		if filter.Limit > MaxIntentsLimit {
+			filter.Limit = MaxIntentsLimit
+		}
+	}
+
+	intents, err := Intents(filter)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	result := []*IntentResponse{}
+	for _, intent := range intents {
+		result = append(result, intentResponse(intent))
+	}
+
+	err = json.NewEncoder(w).Encode(result)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+// StreamIntent sends the intent as a server-sent event now and whenever it changes, until it is paid
+// or the client goes away. Intents are updated by the leader, so the stream polls the database.
+func (c *Controller) StreamIntent(w http.ResponseWriter, r *http.Request) {
+	intent, ok := c.intent(w, r)
+	if !ok {
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+
+	poll := time.NewTicker(IntentStreamInterval)
+	defer poll.Stop()
+	heartbeat := time.NewTicker(IntentStreamHeartbeat)
+	defer heartbeat.Stop()
+	var sent time.Time
+	for {
+		if !intent.UpdatedAt.Equal(sent) {
+			data, err := json.Marshal(intentResponse(intent))
+			if err != nil {
+				return
+			}
+			_, err = fmt.Fprintf(w, "event: intent\ndata: %s\n\n", data)
+			if err != nil {
+				return
+			}
+			flusher.Flush()
+			sent = intent.UpdatedAt
+		}
+		if intent.Status == IntentPaid {
+			return
+		}
+
+		select {
+		case <-r.Context().Done():
+			return
+		case <-heartbeat.C:
+			_, err := fmt.Fprint(w, ": heartbeat\n\n")
+			if err != nil {
+				return
+			}
+			flusher.Flush()
+		case <-poll.C:
+			latest, err := Intent(intent.ID)
+			if err != nil {
+				log.Warn().Err(err).Str("intent", intent.ID.String()).Msg("poll intent")
+				continue
+			}
+			if latest != nil {
+				intent = latest
+			}
+		}
+	}
+}
@@ -0,0 +1,274 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/gofrs/uuid"
+	"github.com/shopspring/decimal"
+)
+
+var (
+	testPayer     = common.HexToAddress("0x1111111111111111111111111111111111111111")
+	testRecipient = common.HexToAddress("0x2222222222222222222222222222222222222222")
+	testStranger  = common.HexToAddress("0x3333333333333333333333333333333333333333")
+	testOpenedAt  = time.Unix(1700000000, 0)
+)
+
+func testIntent(id byte, createdAt time.Time, min int64, max int64) *PaymentIntent {
+	return &PaymentIntent{
+		ID:               uuid.UUID{id},
+		ChainID:          1,
+		Symbol:           "SUPS",
+		PayerAddress:     testPayer.Hex(),
+		RecipientAddress: testRecipient.Hex(),
+		MinAmount:        decimal.NewFromInt(min),
+		MaxAmount:        decimal.NewFromInt(max),
+		Received:         decimal.Zero,
+		Status:           IntentPending,
+		ExpiresAt:        createdAt.Add(time.Hour),
+		CreatedAt:        createdAt,
+	}
+}
+
+func testTransfer(block uint64, logIndex uint, amount int64, minedAt time.Time) *Transfer {
+	return &Transfer{
+		Block:       block,
+		LogIndex:    logIndex,
+		ChainID:     1,
+		Symbol:      "SUPS",
+		FromAddress: testPayer,
+		ToAddress:   testRecipient,
+		Amount:      decimal.NewFromInt(amount),
+		CreatedAt:   uint64(minedAt.Unix()),
+	}
+}
+
+func TestNextStatus(t *testing.T) {
+	tests := []struct {
+		name     string
+		received int64
+		now      time.Time
+		want     IntentStatus
+	}{
+		{"nothing received", 0, testOpenedAt.Add(time.Minute), IntentPending},
+		{"underpaid", 40, testOpenedAt.Add(time.Minute), IntentUnderpaid},
+		{"paid exactly", 100, testOpenedAt.Add(time.Minute), IntentPaid},
+		{"overpaid", 250, testOpenedAt.Add(time.Minute), IntentPaid},
+		{"expired unpaid", 0, testOpenedAt.Add(2 * time.Hour), IntentExpired},
+		{"expired underpaid", 40, testOpenedAt.Add(2 * time.Hour), IntentExpired},
+		{"paid after expiry", 100, testOpenedAt.Add(2 * time.Hour), IntentPaid},
+		{"at expiry", 0, testOpenedAt.Add(time.Hour), IntentPending},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			intent := testIntent(1, testOpenedAt, 100, 200)
+			intent.Received = decimal.NewFromInt(tt.received)
+			got := intent.NextStatus(tt.now)
+			if got != tt.want {
+				t.Errorf("NextStatus() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAccepts(t *testing.T) {
+	tests := []struct {
+		name     string
+		status   IntentStatus
+		transfer func(*Transfer)
+		want     bool
+	}{
+		{"matching transfer", IntentPending, func(*Transfer) {}, true},
+		{"underpaid intent", IntentUnderpaid, func(*Transfer) {}, true},
+		{"paid intent", IntentPaid, func(*Transfer) {}, false},
+		{"other chain", IntentPending, func(tr *Transfer) { tr.ChainID = 56 }, false},
+		{"other symbol", IntentPending, func(tr *Transfer) { tr.Symbol = "ETH" }, false},
+		{"other payer", IntentPending, func(tr *Transfer) { tr.FromAddress = testStranger }, false},
+		{"other recipient", IntentPending, func(tr *Transfer) { tr.ToAddress = testStranger }, false},
+		{"mined before creation", IntentPending, func(tr *Transfer) { tr.CreatedAt = uint64(testOpenedAt.Add(-time.Second).Unix()) }, false},
+		{"mined after expiry", IntentPending, func(tr *Transfer) { tr.CreatedAt = uint64(testOpenedAt.Add(time.Hour + time.Second).Unix()) }, false},
+		{"mined at expiry", IntentPending, func(tr *Transfer) { tr.CreatedAt = uint64(testOpenedAt.Add(time.Hour).Unix()) }, true},
+		{"expired intent, mined before expiry", IntentExpired, func(*Transfer) {}, true},
+		{"expired intent, mined after expiry", IntentExpired, func(tr *Transfer) { tr.CreatedAt = uint64(testOpenedAt.Add(2 * time.Hour).Unix()) }, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			intent := testIntent(1, testOpenedAt, 100, 200)
+			intent.Status = tt.status
+			transfer := testTransfer(10, 0, 50, testOpenedAt.Add(time.Minute))
+			tt.transfer(transfer)
+			got := intent.Accepts(transfer)
+			if got != tt.want {
+				t.Errorf("Accepts() = %t, want %t", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMatchIntents(t *testing.T) {
+	minedAt := testOpenedAt.Add(10 * time.Minute)
+	now := testOpenedAt.Add(20 * time.Minute)
+	tests := []struct {
+		name      string
+		intents   []*PaymentIntent
+		transfers []*Transfer
+		now       time.Time
+		// matches lists the ID byte of the intent each matched transfer went to, in chain order.
+		matches  []byte
+		statuses map[byte]IntentStatus
+		received map[byte]int64
+	}{
+		{
+			name:      "no intents",
+			transfers: []*Transfer{testTransfer(10, 0, 100, minedAt)},
+			now:       now,
+			matches:   []byte{},
+		},
+		{
+			name:      "oldest intent first",
+			intents:   []*PaymentIntent{testIntent(2, testOpenedAt.Add(time.Minute), 100, 100), testIntent(1, testOpenedAt, 100, 100)},
+			transfers: []*Transfer{testTransfer(10, 0, 100, minedAt)},
+			now:       now,
+			matches:   []byte{1},
+			statuses:  map[byte]IntentStatus{1: IntentPaid, 2: IntentPending},
+			received:  map[byte]int64{1: 100, 2: 0},
+		},
+		{
+			name:      "paid intent passes the next transfer on",
+			intents:   []*PaymentIntent{testIntent(1, testOpenedAt, 100, 100), testIntent(2, testOpenedAt.Add(time.Minute), 100, 100)},
+			transfers: []*Transfer{testTransfer(11, 0, 100, minedAt), testTransfer(10, 3, 100, minedAt)},
+			now:       now,
+			matches:   []byte{1, 2},
+			statuses:  map[byte]IntentStatus{1: IntentPaid, 2: IntentPaid},
+			received:  map[byte]int64{1: 100, 2: 100},
+		},
+		{
+			name:      "chain order within a block",
+			intents:   []*PaymentIntent{testIntent(1, testOpenedAt, 100, 100), testIntent(2, testOpenedAt.Add(time.Minute), 10, 10)},
+			transfers: []*Transfer{testTransfer(10, 5, 10, minedAt), testTransfer(10, 1, 100, minedAt)},
+			now:       now,
+			matches:   []byte{1, 2},
+			statuses:  map[byte]IntentStatus{1: IntentPaid, 2: IntentPaid},
+			received:  map[byte]int64{1: 100, 2: 10},
+		},
+		{
+			name:      "underpaid then paid",
+			intents:   []*PaymentIntent{testIntent(1, testOpenedAt, 100, 150)},
+			transfers: []*Transfer{testTransfer(10, 0, 40, minedAt), testTransfer(12, 0, 80, minedAt)},
+			now:       now,
+			matches:   []byte{1, 1},
+			statuses:  map[byte]IntentStatus{1: IntentPaid},
+			received:  map[byte]int64{1: 120},
+		},
+		{
+			name:      "underpaid",
+			intents:   []*PaymentIntent{testIntent(1, testOpenedAt, 100, 150)},
+			transfers: []*Transfer{testTransfer(10, 0, 40, minedAt)},
+			now:       now,
+			matches:   []byte{1},
+			statuses:  map[byte]IntentStatus{1: IntentUnderpaid},
+			received:  map[byte]int64{1: 40},
+		},
+		{
+			name:      "late indexed transfer pays an expired intent",
+			intents:   []*PaymentIntent{expiredIntent(1)},
+			transfers: []*Transfer{testTransfer(10, 0, 100, minedAt)},
+			now:       testOpenedAt.Add(3 * time.Hour),
+			matches:   []byte{1},
+			statuses:  map[byte]IntentStatus{1: IntentPaid},
+			received:  map[byte]int64{1: 100},
+		},
+		{
+			name:      "late indexed partial payment stays expired",
+			intents:   []*PaymentIntent{expiredIntent(1)},
+			transfers: []*Transfer{testTransfer(10, 0, 40, minedAt)},
+			now:       testOpenedAt.Add(3 * time.Hour),
+			matches:   []byte{1},
+			statuses:  map[byte]IntentStatus{1: IntentExpired},
+			received:  map[byte]int64{1: 40},
+		},
+		{
+			name:      "transfer mined after expiry",
+			intents:   []*PaymentIntent{expiredIntent(1)},
+			transfers: []*Transfer{testTransfer(10, 0, 100, testOpenedAt.Add(2*time.Hour))},
+			now:       testOpenedAt.Add(3 * time.Hour),
+			matches:   []byte{},
+			statuses:  map[byte]IntentStatus{1: IntentExpired},
+			received:  map[byte]int64{1: 0},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			byID := map[byte]*PaymentIntent{}
+			for _, intent := range tt.intents {
+				byID[intent.ID[0]] = intent
+			}
+			matches := MatchIntents(tt.intents, tt.transfers, tt.now)
+			if len(matches) != len(tt.matches) {
+				t.Fatalf("MatchIntents() matched %d transfers, want %d", len(matches), len(tt.matches))
+			}
+			for i, match := range matches {
+				if match.Intent.ID[0] != tt.matches[i] {
+					t.Errorf("match %d went to intent %d, want %d", i, match.Intent.ID[0], tt.matches[i])
+				}
+				if i > 0 && matches[i-1].Transfer.Block > match.Transfer.Block {
+					t.Errorf("match %d is out of chain order", i)
+				}
+			}
+			for id, status := range tt.statuses {
+				intent := byID[id]
+				if intent.Status != status {
+					t.Errorf("intent %d status = %s, want %s", id, intent.Status, status)
+				}
+				if (intent.Status == IntentPaid) != (intent.PaidAt != nil) {
+					t.Errorf("intent %d paid at = %v with status %s", id, intent.PaidAt, intent.Status)
+				}
+				if !intent.Received.Equal(decimal.NewFromInt(tt.received[id])) {
+					t.Errorf("intent %d received = %s, want %d", id, intent.Received, tt.received[id])
+				}
+			}
+		})
+	}
+}
+
+func expiredIntent(id byte) *PaymentIntent {
+	intent := testIntent(id, testOpenedAt, 100, 100)
+	intent.Status = IntentExpired
+	return intent
+}
+
+func TestRecount(t *testing.T) {
+	paidAt := testOpenedAt.Add(5 * time.Minute)
+	tests := []struct {
+		name     string
+		received int64
+		now      time.Time
+		want     IntentStatus
+		paid     bool
+	}{
+		{"reorg leaves it paid", 120, testOpenedAt.Add(10 * time.Minute), IntentPaid, true},
+		{"reorg leaves it underpaid", 40, testOpenedAt.Add(10 * time.Minute), IntentUnderpaid, false},
+		{"reorg takes everything", 0, testOpenedAt.Add(10 * time.Minute), IntentPending, false},
+		{"reorg after expiry", 40, testOpenedAt.Add(2 * time.Hour), IntentExpired, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			intent := testIntent(1, testOpenedAt, 100, 200)
+			intent.Received = decimal.NewFromInt(150)
+			intent.Status = IntentPaid
+			intent.PaidAt = &paidAt
+			intent.Recount(decimal.NewFromInt(tt.received), tt.now)
+			if intent.Status != tt.want {
+				t.Errorf("status = %s, want %s", intent.Status, tt.want)
+			}
+			if (intent.PaidAt != nil) != tt.paid {
+				t.Errorf("paid at = %v, want paid %t", intent.PaidAt, tt.paid)
+			}
+			if !intent.Received.Equal(decimal.NewFromInt(tt.received)) {
+				t.Errorf("received = %s, want %d", intent.Received, tt.received)
+			}
+		})
+	}
+}
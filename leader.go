@@ -0,0 +1,178 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	leaderGauge = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "xsyn_pricefeed_leader",
+		Help: "1 while this replica holds the leader lock and runs the scrapers, 0 otherwise.",
+	})
+	leaderElectionsCounter = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "xsyn_pricefeed_leader_elections_total",
+		Help: "How many times this replica became the leader.",
+	})
+)
+
+func init() {
+	prometheus.MustRegister(leaderGauge, leaderElectionsCounter)
+}
+
+const KeyLeader KVKey = "leader"
+
+// DefaultLeaderLockKey is the Postgres advisory lock the replicas of a deployment compete for.
+const DefaultLeaderLockKey = 7359164
+
+// Leader elects one replica among those sharing a database with a session level advisory lock.
+// The lock is held on a dedicated connection and is released by Postgres when that session ends,
+// so a crashed leader is replaced on the next attempt of another replica.
+// The leader pings its connection every third of Lease and steps down on the first ping that fails.
+type Leader struct {
+	Key      int64
+	Lease    time.Duration
+	Instance string
+
+	mu       sync.Mutex
+	isLeader bool
+	since    time.Time
+}
+
+type LeaderStatus struct {
+	Instance string     `json:"instance"`
+	IsLeader bool       `json:"is_leader"`
+	Since    *time.Time `json:"since"`
+	// Leader is the instance that was last elected, on any replica.
+	Leader string `json:"leader,omitempty"`
+}
+
+// InstanceName identifies this replica in the leader status.
+func InstanceName() string {
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "unknown"
+	}
+	return fmt.Sprintf("%s-%d", hostname, os.Getpid())
+}
+
+// Run competes for the lock until ctx is cancelled. While this replica is the leader, lead runs
+// with a context that is cancelled when leadership is lost, and it must return before the next attempt.
+func (l *Leader) Run(ctx context.Context, lead func(ctx context.Context)) {
+	log.Info().Str("instance", l.Instance).Int64("lock_key", l.Key).Msg("start leader election")
+	for {
+		held, err := l.acquire(ctx)
+		if err != nil {
+			log.Err(err).Str("instance", l.Instance).Msg("acquire leader lock")
+		}
+		if held != nil {
+			l.lead(ctx, held, lead)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(l.Lease):
+		}
+	}
+}
+
+// acquire returns the connection holding the lock, or nil when another replica holds it.
+func (l *Leader) acquire(ctx context.Context) (*pgxpool.Conn, error) {
+	held, err := conn.Acquire(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("acquire connection: %w", err)
+	}
+	var ok bool
+	err = held.QueryRow(ctx, `SELECT pg_try_advisory_lock($1)`, l.Key).Scan(&ok)
+	if err != nil {
+		held.Release()
+		return nil, fmt.Errorf("try advisory lock: %w", err)
+	}
+	if !ok {
+		held.Release()
+		return nil, nil
+	}
+	return held, nil
+}
+
+func (l *Leader) lead(ctx context.Context, held *pgxpool.Conn, lead func(ctx context.Context)) {
+	l.setLeader(true)
+	leaderElectionsCounter.Inc()
+	log.Info().Str("instance", l.Instance).Msg("elected leader")
+	err := Set(KeyLeader, l.Instance)
+	if err != nil {
+		log.Err(err).Msg("record leader")
+	}
+
+	leadCtx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		lead(leadCtx)
+	}()
+
+	renew := time.NewTicker(l.Lease / 3)
+	defer renew.Stop()
+loop:
+	for {
+		select {
+		case <-ctx.Done():
+			break loop
+		case <-done:
+			break loop
+		case <-renew.C:
+			pingCtx, pingCancel := context.WithTimeout(ctx, l.Lease/3)
+			err := held.Ping(pingCtx)
+			pingCancel()
+			if err == nil {
+				continue
+			}
+			// The session may be gone and the lock with it, so another replica can already be leading.
+			log.Err(err).Str("instance", l.Instance).Msg("leader lost its lock connection")
+			break loop
+		}
+	}
+
+	cancel()
+	<-done
+	l.setLeader(false)
+	log.Info().Str("instance", l.Instance).Msg("stepped down as leader")
+
+	unlockCtx, unlockCancel := context.WithTimeout(context.Background(), l.Lease/3)
+	defer unlockCancel()
+	_, err = held.Exec(unlockCtx, `SELECT pg_advisory_unlock($1)`, l.Key)
+	if err != nil {
+		// Closing the session releases the lock too.
+		held.Conn().Close(unlockCtx)
+	}
+	held.Release()
+}
+
+func (l *Leader) setLeader(isLeader bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.isLeader = isLeader
+	l.since = time.Now()
+	if isLeader {
+		leaderGauge.Set(1)
+	} else {
+		leaderGauge.Set(0)
+	}
+}
+
+func (l *Leader) Status() *LeaderStatus {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	result := &LeaderStatus{Instance: l.Instance, IsLeader: l.isLeader}
+	if l.isLeader {
+		since := l.since
+		result.Since = &since
+	}
+	return result
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/jackc/pgx/v5"
+)
+
+const DefaultLogRange = 5000
+const MinLogRange = 1
+const MaxLogRange = 100000
+
+// logRangeErrors are substrings of the errors providers return when an eth_getLogs
+// query covers too many blocks or results. Retrying with a smaller range fixes them.
+var logRangeErrors = []string{
+	"query returned more than",
+	"log response size exceeded",
+	"response size exceeded",
+	"block range is too wide",
+	"block range too large",
+	"exceed maximum block range",
+	"range is too large",
+	"query timeout exceeded",
+	"too many blocks",
+}
+
+func IsLogRangeError(err error) bool {
+	if err == nil {
+		return false
+	}
+	msg := strings.ToLower(err.Error())
+	for _, s := range logRangeErrors {
+		if strings.Contains(msg, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// LogRanges remembers the largest eth_getLogs block range that worked for each token, up to the
+// scrape_range_sups setting. Ranges shrink whenever a provider rejects a query and grow back slowly after
+// successes. They are kept in kv, so a restart doesn't learn them again from rejected queries.
+type LogRanges struct {
+	sync.Mutex
+	sizes map[string]int64
+}
+
+var logRanges = &LogRanges{sizes: map[string]int64{}}
+
+func logRangeKey(chainID int64, tokenAddr common.Address) string {
+	return fmt.Sprintf("%d:%s", chainID, tokenAddr.Hex())
+}
+
+// LogRangeLimit is the scrape_range_sups setting, which caps every learned range.
+func LogRangeLimit() (int64, error) {
+	limit, err := GetInt(KeyScrapeRangeSups, DefaultScrapeRangeSups)
+	if err != nil {
+		return 0, fmt.Errorf("get scrape range: %w", err)
+	}
+	return int64(limit), nil
+}
+
+// learned returns the range learned for a token, reading it from kv the first time, or 0 when there is none.
+func (r *LogRanges) learned(chainID int64, tokenAddr common.Address) (int64, error) {
+	key := logRangeKey(chainID, tokenAddr)
+	r.Lock()
+	size, ok := r.sizes[key]
+	r.Unlock()
+	if ok {
+		return size, nil
+	}
+	value, err := Get(KeyLogRange(chainID, tokenAddr))
+	if errors.Is(err, pgx.ErrNoRows) {
+		value = "0"
+	} else if err != nil {
+		return 0, fmt.Errorf("get log range: %w", err)
+	}
+	size, err = strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("parse log range: %w", err)
+	}
+	r.Lock()
+	defer r.Unlock()
+	if current, ok := r.sizes[key]; ok {
+		return current, nil
+	}
+	r.sizes[key] = size
+	return size, nil
+}
+
+// store remembers a new range for a token. The caller holds the lock, so concurrent updates are persisted in the
+// order they were made. Failing to persist it only costs relearning it after a restart.
+func (r *LogRanges) store(chainID int64, tokenAddr common.Address, size int64) {
+	r.sizes[logRangeKey(chainID, tokenAddr)] = size
+	err := Set(KeyLogRange(chainID, tokenAddr), strconv.FormatInt(size, 10))
+	if err != nil {
+		log.Warn().Err(err).Int64("chain_id", chainID).Str("contract", tokenAddr.Hex()).Msg("persist log range")
+	}
+}
+
+// Size is the range to query for a token: the learned one, or limit until a query was rejected.
+func (r *LogRanges) Size(chainID int64, tokenAddr common.Address, limit int64) (int64, error) {
+	size, err := r.learned(chainID, tokenAddr)
+	if err != nil {
+		return 0, err
+	}
+	return clampLogRange(size, limit), nil
+}
+
+func clampLogRange(size int64, limit int64) int64 {
+	if size < MinLogRange || size > limit {
+		return limit
+	}
+	return size
+}
+
+// Shrink narrows the range to size after a provider rejected a query of it. A concurrent Shrink to a smaller
+// size or a Grow is never overwritten by a stale read, as the compare and the store happen under one lock.
+func (r *LogRanges) Shrink(chainID int64, tokenAddr common.Address, size int64) {
+	if size < MinLogRange {
+		size = MinLogRange
+	}
+	_, err := r.learned(chainID, tokenAddr)
+	if err != nil {
+		log.Warn().Err(err).Int64("chain_id", chainID).Str("contract", tokenAddr.Hex()).Msg("shrink log range")
+		return
+	}
+	r.Lock()
+	defer r.Unlock()
+	current := r.sizes[logRangeKey(chainID, tokenAddr)]
+	if current < MinLogRange || size < current {
+		r.store(chainID, tokenAddr, size)
+	}
+}
+
+// Grow widens the range by a quarter, up to limit, after a query of the full size succeeded.
+func (r *LogRanges) Grow(chainID int64, tokenAddr common.Address, limit int64) {
+	_, err := r.learned(chainID, tokenAddr)
+	if err != nil {
+		log.Warn().Err(err).Int64("chain_id", chainID).Str("contract", tokenAddr.Hex()).Msg("grow log range")
+		return
+	}
+	r.Lock()
+	defer r.Unlock()
+	current := clampLogRange(r.sizes[logRangeKey(chainID, tokenAddr)], limit)
+	next := current + current/4 + 1
+	if next > limit {
+		next = limit
+	}
+	if next != current {
+		r.store(chainID, tokenAddr, next)
+	}
+}
+
+// FilterLogsAdaptive runs query over [fromBlock, toBlock] in chunks of the remembered range size for the token,
+// recursively halving any chunk the provider rejects as too large.
+func FilterLogsAdaptive(ctx context.Context, client *Node, query ethereum.FilterQuery, fromBlock int64, toBlock int64, chainID int64, tokenAddr common.Address) ([]types.Log, error) {
+	limit, err := LogRangeLimit()
+	if err != nil {
+		return nil, err
+	}
+	result := []types.Log{}
+	for start := fromBlock; start <= toBlock; {
+		size, err := logRanges.Size(chainID, tokenAddr, limit)
+		if err != nil {
+			return nil, err
+		}
+		end := start + size - 1
+		if end > toBlock {
+			end = toBlock
+		}
+		logs, split, err := filterLogsSplit(ctx, client, query, start, end, chainID, tokenAddr)
+		if err != nil {
+			return nil, err
+		}
+		if !split && end-start+1 == size {
+			logRanges.Grow(chainID, tokenAddr, limit)
+		}
+		result = append(result, logs...)
+		start = end + 1
+	}
+	return result, nil
+}
+
+func filterLogsSplit(ctx context.Context, client *Node, query ethereum.FilterQuery, fromBlock int64, toBlock int64, chainID int64, tokenAddr common.Address) ([]types.Log, bool, error) {
+	query.FromBlock = big.NewInt(fromBlock)
+	query.ToBlock = big.NewInt(toBlock)
+	logs, err := client.FilterLogs(ctx, query)
+	if err == nil {
+		return logs, false, nil
+	}
+	if !IsLogRangeError(err) || fromBlock >= toBlock {
+		return nil, false, fmt.Errorf("filter logs %d-%d: %w", fromBlock, toBlock, err)
+	}
+
+	mid := fromBlock + (toBlock-fromBlock)/2
+	logRanges.Shrink(chainID, tokenAddr, mid-fromBlock+1)
+	log.Warn().Err(err).
+		Int64("from_block", fromBlock).
+		Int64("to_block", toBlock).
+		Int64("chain_id", chainID).
+		Str("contract", tokenAddr.Hex()).
+		Msg("log range rejected, splitting")
+
+	left, _, err := filterLogsSplit(ctx, client, query, fromBlock, mid, chainID, tokenAddr)
+	if err != nil {
+		return nil, true, err
+	}
+	right, _, err := filterLogsSplit(ctx, client, query, mid+1, toBlock, chainID, tokenAddr)
+	if err != nil {
+		return nil, true, err
+	}
+	return append(left, right...), true, nil
+}
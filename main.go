@@ -1,11 +1,15 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"math/big"
 	"net/http"
 	"os"
 	"strconv"
+	"strings"
 	"time"
 	"xsyn-pricefeed/ethusd"
 	"xsyn-pricefeed/supseth"
@@ -22,14 +26,15 @@ import (
 	"github.com/urfave/cli/v2"
 	cache "github.com/victorspringer/http-cache"
 	"github.com/victorspringer/http-cache/adapter/memory"
+	"golang.org/x/sync/errgroup"
 
 	"github.com/ethereum/go-ethereum/accounts/abi/bind"
 	"github.com/ethereum/go-ethereum/common"
-	"github.com/ethereum/go-ethereum/ethclient"
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
 	"github.com/go-chi/cors"
 	"github.com/go-chi/docgen"
+	"github.com/gofrs/uuid"
 	"github.com/shopspring/decimal"
 )
 
@@ -43,96 +48,570 @@ func main() {
 			{
 				Name:  "serve",
 				Usage: "serve price feed API",
-				Flags: []cli.Flag{
+				Flags: append([]cli.Flag{
 					&cli.StringFlag{Name: "log_format", Value: "console", Usage: "log formatting (json or console)", EnvVars: []string{"LOG_FORMAT"}},
 					&cli.IntFlag{Name: "ttl_seconds", Value: 300, Usage: "seconds to cache the responses", EnvVars: []string{"TTL_SECONDS"}},
 					&cli.IntFlag{Name: "port", Value: 8080, Usage: "Server port to host on", EnvVars: []string{"PORT"}},
-					&cli.StringFlag{Name: "rpc_url", Required: true, Usage: "ETH node RPC URL", EnvVars: []string{"RPC_URL"}},
-					&cli.StringFlag{Name: "goerli_rpc_url", Required: true, Usage: "Goerli ETH node RPC URL", EnvVars: []string{"GOERLI_RPC_URL"}},
+					&cli.IntFlag{Name: "grpc_port", Value: 9090, Usage: "Port to serve the gRPC API on, 0 to disable; calls need the admin_token", EnvVars: []string{"GRPC_PORT"}},
+					&cli.StringFlag{Name: "rpc_url", Required: true, Usage: "Comma separated mainnet RPC URLs used for prices, in order of preference", EnvVars: []string{"RPC_URL"}},
+					&cli.IntFlag{Name: "price_quorum", Usage: "How many price endpoints must agree on a price read, 0 to use the first healthy one", EnvVars: []string{"PRICE_QUORUM"}},
+					&cli.Uint64Flag{Name: "rpc_max_lag", Value: DefaultMaxLag, Usage: "Blocks an RPC endpoint may trail the others before it is taken out of rotation", EnvVars: []string{"RPC_MAX_LAG"}},
+					&cli.Float64Flag{Name: "rpc_rate_limit", Usage: "Requests per second allowed to each RPC endpoint, 0 for no limit", EnvVars: []string{"RPC_RATE_LIMIT"}},
+					&cli.IntFlag{Name: "rpc_burst", Value: 10, Usage: "Requests sent to an RPC endpoint at once before the rate limit applies", EnvVars: []string{"RPC_BURST"}},
+					&cli.IntFlag{Name: "rpc_retries", Value: DefaultRPCRetries, Usage: "Retries of a rate limited request before moving to the next endpoint", EnvVars: []string{"RPC_RETRIES"}},
+					&cli.DurationFlag{Name: "rpc_health_interval", Value: 15 * time.Second, Usage: "Wait between RPC endpoint health checks", EnvVars: []string{"RPC_HEALTH_INTERVAL"}},
 					&cli.StringFlag{Name: "db_url", Required: true, Usage: "Database connection string", EnvVars: []string{"DATABASE_URL"}},
-					&cli.StringFlag{Name: "token_addr", Value: "0xCF39360b26a7E54f6c456E69640671Fc5e774FA2", Usage: "Set the token addr (mainnet)", EnvVars: []string{"TOKEN_ADDR"}},
-					&cli.StringFlag{Name: "goerli_token_addr", Value: "0xfF30d2c046AEb5FA793138265Cc586De814d0040", Usage: "Set the token addr (goerli)", EnvVars: []string{"GOERLI_TOKEN_ADDR"}},
-					&cli.BoolFlag{Name: "scrape_mainnet_eth", Value: true, Usage: "Scrape mainnet eth txes", EnvVars: []string{"SCRAPE_MAINNET_ETH"}},
-					&cli.BoolFlag{Name: "scrape_mainnet_sups", Value: true, Usage: "Scrape mainnet sups txes", EnvVars: []string{"SCRAPE_MAINNET_SUPS"}},
-					&cli.BoolFlag{Name: "scrape_goerli_eth", Value: true, Usage: "Scrape goerli eth txes", EnvVars: []string{"SCRAPE_GOERLI_ETH"}},
-					&cli.BoolFlag{Name: "scrape_goerli_sups", Value: true, Usage: "Scrape goerli sups txes", EnvVars: []string{"SCRAPE_GOERLI_SUPS"}},
-				},
+					&cli.DurationFlag{Name: "head_poll_interval", Value: 4 * time.Second, Usage: "Wait between block number polls when head subscriptions are unavailable", EnvVars: []string{"HEAD_POLL_INTERVAL"}},
+					&cli.BoolFlag{Name: "leader_election", Value: true, Usage: "Only scrape on the replica holding the leader lock, turn off for a single replica", EnvVars: []string{"LEADER_ELECTION"}},
+					&cli.Int64Flag{Name: "leader_lock_key", Value: DefaultLeaderLockKey, Usage: "Postgres advisory lock key the replicas compete for", EnvVars: []string{"LEADER_LOCK_KEY"}},
+					&cli.DurationFlag{Name: "leader_lease", Value: 15 * time.Second, Usage: "How long the leader keeps scraping without reaching the database, and the wait between election attempts", EnvVars: []string{"LEADER_LEASE"}},
+					&cli.DurationFlag{Name: "scrape_interval", Value: 12 * time.Second, Usage: "Wait between scrapes of each asset and block height refreshes", EnvVars: []string{"SCRAPE_INTERVAL"}},
+					&cli.DurationFlag{Name: "price_interval", Value: 60 * time.Second, Usage: "Wait between price recordings", EnvVars: []string{"PRICE_INTERVAL"}},
+					&cli.DurationFlag{Name: "scrape_jitter", Value: 2 * time.Second, Usage: "Most random delay added to each wait", EnvVars: []string{"SCRAPE_JITTER"}},
+					&cli.DurationFlag{Name: "intent_expiry_interval", Value: 30 * time.Second, Usage: "Wait between marking overdue payment intents expired", EnvVars: []string{"INTENT_EXPIRY_INTERVAL"}},
+					&cli.DurationFlag{Name: "webhook_interval", Value: 5 * time.Second, Usage: "Wait between sending batches of due webhook deliveries", EnvVars: []string{"WEBHOOK_INTERVAL"}},
+					&cli.DurationFlag{Name: "webhook_timeout", Value: 10 * time.Second, Usage: "Give up on a webhook delivery attempt after", EnvVars: []string{"WEBHOOK_TIMEOUT"}},
+					&cli.IntFlag{Name: "webhook_max_attempts", Value: 12, Usage: "Mark a webhook delivery dead after failing this many times", EnvVars: []string{"WEBHOOK_MAX_ATTEMPTS"}},
+					&cli.DurationFlag{Name: "webhook_min_backoff", Value: 30 * time.Second, Usage: "Wait before retrying a failed webhook delivery", EnvVars: []string{"WEBHOOK_MIN_BACKOFF"}},
+					&cli.DurationFlag{Name: "webhook_max_backoff", Value: 6 * time.Hour, Usage: "Longest wait between webhook delivery retries", EnvVars: []string{"WEBHOOK_MAX_BACKOFF"}},
+					&cli.IntFlag{Name: "webhook_concurrency", Value: 8, Usage: "Send this many webhook deliveries at once", EnvVars: []string{"WEBHOOK_CONCURRENCY"}},
+					&cli.StringFlag{Name: "admin_token", Usage: "Bearer token of the /api/admin endpoints, which are disabled without it", EnvVars: []string{"ADMIN_TOKEN"}},
+					&cli.DurationFlag{Name: "rescan_interval", Value: 10 * time.Second, Usage: "Wait between checks for queued rescans", EnvVars: []string{"RESCAN_INTERVAL"}},
+					&cli.IntFlag{Name: "rescan_chunk_size", Value: DefaultRescanChunkSize, Usage: "Blocks per committed chunk of a rescan", EnvVars: []string{"RESCAN_CHUNK_SIZE"}},
+					&cli.IntFlag{Name: "rescan_concurrency", Value: 4, Usage: "Scrape this many chunks of a rescan at once", EnvVars: []string{"RESCAN_CONCURRENCY"}},
+					&cli.IntFlag{Name: "rescan_max_attempts", Value: DefaultRescanMaxAttempts, Usage: "Mark a rescan failed after this many failed runs", EnvVars: []string{"RESCAN_MAX_ATTEMPTS"}},
+					&cli.DurationFlag{Name: "valuation_interval", Value: 30 * time.Second, Usage: "Wait between valuing batches of new transfers in dollars", EnvVars: []string{"VALUATION_INTERVAL"}},
+					&cli.DurationFlag{Name: "valuation_max_price_age", Value: time.Hour, Usage: "Oldest recorded price a transfer is valued with, relative to its block time", EnvVars: []string{"VALUATION_MAX_PRICE_AGE"}},
+					&cli.DurationFlag{Name: "stream_poll_interval", Value: time.Second, Usage: "Wait between polls for new /api/stream events", EnvVars: []string{"STREAM_POLL_INTERVAL"}},
+					&cli.DurationFlag{Name: "stream_retention", Value: 24 * time.Hour, Usage: "Keep /api/stream events this long for clients to resume from", EnvVars: []string{"STREAM_RETENTION"}},
+					&cli.DurationFlag{Name: "scrape_timeout", Value: 5 * time.Minute, Usage: "Cancel scraper runs taking longer", EnvVars: []string{"SCRAPE_TIMEOUT"}},
+					&cli.DurationFlag{Name: "scrape_min_backoff", Value: 5 * time.Second, Usage: "First retry delay of a failing scraper", EnvVars: []string{"SCRAPE_MIN_BACKOFF"}},
+					&cli.DurationFlag{Name: "scrape_max_backoff", Value: 5 * time.Minute, Usage: "Longest retry delay of a failing scraper", EnvVars: []string{"SCRAPE_MAX_BACKOFF"}},
+				}, chainFlags()...),
 				Action: func(c *cli.Context) error {
 					logFormat := c.String("log_format")
 					ttlSeconds := c.Int("ttl_seconds")
 					rpcURL := c.String("rpc_url")
-					goerliRpcUrl := c.String("goerli_rpc_url")
 					port := c.Int("port")
 					dbURL := c.String("db_url")
-					tokenAddr := c.String("token_addr")
-					goerliTokenAddr := c.String("goerli_token_addr")
-					log = zerolog.New(os.Stdout).With().Caller().Logger()
-					if logFormat == "console" {
-						log = log.Output(zerolog.ConsoleWriter{Out: os.Stderr})
+					setupLogger(logFormat)
+
+					chains, err := chainRegistry(c)
+					if err != nil {
+						return err
 					}
-					err := Connect(dbURL)
+
+					err = Connect(dbURL)
 					if err != nil {
 						return fmt.Errorf("connect db: %w", err)
 					}
-					mainnetClient, err := ethclient.Dial(rpcURL)
+					priceNode, err := DialNode("prices", SplitURLs(rpcURL)...)
 					if err != nil {
-						return fmt.Errorf("dial eth node %s: %w", rpcURL, err)
+						return fmt.Errorf("dial price node: %w", err)
 					}
-					goerliClient, err := ethclient.Dial(goerliRpcUrl)
+					err = chains.Dial()
 					if err != nil {
-						return fmt.Errorf("dial goerli eth node %s: %w", rpcURL, err)
+						return fmt.Errorf("dial chains: %w", err)
+					}
+					nodes := []*Node{priceNode}
+					for _, chain := range chains.Chains {
+						nodes = append(nodes, chain.Node)
+					}
+					for _, node := range nodes {
+						node.MaxLag = c.Uint64("rpc_max_lag")
+						node.Retries = c.Int("rpc_retries")
+						node.SetRateLimit(c.Float64("rpc_rate_limit"), c.Int("rpc_burst"))
+					}
+					for _, chain := range chains.Chains {
+						if chain.RPCRateLimit > 0 {
+							chain.Node.SetRateLimit(chain.RPCRateLimit, c.Int("rpc_burst"))
+						}
+					}
+					for _, node := range nodes {
+						go node.Monitor(c.Context, c.Duration("rpc_health_interval"))
+					}
+					var priceBackend bind.ContractBackend = priceNode
+					if c.Int("price_quorum") > 0 {
+						priceBackend, err = NewQuorumNode(priceNode, c.Int("price_quorum"))
+						if err != nil {
+							return fmt.Errorf("price quorum: %w", err)
+						}
 					}
 
 					ethusdAddr := common.HexToAddress("0x5f4eC3Df9cbd43714FE2740f5E3616155c5b8419")
 					bnbethAddr := common.HexToAddress("0x14e613ac84a31f709eadbdf89c6cc390fdc9540a")
 					supethAddr := common.HexToAddress("0xa1e5dc01359c2920c096f0091fc7f0bf69812ca7")
 
-					bnbethContract, err := ethusd.NewEthusd(bnbethAddr, mainnetClient)
+					bnbethContract, err := ethusd.NewEthusd(bnbethAddr, priceBackend)
 					if err != nil {
 						return fmt.Errorf("create ethusd contract: %w", err)
 					}
-					ethusdContract, err := ethusd.NewEthusd(ethusdAddr, mainnetClient)
+					ethusdContract, err := ethusd.NewEthusd(ethusdAddr, priceBackend)
 					if err != nil {
 						return fmt.Errorf("create ethusd contract: %w", err)
 					}
-					supsethContract, err := supseth.NewSupseth(supethAddr, mainnetClient)
+					supsethContract, err := supseth.NewSupseth(supethAddr, priceBackend)
 					if err != nil {
 						return fmt.Errorf("create supseth contract: %w", err)
 					}
-					ethC := &EthClient{mainnetClient, ethusdContract, supsethContract, bnbethContract}
-					if err != nil {
-						return fmt.Errorf("connect db: %w", err)
-					}
+					ethC := &EthClient{priceNode, ethusdContract, supsethContract, bnbethContract}
 
-					t := &Tickers{
-						c.Bool("scrape_mainnet_eth"),
-						c.Bool("scrape_mainnet_sups"),
-						c.Bool("scrape_goerli_eth"),
-						c.Bool("scrape_goerli_sups"),
-						ethC,
-						ethC.Client,
-						goerliClient,
-						common.HexToAddress(tokenAddr),
-						common.HexToAddress(goerliTokenAddr),
+					t := &Tickers{ethC, chains}
+					scheduler := NewScheduler(c.Duration("scrape_min_backoff"), c.Duration("scrape_max_backoff"))
+					scheduler.Paused = ScraperPaused
+					for _, scraper := range t.Scrapers(ScheduleOptions{
+						AssetInterval: c.Duration("scrape_interval"),
+						PriceInterval: c.Duration("price_interval"),
+						Jitter:        c.Duration("scrape_jitter"),
+						Timeout:       c.Duration("scrape_timeout"),
+					}) {
+						scheduler.Add(scraper)
+					}
+					dispatcher := &WebhookDispatcher{
+						Chains:      chains,
+						Client:      NewWebhookClient(c.Duration("webhook_timeout")),
+						MaxAttempts: c.Int("webhook_max_attempts"),
+						MinBackoff:  c.Duration("webhook_min_backoff"),
+						MaxBackoff:  c.Duration("webhook_max_backoff"),
+						Concurrency: c.Int("webhook_concurrency"),
+						BatchSize:   DefaultWebhookBatchSize,
+					}
+					scheduler.Add(&Scraper{
+						Name:     "webhooks",
+						Interval: c.Duration("webhook_interval"),
+						Timeout:  c.Duration("scrape_timeout"),
+						Run:      dispatcher.Run,
+					})
+					scheduler.Add(&Scraper{
+						Name:     "stream_events",
+						Interval: time.Hour,
+						Timeout:  c.Duration("scrape_timeout"),
+						Run: func(ctx context.Context) error {
+							_, err := PruneStreamEvents(ctx, time.Now().Add(-c.Duration("stream_retention")))
+							return err
+						},
+					})
+					rescanner := &Rescanner{
+						Chains:      chains,
+						ChunkSize:   int64(c.Int("rescan_chunk_size")),
+						Concurrency: c.Int("rescan_concurrency"),
+						MaxAttempts: c.Int("rescan_max_attempts"),
+					}
+					scheduler.Add(&Scraper{
+						Name:     "rescans",
+						Interval: c.Duration("rescan_interval"),
+						Timeout:  c.Duration("scrape_timeout"),
+						Run:      rescanner.Run,
+					})
+					valuer := &Valuer{
+						Prices:      ethC,
+						Chains:      chains,
+						MaxPriceAge: c.Duration("valuation_max_price_age"),
+						BatchSize:   DefaultValuationBatchSize,
+					}
+					scheduler.Add(&Scraper{
+						Name:     "valuations",
+						Interval: c.Duration("valuation_interval"),
+						Timeout:  c.Duration("scrape_timeout"),
+						Run:      valuer.Run,
+					})
+					scheduler.Add(&Scraper{
+						Name:     "payment_intents",
+						Interval: c.Duration("intent_expiry_interval"),
+						Timeout:  c.Duration("scrape_timeout"),
+						Run: func(ctx context.Context) error {
+							expired, err := ExpireIntents(ctx)
+							if expired > 0 {
+								log.Info().Int("expired", expired).Msg("expired payment intents")
+							}
+							return err
+						},
+					})
+					s := &Subscriber{chains, scheduler, c.Duration("head_poll_interval")}
+					lead := func(ctx context.Context) {
+						s.Start(ctx)
+						scheduler.Run(ctx)
+					}
+					leader := &Leader{Key: c.Int64("leader_lock_key"), Lease: c.Duration("leader_lease"), Instance: InstanceName()}
+					if c.Bool("leader_election") {
+						go leader.Run(c.Context, lead)
+					} else {
+						leader.setLeader(true)
+						go lead(c.Context)
 					}
-					go t.Start()
-
-					s := &Subscriber{mainnetClient, goerliClient, t}
-					s.Start()
 
-					return Serve(ethC, rpcURL, port, ttlSeconds)
+					broker := NewStreamBroker(c.Duration("stream_poll_interval"))
+					go broker.Run(c.Context)
+					service := &Service{ethC, chains, broker}
+					g, ctx := errgroup.WithContext(c.Context)
+					if c.Int("grpc_port") > 0 {
+						g.Go(func() error {
+							return ServeGRPC(ctx, service, c.Int("grpc_port"), c.String("admin_token"))
+						})
+					}
+					g.Go(func() error {
+						return Serve(ctx, service, scheduler, leader, port, ttlSeconds, c.String("admin_token"))
+					})
+					return g.Wait()
+				},
+			},
+			{
+				Name:  "backfill",
+				Usage: "Resumable, parallel backfill of transfers",
+				Subcommands: []*cli.Command{
+					{
+						Name:  "eth",
+						Usage: "Backfill native transfers to whitelisted addresses",
+						Flags: append(backfillFlags(),
+							&cli.StringFlag{Name: "symbol", Value: "ETH", Usage: "Set the native coin symbol", EnvVars: []string{"SYMBOL"}},
+							&cli.IntFlag{Name: "batch_size", Value: 20, Usage: "Blocks per JSON-RPC batch", EnvVars: []string{"BATCH_SIZE"}},
+						),
+						Action: func(c *cli.Context) error {
+							chainID := int64(c.Int("chain_id"))
+							symbol := strings.ToUpper(c.String("symbol"))
+							node, err := setupBackfill(c)
+							if err != nil {
+								return err
+							}
+							whitelisted, err := WhitelistedAddresses(int(chainID))
+							if err != nil {
+								return fmt.Errorf("get whitelisted addresses: %w", err)
+							}
+							opts := BlockFetchOptions{Concurrency: 1, BatchSize: c.Int("batch_size")}
+							b := newBackfill(c, BackfillJob(chainID, symbol, IndexModeWatched, whitelisted, int64(c.Int("from_block")), int64(c.Int("to_block")), int64(c.Int("chunk_size"))))
+							b.Scrape = func(ctx context.Context, fromBlock int64, toBlock int64) ([]*Transfer, []*Approval, error) {
+								transfers, err := ScrapeETH(ctx, node, fromBlock, toBlock+1, whitelisted, chainID, symbol, opts)
+								return transfers, nil, err
+							}
+							return b.Run(c.Context)
+						},
+					},
+					{
+						Name:  "token",
+						Usage: "Backfill ERC-20 transfers",
+						Flags: append(backfillFlags(),
+							&cli.StringFlag{Name: "token_addr", Value: "0xCF39360b26a7E54f6c456E69640671Fc5e774FA2", Usage: "Set the token addr", EnvVars: []string{"TOKEN_ADDR"}},
+							&cli.StringFlag{Name: "token_symbol", Usage: "Set the token symbol, read from the contract when unset", EnvVars: []string{"TOKEN_SYMBOL"}},
+							&cli.StringFlag{Name: "index_mode", Value: "all", Usage: "Index all txes or only whitelisted addresses of the chain (all or watched)", EnvVars: []string{"INDEX_MODE"}},
+						),
+						Action: func(c *cli.Context) error {
+							chainID := int64(c.Int("chain_id"))
+							mode, err := ParseIndexMode(c.String("index_mode"))
+							if err != nil {
+								return fmt.Errorf("index_mode: %w", err)
+							}
+							node, err := setupBackfill(c)
+							if err != nil {
+								return err
+							}
+							asset := &Asset{Symbol: strings.ToUpper(c.String("token_symbol")), Contract: c.String("token_addr"), IndexMode: mode}
+							err = ResolveAsset(c.Context, node, chainID, asset)
+							if err != nil {
+								return err
+							}
+							watched, err := WhitelistedAddresses(int(chainID))
+							if err != nil {
+								return fmt.Errorf("get whitelisted addresses: %w", err)
+							}
+							b := newBackfill(c, BackfillJob(chainID, asset.Symbol, mode, watched, int64(c.Int("from_block")), int64(c.Int("to_block")), int64(c.Int("chunk_size"))))
+							b.Scrape = func(ctx context.Context, fromBlock int64, toBlock int64) ([]*Transfer, []*Approval, error) {
+								transfers, err := ScrapeSUPS(ctx, node, fromBlock, toBlock, chainID, asset, watched)
+								if err != nil {
+									return nil, nil, err
+								}
+								approvals, err := ScrapeApprovals(ctx, node, fromBlock, toBlock, chainID, asset, watched)
+								if err != nil {
+									return nil, nil, fmt.Errorf("scrape approvals: %w", err)
+								}
+								return transfers, approvals, nil
+							}
+							return b.Run(c.Context)
+						},
+					},
+				},
+			},
+			{
+				Name:  "balances",
+				Usage: "Manage the derived balance index",
+				Subcommands: []*cli.Command{
+					{
+						Name:  "rebuild",
+						Usage: "Recompute the balances of a token from its transfers",
+						Flags: []cli.Flag{
+							&cli.StringFlag{Name: "log_format", Value: "console", Usage: "log formatting (json or console)", EnvVars: []string{"LOG_FORMAT"}},
+							&cli.StringFlag{Name: "db_url", Required: true, Usage: "Database connection string", EnvVars: []string{"DATABASE_URL"}},
+							&cli.IntFlag{Name: "chain_id", Value: 1, Usage: "Set the chain id", EnvVars: []string{"CHAIN_ID"}},
+							&cli.StringFlag{Name: "token_symbol", Value: "SUPS", Usage: "Set the token symbol", EnvVars: []string{"TOKEN_SYMBOL"}},
+						},
+						Action: func(c *cli.Context) error {
+							setupLogger(c.String("log_format"))
+							err := Connect(c.String("db_url"))
+							if err != nil {
+								return fmt.Errorf("connect db: %w", err)
+							}
+							total, err := RebuildBalances(int64(c.Int("chain_id")), strings.ToUpper(c.String("token_symbol")))
+							if err != nil {
+								return err
+							}
+							log.Info().Int("chain_id", c.Int("chain_id")).Str("token_symbol", c.String("token_symbol")).Int("addresses", total).Msg("rebuilt balances")
+							return nil
+						},
+					},
+				},
+			},
+			{
+				Name:  "admin",
+				Usage: "Change scraper settings, cursors, rescans and pauses, like /api/admin. Every change is audited",
+				Subcommands: []*cli.Command{
+					{
+						Name:  "settings",
+						Usage: "List the scraper settings",
+						Flags: adminFlags(),
+						Action: func(c *cli.Context) error {
+							err := setupAdmin(c)
+							if err != nil {
+								return err
+							}
+							settings, err := ScraperSettingValues()
+							if err != nil {
+								return err
+							}
+							return printJSON(settings)
+						},
+					},
+					{
+						Name:  "set",
+						Usage: "Change a scraper setting",
+						Flags: append(adminFlags(),
+							&cli.StringFlag{Name: "key", Required: true, Usage: "Setting to change, see admin settings"},
+							&cli.IntFlag{Name: "value", Required: true, Usage: "New value"},
+						),
+						Action: func(c *cli.Context) error {
+							err := setupAdmin(c)
+							if err != nil {
+								return err
+							}
+							setting, err := LookupScraperSetting(c.String("key"))
+							if err != nil {
+								return err
+							}
+							err = setting.Validate(c.Int("value"))
+							if err != nil {
+								return err
+							}
+							value := strconv.Itoa(c.Int("value"))
+							old, err := SetKVAudited(c.Context, c.String("actor"), AuditSetSetting, setting.Key, &value)
+							if err != nil {
+								return err
+							}
+							log.Info().Str("key", string(setting.Key)).Interface("old", old).Str("value", value).Msg("set scraper setting")
+							return nil
+						},
+					},
+					{
+						Name:  "unset",
+						Usage: "Put a scraper setting back to its default",
+						Flags: append(adminFlags(),
+							&cli.StringFlag{Name: "key", Required: true, Usage: "Setting to reset, see admin settings"},
+						),
+						Action: func(c *cli.Context) error {
+							err := setupAdmin(c)
+							if err != nil {
+								return err
+							}
+							setting, err := LookupScraperSetting(c.String("key"))
+							if err != nil {
+								return err
+							}
+							old, err := SetKVAudited(c.Context, c.String("actor"), AuditUnsetSetting, setting.Key, nil)
+							if err != nil {
+								return err
+							}
+							log.Info().Str("key", string(setting.Key)).Interface("old", old).Int("default", setting.Default).Msg("unset scraper setting")
+							return nil
+						},
+					},
+					{
+						Name:  "cursor",
+						Usage: "Move the scrape cursor of an asset to a block; the scraper goes on from the block after it",
+						Flags: append(adminChainFlags(),
+							&cli.IntFlag{Name: "chain_id", Value: 1, Usage: "Set the chain id", EnvVars: []string{"CHAIN_ID"}},
+							&cli.StringFlag{Name: "symbol", Required: true, Usage: "Symbol of the asset"},
+							&cli.Int64Flag{Name: "block", Required: true, Usage: "Block to move the cursor to"},
+						),
+						Action: func(c *cli.Context) error {
+							err := setupAdmin(c)
+							if err != nil {
+								return err
+							}
+							chain, asset, err := adminAsset(c)
+							if err != nil {
+								return err
+							}
+							blockHeight, err := GetInt(KeyBlockHeight(chain.ID), int(chain.BaseBlock))
+							if err != nil {
+								return fmt.Errorf("get block height: %w", err)
+							}
+							if c.Int64("block") < 0 || c.Int64("block") > int64(blockHeight) {
+								return fmt.Errorf("block must be between 0 and the block height %d", blockHeight)
+							}
+							key := KeyLastBlock(chain.ID, asset.Symbol)
+							value := strconv.FormatInt(c.Int64("block"), 10)
+							old, err := SetKVAudited(c.Context, c.String("actor"), AuditResetCursor, key, &value)
+							if err != nil {
+								return err
+							}
+							log.Info().Str("key", string(key)).Interface("old", old).Str("block", value).Msg("reset cursor")
+							return nil
+						},
+					},
+					{
+						Name:  "rescan",
+						Usage: "Queue a rescan of a block range of an asset, run by the serve leader",
+						Flags: append(adminChainFlags(),
+							&cli.IntFlag{Name: "chain_id", Value: 1, Usage: "Set the chain id", EnvVars: []string{"CHAIN_ID"}},
+							&cli.StringFlag{Name: "symbol", Required: true, Usage: "Symbol of the asset"},
+							&cli.Int64Flag{Name: "from_block", Required: true, Usage: "Set the from block (inclusive)"},
+							&cli.Int64Flag{Name: "to_block", Required: true, Usage: "Set the to block (inclusive)"},
+							&cli.StringFlag{Name: "addresses", Usage: "Comma separated addresses to only rescan the transfers of"},
+							&cli.StringFlag{Name: "reason", Value: "requested", Usage: "Why the range is rescanned"},
+						),
+						Action: func(c *cli.Context) error {
+							err := setupAdmin(c)
+							if err != nil {
+								return err
+							}
+							chain, asset, err := adminAsset(c)
+							if err != nil {
+								return err
+							}
+							blockHeight, err := GetInt(KeyBlockHeight(chain.ID), int(chain.BaseBlock))
+							if err != nil {
+								return fmt.Errorf("get block height: %w", err)
+							}
+							rescan := &Rescan{
+								ChainID:   chain.ID,
+								Symbol:    asset.Symbol,
+								FromBlock: c.Int64("from_block"),
+								ToBlock:   c.Int64("to_block"),
+								Reason:    c.String("reason"),
+							}
+							if rescan.FromBlock < 0 || rescan.FromBlock > rescan.ToBlock || rescan.ToBlock > int64(blockHeight) {
+								return fmt.Errorf("from_block and to_block must be an ordered range up to the block height %d", blockHeight)
+							}
+							for _, addr := range strings.Split(c.String("addresses"), ",") {
+								addr = strings.TrimSpace(addr)
+								if addr == "" {
+									continue
+								}
+								if !common.IsHexAddress(addr) {
+									return fmt.Errorf("invalid address %q", addr)
+								}
+								rescan.Addresses = append(rescan.Addresses, common.HexToAddress(addr).Hex())
+							}
+							err = QueueRescan(c.Context, c.String("actor"), rescan)
+							if err != nil {
+								return err
+							}
+							return printJSON(rescanResponse(rescan))
+						},
+					},
+					{
+						Name:  "cancel",
+						Usage: "Cancel a pending or running rescan; a running one stops after its current run",
+						Flags: append(adminFlags(),
+							&cli.StringFlag{Name: "id", Required: true, Usage: "ID of the rescan, as listed in /api/admin/rescans"},
+						),
+						Action: func(c *cli.Context) error {
+							err := setupAdmin(c)
+							if err != nil {
+								return err
+							}
+							id, err := uuid.FromString(c.String("id"))
+							if err != nil {
+								return fmt.Errorf("invalid id: %w", err)
+							}
+							rescan, err := CancelRescan(c.Context, c.String("actor"), id)
+							if err != nil {
+								return err
+							}
+							if rescan == nil {
+								return fmt.Errorf("no pending or running rescan %s", id)
+							}
+							return printJSON(rescanResponse(rescan))
+						},
+					},
+					{
+						Name:  "pause",
+						Usage: "Stop the serve leader from running a scraper until it is resumed",
+						Flags: append(adminFlags(),
+							&cli.StringFlag{Name: "scraper", Required: true, Usage: "Name of the scraper, as listed in /api/status"},
+							&cli.StringFlag{Name: "reason", Value: "paused", Usage: "Why the scraper is paused"},
+						),
+						Action: func(c *cli.Context) error {
+							err := setupAdmin(c)
+							if err != nil {
+								return err
+							}
+							reason := c.String("reason")
+							_, err = SetKVAudited(c.Context, c.String("actor"), AuditPauseScraper, KeyScraperPaused(c.String("scraper")), &reason)
+							if err != nil {
+								return err
+							}
+							log.Info().Str("scraper", c.String("scraper")).Msg("paused scraper")
+							return nil
+						},
+					},
+					{
+						Name:  "resume",
+						Usage: "Resume a paused scraper",
+						Flags: append(adminFlags(),
+							&cli.StringFlag{Name: "scraper", Required: true, Usage: "Name of the scraper, as listed in /api/status"},
+						),
+						Action: func(c *cli.Context) error {
+							err := setupAdmin(c)
+							if err != nil {
+								return err
+							}
+							_, err = SetKVAudited(c.Context, c.String("actor"), AuditResumeScraper, KeyScraperPaused(c.String("scraper")), nil)
+							if err != nil {
+								return err
+							}
+							log.Info().Str("scraper", c.String("scraper")).Msg("resumed scraper")
+							return nil
+						},
+					},
+					{
+						Name:  "audit",
+						Usage: "List the newest admin changes",
+						Flags: append(adminFlags(),
+							&cli.IntFlag{Name: "limit", Value: DefaultAuditLimit, Usage: "How many changes to list"},
+						),
+						Action: func(c *cli.Context) error {
+							err := setupAdmin(c)
+							if err != nil {
+								return err
+							}
+							entries, err := AuditLog(c.Context, c.Int("limit"))
+							if err != nil {
+								return err
+							}
+							return printJSON(entries)
+						},
+					},
 				},
 			},
 			{
 				Name: "scrape",
 				Flags: []cli.Flag{
-					&cli.StringFlag{Name: "rpc_url", Required: true, Usage: "Infura or Eth node URL", EnvVars: []string{"RPC_URL"}},
+					&cli.StringFlag{Name: "rpc_url", Required: true, Usage: "Comma separated RPC URLs, in order of preference", EnvVars: []string{"RPC_URL"}},
+					&cli.StringFlag{Name: "db_url", Required: true, Usage: "Database connection string", EnvVars: []string{"DATABASE_URL"}},
 					&cli.IntFlag{Name: "from_block", Value: 15879854, Usage: "Set the from block", EnvVars: []string{"FROM_BLOCK"}},
 					&cli.IntFlag{Name: "to_block", Value: 15974754, Usage: "Set the to block", EnvVars: []string{"TO_BLOCK"}},
 					&cli.IntFlag{Name: "chain_id", Value: 1, Usage: "Set the chain id", EnvVars: []string{"CHAIN_ID"}},
-					&cli.IntFlag{Name: "token_decimals", Value: 18, Usage: "Set the token decimals", EnvVars: []string{"TOKEN_DECIMALS"}},
 					&cli.StringFlag{Name: "token_addr", Value: "0xCF39360b26a7E54f6c456E69640671Fc5e774FA2", Usage: "Set the token addr", EnvVars: []string{"TOKEN_ADDR"}},
-					&cli.StringFlag{Name: "token_symbol", Value: "SUPS", Usage: "Set the token symbol", EnvVars: []string{"TOKEN_SYMBOL"}},
+					&cli.StringFlag{Name: "token_symbol", Usage: "Set the token symbol, read from the contract when unset", EnvVars: []string{"TOKEN_SYMBOL"}},
+					&cli.StringFlag{Name: "index_mode", Value: "all", Usage: "Index all txes or only whitelisted addresses of the chain (all or watched)", EnvVars: []string{"INDEX_MODE"}},
 				},
 				Usage: "Run SUPS scraper",
 				Action: func(c *cli.Context) error {
@@ -140,25 +619,51 @@ func main() {
 					fromBlock := c.Int("from_block")
 					toBlock := c.Int("to_block")
 					chainId := c.Int("chain_id")
-					tokenDecimals := c.Int("token_decimals")
-					tokenAddr := c.String("token_addr")
-					tokenSymbol := c.String("token_symbol")
-					client, err := ethclient.Dial(rpcUrl)
+					mode, err := ParseIndexMode(c.String("index_mode"))
+					if err != nil {
+						return fmt.Errorf("index_mode: %w", err)
+					}
+					err = Connect(c.String("db_url"))
+					if err != nil {
+						return fmt.Errorf("connect db: %w", err)
+					}
+					watched := []common.Address{}
+					if mode == IndexModeWatched {
+						watched, err = WhitelistedAddresses(chainId)
+						if err != nil {
+							return fmt.Errorf("get whitelisted addresses: %w", err)
+						}
+					}
+					client, err := DialNode(strconv.Itoa(chainId), SplitURLs(rpcUrl)...)
 					if err != nil {
 						return fmt.Errorf("dial eth node: %w", err)
 					}
+					asset := &Asset{Symbol: strings.ToUpper(c.String("token_symbol")), Contract: c.String("token_addr"), IndexMode: mode}
+					err = ResolveAsset(c.Context, client, int64(chainId), asset)
+					if err != nil {
+						return err
+					}
 
 					log.Info().
 						Int("from_block", fromBlock).
 						Int("to_block", toBlock).
 						Int("chain_id", chainId).
-						Int("token_decimals", tokenDecimals).
-						Str("token_addr", tokenAddr).
-						Str("token_symbol", tokenSymbol).
+						Int("token_decimals", asset.Decimals).
+						Str("token_addr", asset.Contract).
+						Str("token_symbol", asset.Symbol).
+						Str("index_mode", string(mode)).
 						Msg("scrape")
 
-					_, err = ScrapeSUPS(client, int64(fromBlock), int64(toBlock), int64(chainId), common.HexToAddress(tokenAddr), tokenSymbol, tokenDecimals)
-					return err
+					transfers, err := ScrapeSUPS(c.Context, client, int64(fromBlock), int64(toBlock), int64(chainId), asset, watched)
+					if err != nil {
+						return fmt.Errorf("scrape: %w", err)
+					}
+					total, err := AddTransfers(transfers)
+					if err != nil {
+						return fmt.Errorf("save transfers: %w", err)
+					}
+					log.Info().Int("total", total).Msg("scraped transfers")
+					return nil
 				},
 			}},
 	}
@@ -169,6 +674,171 @@ func main() {
 
 }
 
+func adminFlags() []cli.Flag {
+	return []cli.Flag{
+		&cli.StringFlag{Name: "log_format", Value: "console", Usage: "log formatting (json or console)", EnvVars: []string{"LOG_FORMAT"}},
+		&cli.StringFlag{Name: "db_url", Required: true, Usage: "Database connection string", EnvVars: []string{"DATABASE_URL"}},
+		&cli.StringFlag{Name: "actor", Value: CLIActor(), Usage: "Who makes the change, for the audit log", EnvVars: []string{"ADMIN_ACTOR"}},
+	}
+}
+
+// adminChainFlags are the adminFlags of the commands naming an asset, which is looked up in the chain registry.
+func adminChainFlags() []cli.Flag {
+	flags := append(adminFlags(), &cli.StringFlag{Name: "rpc_url", Usage: "Mainnet node RPC URL, only to build the legacy chain registry without --chains", EnvVars: []string{"RPC_URL"}})
+	return append(flags, chainFlags()...)
+}
+
+// adminAsset looks up the --chain_id and --symbol of an admin command the way the /api/admin endpoints do,
+// so the CLI can't write the cursor or queue a rescan of an asset that isn't configured.
+func adminAsset(c *cli.Context) (*Chain, *Asset, error) {
+	chains, err := chainRegistry(c)
+	if err != nil {
+		return nil, nil, err
+	}
+	chain, asset, err := chains.LookupAsset(strconv.Itoa(c.Int("chain_id")), c.String("symbol"))
+	if err != nil {
+		return nil, nil, fmt.Errorf("chain %d symbol %s: %w", c.Int("chain_id"), c.String("symbol"), err)
+	}
+	return chain, asset, nil
+}
+
+func setupAdmin(c *cli.Context) error {
+	setupLogger(c.String("log_format"))
+	err := Connect(c.String("db_url"))
+	if err != nil {
+		return fmt.Errorf("connect db: %w", err)
+	}
+	return nil
+}
+
+func printJSON(v interface{}) error {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+func backfillFlags() []cli.Flag {
+	return []cli.Flag{
+		&cli.StringFlag{Name: "log_format", Value: "console", Usage: "log formatting (json or console)", EnvVars: []string{"LOG_FORMAT"}},
+		&cli.StringFlag{Name: "rpc_url", Required: true, Usage: "Comma separated RPC URLs, in order of preference", EnvVars: []string{"RPC_URL"}},
+		&cli.StringFlag{Name: "db_url", Required: true, Usage: "Database connection string", EnvVars: []string{"DATABASE_URL"}},
+		&cli.IntFlag{Name: "chain_id", Value: 1, Usage: "Set the chain id", EnvVars: []string{"CHAIN_ID"}},
+		&cli.IntFlag{Name: "from_block", Required: true, Usage: "Set the from block (inclusive)", EnvVars: []string{"FROM_BLOCK"}},
+		&cli.IntFlag{Name: "to_block", Required: true, Usage: "Set the to block (inclusive)", EnvVars: []string{"TO_BLOCK"}},
+		&cli.IntFlag{Name: "chunk_size", Value: 1000, Usage: "Blocks per chunk", EnvVars: []string{"CHUNK_SIZE"}},
+		&cli.IntFlag{Name: "concurrency", Value: 4, Usage: "Chunks scraped at once", EnvVars: []string{"CONCURRENCY"}},
+		&cli.Float64Flag{Name: "rpc_rate_limit", Usage: "Requests per second allowed to each RPC endpoint, 0 for no limit", EnvVars: []string{"RPC_RATE_LIMIT"}},
+		&cli.IntFlag{Name: "rpc_burst", Value: 10, Usage: "Requests sent to an RPC endpoint at once before the rate limit applies", EnvVars: []string{"RPC_BURST"}},
+		&cli.StringFlag{Name: "job", Usage: "Name of the job to resume, derived from the other flags when unset", EnvVars: []string{"JOB"}},
+	}
+}
+
+func setupBackfill(c *cli.Context) (*Node, error) {
+	setupLogger(c.String("log_format"))
+	err := Connect(c.String("db_url"))
+	if err != nil {
+		return nil, fmt.Errorf("connect db: %w", err)
+	}
+	node, err := DialNode(strconv.Itoa(c.Int("chain_id")), SplitURLs(c.String("rpc_url"))...)
+	if err != nil {
+		return nil, fmt.Errorf("dial eth node: %w", err)
+	}
+	node.SetRateLimit(c.Float64("rpc_rate_limit"), c.Int("rpc_burst"))
+	return node, nil
+}
+
+func newBackfill(c *cli.Context, job string) *Backfill {
+	if c.String("job") != "" {
+		job = c.String("job")
+	}
+	return &Backfill{
+		Job:         job,
+		FromBlock:   int64(c.Int("from_block")),
+		ToBlock:     int64(c.Int("to_block")),
+		ChunkSize:   int64(c.Int("chunk_size")),
+		Concurrency: c.Int("concurrency"),
+	}
+}
+
+func setupLogger(logFormat string) {
+	log = zerolog.New(os.Stdout).With().Caller().Logger()
+	if logFormat == "console" {
+		log = log.Output(zerolog.ConsoleWriter{Out: os.Stderr})
+	}
+}
+
+// chainFlags select the chain registry: a --chains file, or the legacy mainnet/goerli flags without one.
+func chainFlags() []cli.Flag {
+	return []cli.Flag{
+		&cli.StringFlag{Name: "chains", Usage: "Chain registry JSON file, replaces the legacy mainnet/goerli flags", EnvVars: []string{"CHAINS"}},
+		&cli.StringFlag{Name: "goerli_rpc_url", Usage: "Goerli ETH node RPC URL (legacy, goerli is skipped when unset)", EnvVars: []string{"GOERLI_RPC_URL"}},
+		&cli.StringFlag{Name: "token_addr", Value: "0xCF39360b26a7E54f6c456E69640671Fc5e774FA2", Usage: "Set the token addr (mainnet, legacy)", EnvVars: []string{"TOKEN_ADDR"}},
+		&cli.StringFlag{Name: "goerli_token_addr", Value: "0xfF30d2c046AEb5FA793138265Cc586De814d0040", Usage: "Set the token addr (goerli, legacy)", EnvVars: []string{"GOERLI_TOKEN_ADDR"}},
+		&cli.StringFlag{Name: "supply_excluded", Usage: "Comma separated mainnet sups addresses left out of the circulating supply (legacy)", EnvVars: []string{"SUPPLY_EXCLUDED"}},
+		&cli.BoolFlag{Name: "scrape_mainnet_eth", Value: true, Usage: "Scrape mainnet eth txes (legacy)", EnvVars: []string{"SCRAPE_MAINNET_ETH"}},
+		&cli.BoolFlag{Name: "scrape_mainnet_sups", Value: true, Usage: "Scrape mainnet sups txes (legacy)", EnvVars: []string{"SCRAPE_MAINNET_SUPS"}},
+		&cli.BoolFlag{Name: "scrape_goerli_eth", Value: true, Usage: "Scrape goerli eth txes (legacy)", EnvVars: []string{"SCRAPE_GOERLI_ETH"}},
+		&cli.BoolFlag{Name: "scrape_goerli_sups", Value: true, Usage: "Scrape goerli sups txes (legacy)", EnvVars: []string{"SCRAPE_GOERLI_SUPS"}},
+		&cli.StringFlag{Name: "index_mode_mainnet_sups", Value: "all", Usage: "Index all mainnet sups txes or only watched addresses (all or watched, legacy)", EnvVars: []string{"INDEX_MODE_MAINNET_SUPS"}},
+		&cli.StringFlag{Name: "index_mode_goerli_sups", Value: "all", Usage: "Index all goerli sups txes or only watched addresses (all or watched, legacy)", EnvVars: []string{"INDEX_MODE_GOERLI_SUPS"}},
+	}
+}
+
+// chainRegistry loads the chain registry selected by chainFlags.
+func chainRegistry(c *cli.Context) (*ChainRegistry, error) {
+	var chains *ChainRegistry
+	var err error
+	if c.String("chains") != "" {
+		chains, err = LoadChains(c.String("chains"))
+	} else {
+		chains, err = legacyChains(c)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("chain registry: %w", err)
+	}
+	return chains, nil
+}
+
+// legacyChains builds the chain registry from the flags used before the registry existed:
+// mainnet from rpc_url and goerli from goerli_rpc_url when it is set.
+func legacyChains(c *cli.Context) (*ChainRegistry, error) {
+	chains := []*Chain{}
+	mainnetMode, err := ParseIndexMode(c.String("index_mode_mainnet_sups"))
+	if err != nil {
+		return nil, fmt.Errorf("index_mode_mainnet_sups: %w", err)
+	}
+	mainnet := &Chain{Name: "mainnet", RPCURLs: SplitURLs(c.String("rpc_url"))}
+	if c.Bool("scrape_mainnet_eth") {
+		mainnet.Assets = append(mainnet.Assets, &Asset{Symbol: "ETH"})
+	}
+	if c.Bool("scrape_mainnet_sups") {
+		excluded := []string{}
+		for _, addr := range strings.Split(c.String("supply_excluded"), ",") {
+			if strings.TrimSpace(addr) != "" {
+				excluded = append(excluded, strings.TrimSpace(addr))
+			}
+		}
+		mainnet.Assets = append(mainnet.Assets, &Asset{Symbol: "SUPS", Contract: c.String("token_addr"), IndexMode: mainnetMode, SupplyExcluded: excluded})
+	}
+	chains = append(chains, mainnet)
+
+	if c.String("goerli_rpc_url") != "" {
+		goerliMode, err := ParseIndexMode(c.String("index_mode_goerli_sups"))
+		if err != nil {
+			return nil, fmt.Errorf("index_mode_goerli_sups: %w", err)
+		}
+		goerli := &Chain{Name: "goerli", RPCURLs: SplitURLs(c.String("goerli_rpc_url"))}
+		if c.Bool("scrape_goerli_eth") {
+			goerli.Assets = append(goerli.Assets, &Asset{Symbol: "ETH"})
+		}
+		if c.Bool("scrape_goerli_sups") {
+			goerli.Assets = append(goerli.Assets, &Asset{Symbol: "SUPS", Contract: c.String("goerli_token_addr"), IndexMode: goerliMode})
+		}
+		chains = append(chains, goerli)
+	}
+	return NewChainRegistry(chains)
+}
+
 func LoggerMiddleware(next http.Handler) http.Handler {
 	fn := func(w http.ResponseWriter, r *http.Request) {
 		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
@@ -190,7 +860,10 @@ func LoggerMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(fn)
 }
 
-func Serve(ethC *EthClient, rpcURL string, port int, ttlSeconds int) error {
+// ShutdownTimeout bounds how long the HTTP and gRPC servers wait for open requests and streams when they stop.
+const ShutdownTimeout = 10 * time.Second
+
+func Serve(ctx context.Context, service *Service, scheduler *Scheduler, leader *Leader, port int, ttlSeconds int, adminToken string) error {
 
 	memcached, err := memory.NewAdapter(
 		memory.AdapterWithAlgorithm(memory.LRU),
@@ -209,7 +882,7 @@ func Serve(ethC *EthClient, rpcURL string, port int, ttlSeconds int) error {
 		return fmt.Errorf("memcached client: %w", err)
 	}
 
-	c := &Controller{ethC}
+	c := &Controller{service, scheduler, leader}
 
 	r := chi.NewRouter()
 	r.Use(cors.Handler(cors.Options{
@@ -235,6 +908,48 @@ func Serve(ethC *EthClient, rpcURL string, port int, ttlSeconds int) error {
 		w.Write(output)
 	})
 	r.Get("/api/transfers/{chain}/{symbol}", http.HandlerFunc(c.Transfers))
+	r.Get("/api/allowances/{chain}/{owner}", http.HandlerFunc(c.Allowances))
+	r.Get("/api/tokens/{chain}/{contract}", http.HandlerFunc(c.Token))
+	r.With(AdminAuth(adminToken)).Post("/api/intents", http.HandlerFunc(c.CreateIntent))
+	r.With(AdminAuth(adminToken)).Get("/api/intents", http.HandlerFunc(c.Intents))
+	r.Get("/api/intents/{id}", http.HandlerFunc(c.Intent))
+	r.Get("/api/intents/{id}/stream", http.HandlerFunc(c.StreamIntent))
+	r.Route("/api/webhooks", func(r chi.Router) {
+		r.Use(AdminAuth(adminToken))
+		r.Post("/", http.HandlerFunc(c.CreateWebhook))
+		r.Get("/", http.HandlerFunc(c.Webhooks))
+		r.Get("/{id}", http.HandlerFunc(c.Webhook))
+		r.Delete("/{id}", http.HandlerFunc(c.DeleteWebhook))
+		r.Get("/{id}/deliveries", http.HandlerFunc(c.WebhookDeliveries))
+		r.Get("/{id}/deliveries/{delivery}", http.HandlerFunc(c.WebhookDelivery))
+		r.Post("/{id}/deliveries/{delivery}/redeliver", http.HandlerFunc(c.RedeliverWebhook))
+	})
+	r.Route("/api/admin", func(r chi.Router) {
+		r.Use(AdminAuth(adminToken))
+		r.Get("/whitelist/{chain}", http.HandlerFunc(c.Whitelist))
+		r.Post("/whitelist/{chain}", http.HandlerFunc(c.AddWhitelist))
+		r.Patch("/whitelist/{chain}/{address}", http.HandlerFunc(c.UpdateWhitelist))
+		r.Delete("/whitelist/{chain}/{address}", http.HandlerFunc(c.DeleteWhitelist))
+		r.Get("/rescans", http.HandlerFunc(c.Rescans))
+		r.Post("/rescans", http.HandlerFunc(c.QueueRescan))
+		r.Post("/rescans/{id}/cancel", http.HandlerFunc(c.CancelRescan))
+		r.Get("/settings", http.HandlerFunc(c.Settings))
+		r.Put("/settings/{key}", http.HandlerFunc(c.SetSetting))
+		r.Delete("/settings/{key}", http.HandlerFunc(c.UnsetSetting))
+		r.Get("/cursors", http.HandlerFunc(c.Cursors))
+		r.Put("/cursors/{chain}/{symbol}", http.HandlerFunc(c.ResetCursor))
+		r.Post("/scrapers/{name}/pause", http.HandlerFunc(c.PauseScraper))
+		r.Post("/scrapers/{name}/resume", http.HandlerFunc(c.ResumeScraper))
+		r.Get("/audit", http.HandlerFunc(c.Audit))
+	})
+	r.Get("/api/stream", http.HandlerFunc(c.StreamSSE))
+	r.Get("/api/stream/ws", http.HandlerFunc(c.StreamWebSocket))
+	r.Get("/api/holders/{chain}/{symbol}", http.HandlerFunc(c.Holders))
+	r.Get("/api/address/{addr}/balances", http.HandlerFunc(c.AddressBalances))
+	r.Get("/api/supply/{symbol}", cacheClient.Middleware(http.HandlerFunc(c.Supply)).ServeHTTP)
+	r.Get("/api/supply/{symbol}/circulating.txt", cacheClient.Middleware(http.HandlerFunc(c.CirculatingSupply)).ServeHTTP)
+	r.Get("/api/supply/{symbol}/total.txt", cacheClient.Middleware(http.HandlerFunc(c.TotalSupply)).ServeHTTP)
+	r.Get("/api/status", http.HandlerFunc(c.Status))
 	r.Get("/api/check", func(w http.ResponseWriter, r *http.Request) { w.Write([]byte("ok")) })
 	r.Get("/api/prices", cacheClient.Middleware(http.HandlerFunc(c.PricesHandler)).ServeHTTP)
 	r.Get("/api/eth_price", cacheClient.Middleware(http.HandlerFunc(c.Eth)).ServeHTTP)
@@ -242,54 +957,142 @@ func Serve(ethC *EthClient, rpcURL string, port int, ttlSeconds int) error {
 	r.Get("/api/sups_price", cacheClient.Middleware(http.HandlerFunc(c.Sups)).ServeHTTP)
 	log.Info().Int("port", port).Msg("Running server")
 
-	return http.ListenAndServe(":"+fmt.Sprintf("%d", port), r)
+	server := &http.Server{Addr: ":" + fmt.Sprintf("%d", port), Handler: r}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), ShutdownTimeout)
+		defer cancel()
+		err := server.Shutdown(shutdownCtx)
+		if err != nil {
+			log.Warn().Err(err).Msg("shut down server")
+		}
+	}()
+	err = server.ListenAndServe()
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
+	return err
 }
 
-func (c *Controller) Transfers(w http.ResponseWriter, r *http.Request) {
-
-	symbol := chi.URLParam(r, "symbol")
-	chain := chi.URLParam(r, "chain")
-	if symbol != "sups" && symbol != "eth" {
-		http.Error(w, "sups or eth only", http.StatusInternalServerError)
-		return
+// parseTransferQuery reads the filters and paging of a transfers listing from the query string.
+func parseTransferQuery(r *http.Request) (*TransferQuery, error) {
+	query := r.URL.Query()
+	result := &TransferQuery{Cursor: query.Get("cursor")}
+	ints := []struct {
+		name  string
+		value *int64
+	}{
+		{"since_block", &result.SinceBlock},
+		{"until_block", &result.UntilBlock},
+		{"since_time", &result.SinceTime},
+		{"until_time", &result.UntilTime},
 	}
-
-	var err error
-	sinceBlockStr := r.URL.Query().Get("since_block")
-	sinceBlock := 0
-	if sinceBlockStr != "" {
-		sinceBlock, err = strconv.Atoi(sinceBlockStr)
-		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
+	for _, param := range ints {
+		if str := query.Get(param.name); str != "" {
+			value, err := strconv.ParseInt(str, 10, 64)
+			if err != nil || value < 0 {
+				return nil, fmt.Errorf("invalid %s", param.name)
+			}
+			*param.value = value
 		}
 	}
-
-	blockheight := 0
-	chainID := 0
-	switch chain {
-	case "mainnet":
-		chainID = 1
-		blockheight, err = GetInt(KeyBlockHeightMainnet, BaseMainnetBlock)
-		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
+	addrs := []struct {
+		name  string
+		value **common.Address
+	}{
+		{"from", &result.From},
+		{"to", &result.To},
+		{"address", &result.Address},
+	}
+	for _, param := range addrs {
+		if str := query.Get(param.name); str != "" {
+			if !common.IsHexAddress(str) {
+				return nil, fmt.Errorf("invalid %s", param.name)
+			}
+			addr := common.HexToAddress(str)
+			*param.value = &addr
 		}
-	case "goerli":
-		chainID = 5
-		blockheight, err = GetInt(KeyBlockHeightGoerli, BaseGoerliBlock)
+	}
+	if str := query.Get("min_amount"); str != "" {
+		minAmount, err := decimal.NewFromString(str)
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
+			return nil, fmt.Errorf("invalid min_amount")
+		}
+		result.MinAmount = &minAmount
+	}
+	if str := query.Get("limit"); str != "" {
+		limit, err := strconv.Atoi(str)
+		if err != nil || limit < 1 {
+			return nil, fmt.Errorf("invalid limit")
 		}
+		result.Limit = limit
+	}
+	switch query.Get("order") {
+	case "", "desc":
+	case "asc":
+		result.Ascending = true
+	default:
+		return nil, fmt.Errorf("invalid order")
+	}
+	return result, nil
+}
+
+// Transfers returns a page of transfers, newest first unless ?order=asc. The next page is linked in the Link header.
+// Without ?limit=, ?cursor= and ?order= it returns every matching transfer newest first, as it did before pages,
+// so since_block pollers keep getting everything. CSV and NDJSON (?format= or Accept) export every matching
+// transfer instead, streamed as they are read.
+func (c *Controller) Transfers(w http.ResponseWriter, r *http.Request) {
+	query, err := parseTransferQuery(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	format, ok := responseFormat(r)
+	if !ok {
+		http.Error(w, "invalid format", http.StatusBadRequest)
+		return
+	}
+	if format != FormatJSON {
+		c.exportTransfers(w, r, format, query)
+		return
+	}
+
+	if query.Limit == 0 && query.Cursor == "" && !r.URL.Query().Has("order") {
+		c.allTransfers(w, r, query)
+		return
+	}
+
+	result, err := c.Service.Transfers(r.Context(), chi.URLParam(r, "chain"), chi.URLParam(r, "symbol"), query)
+	if err != nil {
+		serviceError(w, err)
+		return
+	}
+	if result.NextCursor != "" {
+		next := *r.URL
+		values := next.Query()
+		values.Set("cursor", result.NextCursor)
+		next.RawQuery = values.Encode()
+		w.Header().Set("Link", fmt.Sprintf(`<%s>; rel="next"`, next.RequestURI()))
 	}
 
-	result, err := Transfers(symbol, blockheight, sinceBlock, chainID)
+	err = json.NewEncoder(w).Encode(result.Transfers)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+}
 
+// allTransfers writes every transfer matching query as one JSON array, the response of /api/transfers before pages.
+func (c *Controller) allTransfers(w http.ResponseWriter, r *http.Request, query *TransferQuery) {
+	result := []*TransferAPIResponse{}
+	err := c.ExportTransfers(r.Context(), chi.URLParam(r, "chain"), chi.URLParam(r, "symbol"), query, func(transfer *TransferAPIResponse) error {
+		result = append(result, transfer)
+		return nil
+	})
+	if err != nil {
+		serviceError(w, err)
+		return
+	}
 	err = json.NewEncoder(w).Encode(result)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
@@ -297,8 +1100,22 @@ func (c *Controller) Transfers(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// serviceError writes the status matching an error of the Service.
+func serviceError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, ErrUnknownChain), errors.Is(err, ErrUnknownSymbol), errors.Is(err, ErrNoPrice):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, ErrInvalidAddress), errors.Is(err, ErrInvalidCursor), errors.Is(err, ErrCursorMismatch):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	default:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
 type Controller struct {
-	*EthClient
+	*Service
+	Scheduler *Scheduler
+	Leader    *Leader
 }
 
 type SingleResponse struct {
@@ -307,7 +1124,7 @@ type SingleResponse struct {
 }
 
 func (c *Controller) Eth(w http.ResponseWriter, r *http.Request) {
-	price, err := c.ETHUSD()
+	price, err := c.ETHUSD(r.Context())
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -322,7 +1139,7 @@ func (c *Controller) Eth(w http.ResponseWriter, r *http.Request) {
 }
 
 func (c *Controller) Bnb(w http.ResponseWriter, r *http.Request) {
-	price, err := c.BNBUSD()
+	price, err := c.BNBUSD(r.Context())
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -336,7 +1153,7 @@ func (c *Controller) Bnb(w http.ResponseWriter, r *http.Request) {
 	}
 }
 func (c *Controller) Sups(w http.ResponseWriter, r *http.Request) {
-	price, err := c.SUPSUSD()
+	price, err := c.SUPSUSD(r.Context())
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -357,29 +1174,25 @@ type PriceResponse struct {
 	BNBUSD  decimal.Decimal `json:"bnb_usd_cents"`
 }
 
+// PricesHandler returns the current prices, or the last ones recorded at or before ?at= (unix seconds).
 func (c *Controller) PricesHandler(w http.ResponseWriter, r *http.Request) {
-	supsusd, err := c.SUPSUSD()
-	if err != nil {
-		log.Err(err).Msg("get supsusd price")
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
-	}
-	ethusd, err := c.ETHUSD()
-	if err != nil {
-		log.Err(err).Msg("get ethusd price")
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
+	var result *PriceResponse
+	var err error
+	if atStr := r.URL.Query().Get("at"); atStr != "" {
+		at, parseErr := strconv.ParseInt(atStr, 10, 64)
+		if parseErr != nil {
+			http.Error(w, "invalid at", http.StatusBadRequest)
+			return
+		}
+		result, err = c.PriceAt(r.Context(), time.Unix(at, 0))
+	} else {
+		result, err = c.Prices(r.Context())
 	}
-	bnbusd, err := c.BNBUSD()
 	if err != nil {
-		log.Err(err).Msg("get ethusd price")
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		log.Err(err).Msg("get prices")
+		serviceError(w, err)
 		return
 	}
-	result := &PriceResponse{time.Now().Unix(), supsusd, ethusd, bnbusd}
-	if result.SUPSUSD.IsZero() {
-		result.SUPSUSD = decimal.NewFromFloat(0.8)
-	}
 	err = json.NewEncoder(w).Encode(result)
 	if err != nil {
 		log.Err(err).Msg("marshal json")
@@ -388,20 +1201,85 @@ func (c *Controller) PricesHandler(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// PriceChainID is the chain the price contracts of EthClient live on.
+const PriceChainID int64 = 1
+
 type EthClient struct {
-	Client  *ethclient.Client
+	Node    *Node
 	Ethusd  *ethusd.Ethusd
 	Supseth *supseth.Supseth
 	Bnbusd  *ethusd.Ethusd
 }
 
-func (c *EthClient) SUPSUSD() (decimal.Decimal, error) {
-	ethusdPrice, err := c.ETHUSD()
+// USDPrice returns the price of symbol on chainID in dollars, and false when the service doesn't price it there,
+// like the same symbols on testnets.
+func (c *EthClient) USDPrice(ctx context.Context, chainID int64, symbol string) (decimal.Decimal, bool, error) {
+	if !Priced(chainID, symbol) {
+		return decimal.Zero, false, nil
+	}
+	var cents decimal.Decimal
+	var err error
+	switch strings.ToUpper(symbol) {
+	case "SUPS":
+		cents, err = c.SUPSUSD(ctx)
+	case "ETH":
+		cents, err = c.ETHUSD(ctx)
+	case "BNB":
+		cents, err = c.BNBUSD(ctx)
+	default:
+		return decimal.Zero, false, nil
+	}
+	if err != nil {
+		return decimal.Zero, false, err
+	}
+	return cents.Div(decimal.NewFromInt(100)), true, nil
+}
+
+func (c *EthClient) SUPSUSD(ctx context.Context) (decimal.Decimal, error) {
+	return c.supsUSD(&bind.CallOpts{Context: ctx})
+}
+
+func (c *EthClient) ETHUSD(ctx context.Context) (decimal.Decimal, error) {
+	return c.ethUSD(&bind.CallOpts{Context: ctx})
+}
+
+func (c *EthClient) BNBUSD(ctx context.Context) (decimal.Decimal, error) {
+	return c.bnbUSD(&bind.CallOpts{Context: ctx})
+}
+
+// USDPriceAt reads the price of symbol in dollars from the contracts as of a block of the price chain,
+// which needs an archive node for old blocks. It returns false when the service doesn't price symbol, or when the pool
+// had no price yet at block.
+func (c *EthClient) USDPriceAt(ctx context.Context, symbol string, block uint64) (decimal.Decimal, bool, error) {
+	opts := &bind.CallOpts{Context: ctx, BlockNumber: new(big.Int).SetUint64(block)}
+	var cents decimal.Decimal
+	var err error
+	switch strings.ToUpper(symbol) {
+	case "SUPS":
+		cents, err = c.supsUSD(opts)
+	case "ETH":
+		cents, err = c.ethUSD(opts)
+	case "BNB":
+		cents, err = c.bnbUSD(opts)
+	default:
+		return decimal.Zero, false, nil
+	}
+	if errors.Is(err, ErrNoPoolPrice) {
+		return decimal.Zero, false, nil
+	}
+	if err != nil {
+		return decimal.Zero, false, err
+	}
+	return cents.Div(decimal.NewFromInt(100)), true, nil
+}
+
+func (c *EthClient) supsUSD(opts *bind.CallOpts) (decimal.Decimal, error) {
+	ethusdPrice, err := c.ethUSD(opts)
 	if err != nil {
 		return decimal.Zero, fmt.Errorf("query supsusd: %w", err)
 	}
 
-	result, err := c.Supseth.Slot0(&bind.CallOpts{})
+	result, err := c.Supseth.Slot0(opts)
 	if err != nil {
 		return decimal.Zero, fmt.Errorf("query slot0: %w", err)
 	}
@@ -410,21 +1288,25 @@ func (c *EthClient) SUPSUSD() (decimal.Decimal, error) {
 
 	supsEthPrice := sqrtprice.Pow(decimal.NewFromInt(2)).
 		Div(decimal.NewFromInt(2).Pow(decimal.NewFromInt(192)))
+	if supsEthPrice.IsZero() {
+		return decimal.Zero, ErrNoPoolPrice
+	}
 
 	supsUsdPrice := ethusdPrice.Div(supsEthPrice)
 
 	return supsUsdPrice, nil
 }
-func (c *EthClient) ETHUSD() (decimal.Decimal, error) {
-	result, err := c.Ethusd.LatestRoundData(&bind.CallOpts{})
+
+func (c *EthClient) ethUSD(opts *bind.CallOpts) (decimal.Decimal, error) {
+	result, err := c.Ethusd.LatestRoundData(opts)
 	if err != nil {
 		return decimal.Zero, fmt.Errorf("query ethusd: %w", err)
 	}
 	return decimal.NewFromBigInt(result.Answer, -6), nil
 }
 
-func (c *EthClient) BNBUSD() (decimal.Decimal, error) {
-	result, err := c.Bnbusd.LatestRoundData(&bind.CallOpts{})
+func (c *EthClient) bnbUSD(opts *bind.CallOpts) (decimal.Decimal, error) {
+	result, err := c.Bnbusd.LatestRoundData(opts)
 	if err != nil {
 		return decimal.Zero, fmt.Errorf("query bnbusd: %w", err)
 	}
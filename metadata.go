@@ -0,0 +1,160 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"xsyn-pricefeed/erc20"
+
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/go-chi/chi/v5"
+)
+
+type TokenMetadata struct {
+	ChainID  int64  `json:"chain_id"`
+	Contract string `json:"contract"`
+	Name     string `json:"name"`
+	Symbol   string `json:"symbol"`
+	Decimals int    `json:"decimals"`
+}
+
+type tokenKey struct {
+	ChainID  int64
+	Contract common.Address
+}
+
+// TokenMetadataCache keeps the name, symbol and decimals of ERC-20 contracts, which never change,
+// in memory and in the tokens table.
+type TokenMetadataCache struct {
+	mu     sync.Mutex
+	tokens map[tokenKey]*TokenMetadata
+}
+
+var tokenMetadata = &TokenMetadataCache{tokens: map[tokenKey]*TokenMetadata{}}
+
+// Resolve returns the metadata of a contract, checking memory, then the tokens table, then the contract itself.
+func (c *TokenMetadataCache) Resolve(ctx context.Context, node *Node, chainID int64, contract common.Address) (*TokenMetadata, error) {
+	key := tokenKey{chainID, contract}
+	c.mu.Lock()
+	cached, ok := c.tokens[key]
+	c.mu.Unlock()
+	if ok {
+		return cached, nil
+	}
+
+	result, err := StoredTokenMetadata(chainID, contract)
+	if err != nil {
+		return nil, fmt.Errorf("get stored token metadata: %w", err)
+	}
+	if result == nil {
+		result, err = ReadTokenMetadata(ctx, node, chainID, contract)
+		if err != nil {
+			return nil, err
+		}
+		err = AddTokenMetadata(result)
+		if err != nil {
+			return nil, fmt.Errorf("save token metadata: %w", err)
+		}
+	}
+
+	c.mu.Lock()
+	c.tokens[key] = result
+	c.mu.Unlock()
+	return result, nil
+}
+
+// ReadTokenMetadata calls name(), symbol() and decimals() on a contract. Tokens returning bytes32 from
+// name() and symbol() are supported, and a missing name() is left empty.
+func ReadTokenMetadata(ctx context.Context, node *Node, chainID int64, contract common.Address) (*TokenMetadata, error) {
+	caller, err := erc20.NewErc20Caller(contract, node)
+	if err != nil {
+		return nil, fmt.Errorf("erc20 caller: %w", err)
+	}
+	bytes32Caller, err := erc20.NewErc20Bytes32Caller(contract, node)
+	if err != nil {
+		return nil, fmt.Errorf("erc20 bytes32 caller: %w", err)
+	}
+	opts := &bind.CallOpts{Context: ctx}
+
+	decimals, err := caller.Decimals(opts)
+	if err != nil {
+		return nil, fmt.Errorf("get decimals of %s: %w", contract.Hex(), err)
+	}
+	symbol, err := tokenString(opts, caller.Symbol, bytes32Caller.Symbol)
+	if err != nil {
+		return nil, fmt.Errorf("get symbol of %s: %w", contract.Hex(), err)
+	}
+	name, err := tokenString(opts, caller.Name, bytes32Caller.Name)
+	if err != nil {
+		log.Warn().Err(err).Int64("chain_id", chainID).Str("contract", contract.Hex()).Msg("get token name")
+	}
+	return &TokenMetadata{
+		ChainID:  chainID,
+		Contract: contract.Hex(),
+		Name:     name,
+		Symbol:   symbol,
+		Decimals: int(decimals),
+	}, nil
+}
+
+// tokenString reads a string getter, falling back to its bytes32 form.
+func tokenString(opts *bind.CallOpts, str func(opts *bind.CallOpts) (string, error), b32 func(opts *bind.CallOpts) ([32]byte, error)) (string, error) {
+	result, err := str(opts)
+	if err == nil {
+		return result, nil
+	}
+	raw, b32Err := b32(opts)
+	if b32Err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(raw[:]), "\x00"), nil
+}
+
+// ResolveAsset fills in the symbol and decimals of a token asset from its contract.
+// A configured symbol is kept, since it names the asset in the API and the database,
+// but the contract's decimals always win.
+func ResolveAsset(ctx context.Context, node *Node, chainID int64, asset *Asset) error {
+	if asset.Native() {
+		return nil
+	}
+	meta, err := tokenMetadata.Resolve(ctx, node, chainID, asset.ContractAddress())
+	if err != nil {
+		return fmt.Errorf("resolve token %s: %w", asset.Contract, err)
+	}
+	if asset.Symbol == "" {
+		asset.Symbol = strings.ToUpper(meta.Symbol)
+	}
+	if asset.Decimals != 0 && asset.Decimals != meta.Decimals {
+		log.Warn().Int64("chain_id", chainID).Str("symbol", asset.Symbol).Int("configured", asset.Decimals).Int("contract", meta.Decimals).Msg("configured decimals differ from the contract, using the contract's")
+	}
+	asset.Decimals = meta.Decimals
+	return nil
+}
+
+// Token returns the name, symbol and decimals of any ERC-20 contract on a chain.
+func (c *Controller) Token(w http.ResponseWriter, r *http.Request) {
+	chain, ok := c.Chains.Lookup(chi.URLParam(r, "chain"))
+	if !ok {
+		http.Error(w, "unknown chain", http.StatusNotFound)
+		return
+	}
+	contractStr := chi.URLParam(r, "contract")
+	if !common.IsHexAddress(contractStr) {
+		http.Error(w, "invalid contract", http.StatusBadRequest)
+		return
+	}
+	result, err := tokenMetadata.Resolve(r.Context(), chain.Node, chain.ID, common.HexToAddress(contractStr))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	err = json.NewEncoder(w).Encode(result)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
@@ -0,0 +1,678 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	endpointHealthyGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "xsyn_pricefeed_rpc_endpoint_healthy",
+		Help: "1 while an RPC endpoint answers and keeps up with the others, 0 otherwise.",
+	}, []string{"chain", "endpoint"})
+	endpointHeadGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "xsyn_pricefeed_rpc_endpoint_head",
+		Help: "Block number last reported by an RPC endpoint.",
+	}, []string{"chain", "endpoint"})
+	endpointFailoversCounter = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "xsyn_pricefeed_rpc_failovers_total",
+		Help: "How many calls were retried on the next endpoint after an endpoint failed.",
+	}, []string{"chain", "endpoint"})
+)
+
+func init() {
+	prometheus.MustRegister(endpointHealthyGauge, endpointHeadGauge, endpointFailoversCounter)
+}
+
+// DefaultMaxLag is how many blocks an endpoint may trail the most advanced one before it is taken out of rotation.
+const DefaultMaxLag = 5
+
+// Endpoint is one RPC URL of a Node. It is dialled lazily, and redialled by health checks after a failed dial.
+type Endpoint struct {
+	URL string
+	// Name identifies the endpoint in logs and metrics without leaking the API key in its path.
+	Name string
+
+	limiter *TokenBucket
+
+	mu        sync.Mutex
+	client    *ethclient.Client
+	rpc       *rpc.Client
+	healthy   bool
+	head      uint64
+	lastErr   error
+	checkedAt time.Time
+}
+
+type EndpointStatus struct {
+	Name      string     `json:"name"`
+	Healthy   bool       `json:"healthy"`
+	Head      uint64     `json:"head"`
+	LastError string     `json:"last_error,omitempty"`
+	CheckedAt *time.Time `json:"checked_at"`
+}
+
+func (e *Endpoint) clients(ctx context.Context) (*ethclient.Client, *rpc.Client, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.rpc == nil {
+		rpcClient, err := rpc.DialContext(ctx, e.URL)
+		if err != nil {
+			return nil, nil, fmt.Errorf("dial %s: %w", e.Name, err)
+		}
+		e.rpc = rpcClient
+		e.client = ethclient.NewClient(rpcClient)
+	}
+	return e.client, e.rpc, nil
+}
+
+func (e *Endpoint) isHealthy() bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.healthy
+}
+
+// Node is the RPC client of a chain. It spreads over several endpoints: calls go to the first healthy one
+// and move on to the next when an endpoint fails. Monitor takes endpoints that fail or lag out of rotation
+// and puts them back once they recover.
+// It implements bind.ContractBackend, so contract bindings fail over too.
+type Node struct {
+	Chain     string
+	Endpoints []*Endpoint
+	// MaxLag is how many blocks an endpoint may trail the most advanced one and stay healthy.
+	MaxLag uint64
+	// Retries is how many times a rate limited request is retried on the same endpoint, with exponential backoff,
+	// before moving on to the next endpoint.
+	Retries int
+}
+
+var _ bind.ContractBackend = (*Node)(nil)
+
+// DialNode sets up a node over rawurls. Websocket endpoints connect straight away and the node fails
+// only when none of them do; an endpoint that couldn't connect is retried by health checks.
+func DialNode(chain string, rawurls ...string) (*Node, error) {
+	if len(rawurls) == 0 {
+		return nil, fmt.Errorf("no rpc urls")
+	}
+	n := &Node{Chain: chain, MaxLag: DefaultMaxLag, Retries: DefaultRPCRetries}
+	var dialErr error
+	dialled := 0
+	for i, rawurl := range rawurls {
+		e := &Endpoint{URL: rawurl, Name: endpointName(i, rawurl), healthy: true}
+		n.Endpoints = append(n.Endpoints, e)
+		_, _, err := e.clients(context.Background())
+		if err != nil {
+			log.Err(err).Str("chain", chain).Str("endpoint", e.Name).Msg("dial endpoint")
+			dialErr = err
+			e.healthy = false
+			continue
+		}
+		dialled++
+		endpointHealthyGauge.WithLabelValues(chain, e.Name).Set(1)
+	}
+	if dialled == 0 {
+		return nil, dialErr
+	}
+	return n, nil
+}
+
+func endpointName(i int, rawurl string) string {
+	u, err := url.Parse(rawurl)
+	if err != nil || u.Host == "" {
+		return fmt.Sprintf("%d", i)
+	}
+	return fmt.Sprintf("%d:%s", i, u.Host)
+}
+
+// SplitURLs splits a comma separated flag of RPC URLs.
+func SplitURLs(urls string) []string {
+	result := []string{}
+	for _, u := range strings.Split(urls, ",") {
+		if strings.TrimSpace(u) != "" {
+			result = append(result, strings.TrimSpace(u))
+		}
+	}
+	return result
+}
+
+// SetRateLimit limits every endpoint to rate requests per second, in bursts of up to burst. A rate of 0 removes the limit.
+func (n *Node) SetRateLimit(rate float64, burst int) {
+	for _, e := range n.Endpoints {
+		e.limiter = NewTokenBucket(rate, burst)
+	}
+}
+
+// call sends a request to one endpoint through its rate limiter, retrying with exponential backoff while
+// the endpoint rate limits it, and records it in the RPC metrics. weight is the number of calls in the request.
+func (n *Node) call(ctx context.Context, e *Endpoint, method string, weight int, fn func(ctx context.Context, client *ethclient.Client, rpcClient *rpc.Client) error) error {
+	client, rpcClient, err := e.clients(ctx)
+	if err != nil {
+		rpcRequestsCounter.WithLabelValues(n.Chain, e.Name, method, "error").Inc()
+		return err
+	}
+	wait := RateLimitMinBackoff
+	for attempt := 0; ; attempt++ {
+		throttled := time.Now()
+		err = e.limiter.Wait(ctx, weight)
+		if err != nil {
+			return err
+		}
+		if e.limiter != nil && e.limiter.Rate > 0 {
+			rpcThrottledHistogram.WithLabelValues(n.Chain, e.Name).Observe(time.Since(throttled).Seconds())
+		}
+
+		start := time.Now()
+		err = fn(ctx, client, rpcClient)
+		status := rpcStatus(err)
+		rpcRequestsCounter.WithLabelValues(n.Chain, e.Name, method, status).Inc()
+		rpcCallsCounter.WithLabelValues(n.Chain, e.Name, method).Add(float64(weight))
+		rpcDurationHistogram.WithLabelValues(n.Chain, method, status).Observe(time.Since(start).Seconds())
+		if status != "rate_limited" || attempt >= n.Retries {
+			return err
+		}
+
+		rpcRetriesCounter.WithLabelValues(n.Chain, e.Name, method).Inc()
+		log.Warn().Err(err).Str("chain", n.Chain).Str("endpoint", e.Name).Str("method", method).Int("attempt", attempt+1).Str("retry_in", wait.String()).Msg("rate limited")
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+		wait *= 2
+		if wait > RateLimitMaxBackoff {
+			wait = RateLimitMaxBackoff
+		}
+	}
+}
+
+// candidates orders the endpoints to try: the healthy ones first, in configuration order.
+// Unhealthy endpoints come last, so calls still go through when every endpoint is marked down.
+func (n *Node) candidates() []*Endpoint {
+	healthy := []*Endpoint{}
+	unhealthy := []*Endpoint{}
+	for _, e := range n.Endpoints {
+		if e.isHealthy() {
+			healthy = append(healthy, e)
+		} else {
+			unhealthy = append(unhealthy, e)
+		}
+	}
+	return append(healthy, unhealthy...)
+}
+
+// shouldFailover tells errors from the endpoint apart from errors another endpoint would return too.
+func shouldFailover(ctx context.Context, err error) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+	if IsLogRangeError(err) {
+		return false
+	}
+	if strings.Contains(strings.ToLower(err.Error()), "execution reverted") {
+		return false
+	}
+	return true
+}
+
+// ErrEndpointsBehind fails a read of a block range no endpoint has reached yet.
+var ErrEndpointsBehind = errors.New("no rpc endpoint has reached block")
+
+// candidatesAt orders the endpoints whose head is at least block like candidates. An endpoint whose last known
+// head is below block is asked for its block number first, since health checks only run every interval.
+// Range reads go to these only: an endpoint that is behind answers for blocks it doesn't have with nothing.
+func (n *Node) candidatesAt(ctx context.Context, block uint64) []*Endpoint {
+	result := []*Endpoint{}
+	for _, e := range n.candidates() {
+		e.mu.Lock()
+		head := e.head
+		e.mu.Unlock()
+		if head < block {
+			err := n.call(ctx, e, "eth_blockNumber", 1, func(ctx context.Context, client *ethclient.Client, rpcClient *rpc.Client) error {
+				var err error
+				head, err = client.BlockNumber(ctx)
+				return err
+			})
+			if err != nil {
+				continue
+			}
+			e.mu.Lock()
+			if head > e.head {
+				e.head = head
+				endpointHeadGauge.WithLabelValues(n.Chain, e.Name).Set(float64(head))
+			}
+			e.mu.Unlock()
+		}
+		if head >= block {
+			result = append(result, e)
+		}
+	}
+	return result
+}
+
+// do runs fn on the endpoints in turn until one succeeds or fails with an error that isn't the endpoint's fault.
+func (n *Node) do(ctx context.Context, method string, weight int, fn func(ctx context.Context, client *ethclient.Client, rpcClient *rpc.Client) error) error {
+	return n.try(ctx, n.candidates(), method, weight, fn)
+}
+
+// doAt is do for reads of blocks up to block, which only go to endpoints that have it.
+func (n *Node) doAt(ctx context.Context, block uint64, method string, weight int, fn func(ctx context.Context, client *ethclient.Client, rpcClient *rpc.Client) error) error {
+	candidates := n.candidatesAt(ctx, block)
+	if len(candidates) == 0 {
+		return fmt.Errorf("%s: %w %d", method, ErrEndpointsBehind, block)
+	}
+	return n.try(ctx, candidates, method, weight, fn)
+}
+
+func (n *Node) try(ctx context.Context, candidates []*Endpoint, method string, weight int, fn func(ctx context.Context, client *ethclient.Client, rpcClient *rpc.Client) error) error {
+	var err error
+	for i, e := range candidates {
+		err = n.call(ctx, e, method, weight, fn)
+		if err == nil || !shouldFailover(ctx, err) {
+			return err
+		}
+		n.markDown(e, err)
+		if i < len(candidates)-1 {
+			endpointFailoversCounter.WithLabelValues(n.Chain, e.Name).Inc()
+			log.Warn().Err(err).Str("chain", n.Chain).Str("endpoint", e.Name).Str("method", method).Msg("rpc endpoint failed, trying the next one")
+		}
+	}
+	return err
+}
+
+func (n *Node) markDown(e *Endpoint, err error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.lastErr = err
+	if e.healthy {
+		e.healthy = false
+		endpointHealthyGauge.WithLabelValues(n.Chain, e.Name).Set(0)
+	}
+}
+
+// Monitor checks the block number of every endpoint each interval until ctx is cancelled.
+func (n *Node) Monitor(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		n.Check(ctx, interval)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Check marks the endpoints healthy when they answer within timeout and trail the most advanced one by at most MaxLag blocks.
+func (n *Node) Check(ctx context.Context, timeout time.Duration) {
+	heads := make([]uint64, len(n.Endpoints))
+	errs := make([]error, len(n.Endpoints))
+	wg := &sync.WaitGroup{}
+	for i, e := range n.Endpoints {
+		wg.Add(1)
+		go func(i int, e *Endpoint) {
+			defer wg.Done()
+			checkCtx, cancel := context.WithTimeout(ctx, timeout)
+			defer cancel()
+			errs[i] = n.call(checkCtx, e, "eth_blockNumber", 1, func(ctx context.Context, client *ethclient.Client, rpcClient *rpc.Client) error {
+				var err error
+				heads[i], err = client.BlockNumber(ctx)
+				return err
+			})
+		}(i, e)
+	}
+	wg.Wait()
+	if ctx.Err() != nil {
+		return
+	}
+
+	highest := uint64(0)
+	for i := range n.Endpoints {
+		if errs[i] == nil && heads[i] > highest {
+			highest = heads[i]
+		}
+	}
+	now := time.Now()
+	for i, e := range n.Endpoints {
+		err := errs[i]
+		if err == nil && heads[i]+n.MaxLag < highest {
+			err = fmt.Errorf("lagging %d blocks behind", highest-heads[i])
+		}
+		e.mu.Lock()
+		wasHealthy := e.healthy
+		e.healthy = err == nil
+		e.lastErr = err
+		e.checkedAt = now
+		if errs[i] == nil {
+			e.head = heads[i]
+			endpointHeadGauge.WithLabelValues(n.Chain, e.Name).Set(float64(heads[i]))
+		}
+		e.mu.Unlock()
+		if e.healthy {
+			endpointHealthyGauge.WithLabelValues(n.Chain, e.Name).Set(1)
+		} else {
+			endpointHealthyGauge.WithLabelValues(n.Chain, e.Name).Set(0)
+		}
+		if wasHealthy && err != nil {
+			log.Warn().Err(err).Str("chain", n.Chain).Str("endpoint", e.Name).Msg("rpc endpoint unhealthy")
+		}
+		if !wasHealthy && err == nil {
+			log.Info().Str("chain", n.Chain).Str("endpoint", e.Name).Msg("rpc endpoint recovered")
+		}
+	}
+}
+
+func (n *Node) Status() []*EndpointStatus {
+	result := []*EndpointStatus{}
+	for _, e := range n.Endpoints {
+		e.mu.Lock()
+		status := &EndpointStatus{Name: e.Name, Healthy: e.healthy, Head: e.head}
+		if e.lastErr != nil {
+			status.LastError = e.lastErr.Error()
+		}
+		if !e.checkedAt.IsZero() {
+			checkedAt := e.checkedAt
+			status.CheckedAt = &checkedAt
+		}
+		e.mu.Unlock()
+		result = append(result, status)
+	}
+	return result
+}
+
+func (n *Node) BlockNumber(ctx context.Context) (uint64, error) {
+	var result uint64
+	err := n.do(ctx, "eth_blockNumber", 1, func(ctx context.Context, client *ethclient.Client, rpcClient *rpc.Client) error {
+		var err error
+		result, err = client.BlockNumber(ctx)
+		return err
+	})
+	return result, err
+}
+
+func (n *Node) HeaderByHash(ctx context.Context, hash common.Hash) (*types.Header, error) {
+	var result *types.Header
+	err := n.do(ctx, "eth_getBlockByHash", 1, func(ctx context.Context, client *ethclient.Client, rpcClient *rpc.Client) error {
+		var err error
+		result, err = client.HeaderByHash(ctx, hash)
+		return err
+	})
+	return result, err
+}
+
+func (n *Node) HeaderByNumber(ctx context.Context, number *big.Int) (*types.Header, error) {
+	var result *types.Header
+	fn := func(ctx context.Context, client *ethclient.Client, rpcClient *rpc.Client) error {
+		var err error
+		result, err = client.HeaderByNumber(ctx, number)
+		return err
+	}
+	var err error
+	if number == nil {
+		err = n.do(ctx, "eth_getBlockByNumber", 1, fn)
+	} else {
+		err = n.doAt(ctx, number.Uint64(), "eth_getBlockByNumber", 1, fn)
+	}
+	return result, err
+}
+
+// FilterLogs only asks endpoints that have reached query.ToBlock, when it is set.
+func (n *Node) FilterLogs(ctx context.Context, query ethereum.FilterQuery) ([]types.Log, error) {
+	var result []types.Log
+	fn := func(ctx context.Context, client *ethclient.Client, rpcClient *rpc.Client) error {
+		var err error
+		result, err = client.FilterLogs(ctx, query)
+		return err
+	}
+	var err error
+	if query.ToBlock == nil || query.BlockHash != nil {
+		err = n.do(ctx, "eth_getLogs", 1, fn)
+	} else {
+		err = n.doAt(ctx, query.ToBlock.Uint64(), "eth_getLogs", 1, fn)
+	}
+	return result, err
+}
+
+func (n *Node) CodeAt(ctx context.Context, contract common.Address, blockNumber *big.Int) ([]byte, error) {
+	var result []byte
+	err := n.do(ctx, "eth_getCode", 1, func(ctx context.Context, client *ethclient.Client, rpcClient *rpc.Client) error {
+		var err error
+		result, err = client.CodeAt(ctx, contract, blockNumber)
+		return err
+	})
+	return result, err
+}
+
+func (n *Node) CallContract(ctx context.Context, call ethereum.CallMsg, blockNumber *big.Int) ([]byte, error) {
+	var result []byte
+	err := n.do(ctx, "eth_call", 1, func(ctx context.Context, client *ethclient.Client, rpcClient *rpc.Client) error {
+		var err error
+		result, err = client.CallContract(ctx, call, blockNumber)
+		return err
+	})
+	return result, err
+}
+
+func (n *Node) PendingCodeAt(ctx context.Context, account common.Address) ([]byte, error) {
+	var result []byte
+	err := n.do(ctx, "eth_getCode", 1, func(ctx context.Context, client *ethclient.Client, rpcClient *rpc.Client) error {
+		var err error
+		result, err = client.PendingCodeAt(ctx, account)
+		return err
+	})
+	return result, err
+}
+
+func (n *Node) PendingNonceAt(ctx context.Context, account common.Address) (uint64, error) {
+	var result uint64
+	err := n.do(ctx, "eth_getTransactionCount", 1, func(ctx context.Context, client *ethclient.Client, rpcClient *rpc.Client) error {
+		var err error
+		result, err = client.PendingNonceAt(ctx, account)
+		return err
+	})
+	return result, err
+}
+
+func (n *Node) SuggestGasPrice(ctx context.Context) (*big.Int, error) {
+	var result *big.Int
+	err := n.do(ctx, "eth_gasPrice", 1, func(ctx context.Context, client *ethclient.Client, rpcClient *rpc.Client) error {
+		var err error
+		result, err = client.SuggestGasPrice(ctx)
+		return err
+	})
+	return result, err
+}
+
+func (n *Node) SuggestGasTipCap(ctx context.Context) (*big.Int, error) {
+	var result *big.Int
+	err := n.do(ctx, "eth_maxPriorityFeePerGas", 1, func(ctx context.Context, client *ethclient.Client, rpcClient *rpc.Client) error {
+		var err error
+		result, err = client.SuggestGasTipCap(ctx)
+		return err
+	})
+	return result, err
+}
+
+func (n *Node) EstimateGas(ctx context.Context, call ethereum.CallMsg) (uint64, error) {
+	var result uint64
+	err := n.do(ctx, "eth_estimateGas", 1, func(ctx context.Context, client *ethclient.Client, rpcClient *rpc.Client) error {
+		var err error
+		result, err = client.EstimateGas(ctx, call)
+		return err
+	})
+	return result, err
+}
+
+func (n *Node) SendTransaction(ctx context.Context, tx *types.Transaction) error {
+	return n.do(ctx, "eth_sendRawTransaction", 1, func(ctx context.Context, client *ethclient.Client, rpcClient *rpc.Client) error {
+		return client.SendTransaction(ctx, tx)
+	})
+}
+
+// BatchCallContextAt sends a JSON-RPC batch reading blocks up to block to one endpoint that has it. Only a failure
+// of the whole batch moves it to the next endpoint; errors of single calls are left in their elements.
+func (n *Node) BatchCallContextAt(ctx context.Context, block uint64, elems []rpc.BatchElem) error {
+	return n.doAt(ctx, block, batchMethod(elems), len(elems), batchCall(elems))
+}
+
+func batchMethod(elems []rpc.BatchElem) string {
+	if len(elems) > 0 {
+		return "batch_" + elems[0].Method
+	}
+	return "batch"
+}
+
+func batchCall(elems []rpc.BatchElem) func(ctx context.Context, client *ethclient.Client, rpcClient *rpc.Client) error {
+	return func(ctx context.Context, client *ethclient.Client, rpcClient *rpc.Client) error {
+		for i := range elems {
+			elems[i].Error = nil
+		}
+		return rpcClient.BatchCallContext(ctx, elems)
+	}
+}
+
+// subscribe tries the endpoints in turn. It only returns rpc.ErrNotificationsUnsupported when no endpoint supports subscriptions.
+func (n *Node) subscribe(ctx context.Context, fn func(client *ethclient.Client) (ethereum.Subscription, error)) (ethereum.Subscription, error) {
+	var result error
+	for _, e := range n.candidates() {
+		var sub ethereum.Subscription
+		err := n.call(ctx, e, "eth_subscribe", 1, func(ctx context.Context, client *ethclient.Client, rpcClient *rpc.Client) error {
+			var err error
+			sub, err = fn(client)
+			return err
+		})
+		if err == nil {
+			return sub, nil
+		}
+		if errors.Is(err, rpc.ErrNotificationsUnsupported) {
+			if result == nil {
+				result = err
+			}
+			continue
+		}
+		n.markDown(e, err)
+		result = err
+	}
+	return nil, result
+}
+
+func (n *Node) SubscribeNewHead(ctx context.Context, ch chan<- *types.Header) (ethereum.Subscription, error) {
+	return n.subscribe(ctx, func(client *ethclient.Client) (ethereum.Subscription, error) {
+		return client.SubscribeNewHead(ctx, ch)
+	})
+}
+
+func (n *Node) SubscribeFilterLogs(ctx context.Context, query ethereum.FilterQuery, ch chan<- types.Log) (ethereum.Subscription, error) {
+	return n.subscribe(ctx, func(client *ethclient.Client) (ethereum.Subscription, error) {
+		return client.SubscribeFilterLogs(ctx, query, ch)
+	})
+}
+
+// QuorumNode answers contract calls only when Quorum endpoints return the same result at the same block.
+// Calls for the latest block are pinned to the highest block that at least Quorum endpoints have reached.
+type QuorumNode struct {
+	*Node
+	Quorum int
+}
+
+func NewQuorumNode(node *Node, quorum int) (*QuorumNode, error) {
+	if quorum < 1 || quorum > len(node.Endpoints) {
+		return nil, fmt.Errorf("quorum of %d needs between 1 and %d endpoints", quorum, len(node.Endpoints))
+	}
+	return &QuorumNode{node, quorum}, nil
+}
+
+func (q *QuorumNode) CallContract(ctx context.Context, call ethereum.CallMsg, blockNumber *big.Int) ([]byte, error) {
+	if blockNumber == nil {
+		pinned, err := q.quorumHead(ctx)
+		if err != nil {
+			return nil, err
+		}
+		blockNumber = new(big.Int).SetUint64(pinned)
+	}
+
+	results := make([][]byte, len(q.Endpoints))
+	errs := make([]error, len(q.Endpoints))
+	wg := &sync.WaitGroup{}
+	for i, e := range q.Endpoints {
+		wg.Add(1)
+		go func(i int, e *Endpoint) {
+			defer wg.Done()
+			errs[i] = q.call(ctx, e, "eth_call", 1, func(ctx context.Context, client *ethclient.Client, rpcClient *rpc.Client) error {
+				var err error
+				results[i], err = client.CallContract(ctx, call, blockNumber)
+				return err
+			})
+		}(i, e)
+	}
+	wg.Wait()
+
+	for i := range results {
+		if errs[i] != nil {
+			continue
+		}
+		agree := 0
+		for j := range results {
+			if errs[j] == nil && bytes.Equal(results[i], results[j]) {
+				agree++
+			}
+		}
+		if agree >= q.Quorum {
+			return results[i], nil
+		}
+	}
+	for i, err := range errs {
+		if err != nil {
+			log.Warn().Err(err).Str("chain", q.Chain).Str("endpoint", q.Endpoints[i].Name).Msg("quorum call")
+		}
+	}
+	return nil, fmt.Errorf("no quorum at block %s: fewer than %d of %d endpoints agree", blockNumber, q.Quorum, len(q.Endpoints))
+}
+
+func (q *QuorumNode) quorumHead(ctx context.Context) (uint64, error) {
+	heads := []uint64{}
+	mu := &sync.Mutex{}
+	wg := &sync.WaitGroup{}
+	for _, e := range q.Endpoints {
+		wg.Add(1)
+		go func(e *Endpoint) {
+			defer wg.Done()
+			var head uint64
+			err := q.call(ctx, e, "eth_blockNumber", 1, func(ctx context.Context, client *ethclient.Client, rpcClient *rpc.Client) error {
+				var err error
+				head, err = client.BlockNumber(ctx)
+				return err
+			})
+			if err != nil {
+				return
+			}
+			mu.Lock()
+			heads = append(heads, head)
+			mu.Unlock()
+		}(e)
+	}
+	wg.Wait()
+	if len(heads) < q.Quorum {
+		return 0, fmt.Errorf("no quorum: only %d of %d endpoints answered", len(heads), len(q.Endpoints))
+	}
+	sort.Slice(heads, func(i, j int) bool { return heads[i] > heads[j] })
+	return heads[q.Quorum-1], nil
+}
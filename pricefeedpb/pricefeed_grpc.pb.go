@@ -0,0 +1,312 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.3.0
+// - protoc             (unknown)
+// source: pricefeed.proto
+
+package pricefeedpb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.32.0 or later.
+const _ = grpc.SupportPackageIsVersion7
+
+const (
+	Pricefeed_GetPrices_FullMethodName      = "/pricefeed.v1.Pricefeed/GetPrices"
+	Pricefeed_GetPriceAt_FullMethodName     = "/pricefeed.v1.Pricefeed/GetPriceAt"
+	Pricefeed_ListTransfers_FullMethodName  = "/pricefeed.v1.Pricefeed/ListTransfers"
+	Pricefeed_WatchTransfers_FullMethodName = "/pricefeed.v1.Pricefeed/WatchTransfers"
+	Pricefeed_WatchPrices_FullMethodName    = "/pricefeed.v1.Pricefeed/WatchPrices"
+)
+
+// PricefeedClient is the client API for Pricefeed service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type PricefeedClient interface {
+	GetPrices(ctx context.Context, in *GetPricesRequest, opts ...grpc.CallOption) (*Prices, error)
+	GetPriceAt(ctx context.Context, in *GetPriceAtRequest, opts ...grpc.CallOption) (*Prices, error)
+	ListTransfers(ctx context.Context, in *ListTransfersRequest, opts ...grpc.CallOption) (*ListTransfersResponse, error)
+	WatchTransfers(ctx context.Context, in *WatchTransfersRequest, opts ...grpc.CallOption) (Pricefeed_WatchTransfersClient, error)
+	WatchPrices(ctx context.Context, in *WatchPricesRequest, opts ...grpc.CallOption) (Pricefeed_WatchPricesClient, error)
+}
+
+type pricefeedClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewPricefeedClient(cc grpc.ClientConnInterface) PricefeedClient {
+	return &pricefeedClient{cc}
+}
+
+func (c *pricefeedClient) GetPrices(ctx context.Context, in *GetPricesRequest, opts ...grpc.CallOption) (*Prices, error) {
+	out := new(Prices)
+	err := c.cc.Invoke(ctx, Pricefeed_GetPrices_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *pricefeedClient) GetPriceAt(ctx context.Context, in *GetPriceAtRequest, opts ...grpc.CallOption) (*Prices, error) {
+	out := new(Prices)
+	err := c.cc.Invoke(ctx, Pricefeed_GetPriceAt_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *pricefeedClient) ListTransfers(ctx context.Context, in *ListTransfersRequest, opts ...grpc.CallOption) (*ListTransfersResponse, error) {
+	out := new(ListTransfersResponse)
+	err := c.cc.Invoke(ctx, Pricefeed_ListTransfers_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *pricefeedClient) WatchTransfers(ctx context.Context, in *WatchTransfersRequest, opts ...grpc.CallOption) (Pricefeed_WatchTransfersClient, error) {
+	stream, err := c.cc.NewStream(ctx, &Pricefeed_ServiceDesc.Streams[0], Pricefeed_WatchTransfers_FullMethodName, opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &pricefeedWatchTransfersClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type Pricefeed_WatchTransfersClient interface {
+	Recv() (*TransferEvent, error)
+	grpc.ClientStream
+}
+
+type pricefeedWatchTransfersClient struct {
+	grpc.ClientStream
+}
+
+func (x *pricefeedWatchTransfersClient) Recv() (*TransferEvent, error) {
+	m := new(TransferEvent)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *pricefeedClient) WatchPrices(ctx context.Context, in *WatchPricesRequest, opts ...grpc.CallOption) (Pricefeed_WatchPricesClient, error) {
+	stream, err := c.cc.NewStream(ctx, &Pricefeed_ServiceDesc.Streams[1], Pricefeed_WatchPrices_FullMethodName, opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &pricefeedWatchPricesClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type Pricefeed_WatchPricesClient interface {
+	Recv() (*PriceEvent, error)
+	grpc.ClientStream
+}
+
+type pricefeedWatchPricesClient struct {
+	grpc.ClientStream
+}
+
+func (x *pricefeedWatchPricesClient) Recv() (*PriceEvent, error) {
+	m := new(PriceEvent)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// PricefeedServer is the server API for Pricefeed service.
+// All implementations must embed UnimplementedPricefeedServer
+// for forward compatibility
+type PricefeedServer interface {
+	GetPrices(context.Context, *GetPricesRequest) (*Prices, error)
+	GetPriceAt(context.Context, *GetPriceAtRequest) (*Prices, error)
+	ListTransfers(context.Context, *ListTransfersRequest) (*ListTransfersResponse, error)
+	WatchTransfers(*WatchTransfersRequest, Pricefeed_WatchTransfersServer) error
+	WatchPrices(*WatchPricesRequest, Pricefeed_WatchPricesServer) error
+	mustEmbedUnimplementedPricefeedServer()
+}
+
+// UnimplementedPricefeedServer must be embedded to have forward compatible implementations.
+type UnimplementedPricefeedServer struct {
+}
+
+func (UnimplementedPricefeedServer) GetPrices(context.Context, *GetPricesRequest) (*Prices, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetPrices not implemented")
+}
+func (UnimplementedPricefeedServer) GetPriceAt(context.Context, *GetPriceAtRequest) (*Prices, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetPriceAt not implemented")
+}
+func (UnimplementedPricefeedServer) ListTransfers(context.Context, *ListTransfersRequest) (*ListTransfersResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListTransfers not implemented")
+}
+func (UnimplementedPricefeedServer) WatchTransfers(*WatchTransfersRequest, Pricefeed_WatchTransfersServer) error {
+	return status.Errorf(codes.Unimplemented, "method WatchTransfers not implemented")
+}
+func (UnimplementedPricefeedServer) WatchPrices(*WatchPricesRequest, Pricefeed_WatchPricesServer) error {
+	return status.Errorf(codes.Unimplemented, "method WatchPrices not implemented")
+}
+func (UnimplementedPricefeedServer) mustEmbedUnimplementedPricefeedServer() {}
+
+// UnsafePricefeedServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to PricefeedServer will
+// result in compilation errors.
+type UnsafePricefeedServer interface {
+	mustEmbedUnimplementedPricefeedServer()
+}
+
+func RegisterPricefeedServer(s grpc.ServiceRegistrar, srv PricefeedServer) {
+	s.RegisterService(&Pricefeed_ServiceDesc, srv)
+}
+
+func _Pricefeed_GetPrices_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetPricesRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(PricefeedServer).GetPrices(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Pricefeed_GetPrices_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(PricefeedServer).GetPrices(ctx, req.(*GetPricesRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Pricefeed_GetPriceAt_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetPriceAtRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(PricefeedServer).GetPriceAt(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Pricefeed_GetPriceAt_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(PricefeedServer).GetPriceAt(ctx, req.(*GetPriceAtRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Pricefeed_ListTransfers_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListTransfersRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(PricefeedServer).ListTransfers(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Pricefeed_ListTransfers_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(PricefeedServer).ListTransfers(ctx, req.(*ListTransfersRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Pricefeed_WatchTransfers_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(WatchTransfersRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(PricefeedServer).WatchTransfers(m, &pricefeedWatchTransfersServer{stream})
+}
+
+type Pricefeed_WatchTransfersServer interface {
+	Send(*TransferEvent) error
+	grpc.ServerStream
+}
+
+type pricefeedWatchTransfersServer struct {
+	grpc.ServerStream
+}
+
+func (x *pricefeedWatchTransfersServer) Send(m *TransferEvent) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _Pricefeed_WatchPrices_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(WatchPricesRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(PricefeedServer).WatchPrices(m, &pricefeedWatchPricesServer{stream})
+}
+
+type Pricefeed_WatchPricesServer interface {
+	Send(*PriceEvent) error
+	grpc.ServerStream
+}
+
+type pricefeedWatchPricesServer struct {
+	grpc.ServerStream
+}
+
+func (x *pricefeedWatchPricesServer) Send(m *PriceEvent) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+// Pricefeed_ServiceDesc is the grpc.ServiceDesc for Pricefeed service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var Pricefeed_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "pricefeed.v1.Pricefeed",
+	HandlerType: (*PricefeedServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "GetPrices",
+			Handler:    _Pricefeed_GetPrices_Handler,
+		},
+		{
+			MethodName: "GetPriceAt",
+			Handler:    _Pricefeed_GetPriceAt_Handler,
+		},
+		{
+			MethodName: "ListTransfers",
+			Handler:    _Pricefeed_ListTransfers_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "WatchTransfers",
+			Handler:       _Pricefeed_WatchTransfers_Handler,
+			ServerStreams: true,
+		},
+		{
+			StreamName:    "WatchPrices",
+			Handler:       _Pricefeed_WatchPrices_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "pricefeed.proto",
+}
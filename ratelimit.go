@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	rpcRequestsCounter = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "xsyn_pricefeed_rpc_requests_total",
+		Help: "How many RPC requests were sent, partitioned by chain, endpoint, method and status (ok, rate_limited or error). A batch counts once.",
+	}, []string{"chain", "endpoint", "method", "status"})
+	rpcCallsCounter = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "xsyn_pricefeed_rpc_calls_total",
+		Help: "How many JSON-RPC calls were sent, counting every call of a batch, partitioned by chain, endpoint and method.",
+	}, []string{"chain", "endpoint", "method"})
+	rpcDurationHistogram = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "xsyn_pricefeed_rpc_request_seconds",
+		Help:    "Latency of RPC requests, partitioned by chain, method and status.",
+		Buckets: prometheus.ExponentialBuckets(0.01, 2, 12),
+	}, []string{"chain", "method", "status"})
+	rpcRetriesCounter = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "xsyn_pricefeed_rpc_retries_total",
+		Help: "How many RPC requests were retried after being rate limited, partitioned by chain, endpoint and method.",
+	}, []string{"chain", "endpoint", "method"})
+	rpcThrottledHistogram = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "xsyn_pricefeed_rpc_throttled_seconds",
+		Help:    "How long RPC requests waited for the rate limiter of their endpoint.",
+		Buckets: prometheus.ExponentialBuckets(0.01, 2, 12),
+	}, []string{"chain", "endpoint"})
+)
+
+func init() {
+	prometheus.MustRegister(rpcRequestsCounter, rpcCallsCounter, rpcDurationHistogram, rpcRetriesCounter, rpcThrottledHistogram)
+}
+
+const DefaultRPCRetries = 5
+const RateLimitMinBackoff = 500 * time.Millisecond
+const RateLimitMaxBackoff = 30 * time.Second
+
+// TokenBucket allows Rate calls per second on average, and up to Burst at once. A nil bucket or a Rate of 0 allows everything.
+type TokenBucket struct {
+	Rate  float64
+	Burst float64
+
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+func NewTokenBucket(rate float64, burst int) *TokenBucket {
+	if burst < 1 {
+		burst = 1
+	}
+	return &TokenBucket{Rate: rate, Burst: float64(burst), tokens: float64(burst), last: time.Now()}
+}
+
+// Wait blocks until n tokens are available and takes them. n is capped to Burst so large batches still get through.
+func (b *TokenBucket) Wait(ctx context.Context, n int) error {
+	if b == nil || b.Rate <= 0 {
+		return nil
+	}
+	need := float64(n)
+	if need > b.Burst {
+		need = b.Burst
+	}
+	for {
+		b.mu.Lock()
+		now := time.Now()
+		b.tokens += now.Sub(b.last).Seconds() * b.Rate
+		if b.tokens > b.Burst {
+			b.tokens = b.Burst
+		}
+		b.last = now
+		if b.tokens >= need {
+			b.tokens -= need
+			b.mu.Unlock()
+			return nil
+		}
+		wait := time.Duration((need - b.tokens) / b.Rate * float64(time.Second))
+		b.mu.Unlock()
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+var rateLimitErrors = []string{
+	"rate limit",
+	"too many requests",
+	"request rate",
+	"requests per second",
+	"compute units per second",
+	"capacity exceeded",
+}
+
+// IsRateLimitError reports whether the provider turned a request down for exceeding its rate limit:
+// an HTTP 429, or a JSON-RPC error such as Infura's -32005 that isn't about the size of a log query.
+func IsRateLimitError(err error) bool {
+	if err == nil || IsLogRangeError(err) {
+		return false
+	}
+	var httpErr rpc.HTTPError
+	if errors.As(err, &httpErr) && httpErr.StatusCode == 429 {
+		return true
+	}
+	var rpcErr rpc.Error
+	if errors.As(err, &rpcErr) && (rpcErr.ErrorCode() == -32005 || rpcErr.ErrorCode() == 429) {
+		return true
+	}
+	msg := strings.ToLower(err.Error())
+	for _, substr := range rateLimitErrors {
+		if strings.Contains(msg, substr) {
+			return true
+		}
+	}
+	return false
+}
+
+func rpcStatus(err error) string {
+	if err == nil {
+		return "ok"
+	}
+	if IsRateLimitError(err) {
+		return "rate_limited"
+	}
+	return "error"
+}
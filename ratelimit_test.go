@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+type testRPCError struct {
+	code int
+	msg  string
+}
+
+func (e *testRPCError) Error() string  { return e.msg }
+func (e *testRPCError) ErrorCode() int { return e.code }
+
+func TestIsRateLimitError(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"nil", nil, false},
+		{"plain error", errors.New("connection reset by peer"), false},
+		{"http 429", rpc.HTTPError{StatusCode: 429, Status: "429 Too Many Requests"}, true},
+		{"http 503", rpc.HTTPError{StatusCode: 503, Status: "503 Service Unavailable"}, false},
+		{"wrapped http 429", fmt.Errorf("get block: %w", rpc.HTTPError{StatusCode: 429, Status: "429"}), true},
+		{"infura -32005", &testRPCError{-32005, "daily request count exceeded, request rate limited"}, true},
+		{"json-rpc 429", &testRPCError{429, "slow down"}, true},
+		{"other json-rpc code", &testRPCError{-32000, "header not found"}, false},
+		{"rate limit message", errors.New("Your app has exceeded its compute units per second capacity"), true},
+		{"too many requests message", errors.New("Too Many Requests"), true},
+		{"-32005 log range", &testRPCError{-32005, "query returned more than 10000 results"}, false},
+		{"wrapped -32005 log range", fmt.Errorf("filter logs: %w", &testRPCError{-32005, "query returned more than 10000 results"}), false},
+		{"log response size", &testRPCError{-32005, "Log response size exceeded. You can make eth_getLogs requests with up to a 2K block range"}, false},
+		{"block range too large", errors.New("block range too large"), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := IsRateLimitError(tt.err)
+			if got != tt.want {
+				t.Errorf("IsRateLimitError(%v) = %t, want %t", tt.err, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTokenBucketWait(t *testing.T) {
+	tests := []struct {
+		name    string
+		bucket  *TokenBucket
+		calls   []int
+		minWait time.Duration
+		maxWait time.Duration
+	}{
+		{"nil bucket", nil, []int{100, 100}, 0, 50 * time.Millisecond},
+		{"no rate", NewTokenBucket(0, 1), []int{100, 100}, 0, 50 * time.Millisecond},
+		{"within burst", NewTokenBucket(10, 5), []int{1, 1, 1, 1, 1}, 0, 50 * time.Millisecond},
+		{"batch within burst", NewTokenBucket(10, 5), []int{5}, 0, 50 * time.Millisecond},
+		{"batch capped to burst", NewTokenBucket(10, 5), []int{50}, 0, 50 * time.Millisecond},
+		{"refill after burst", NewTokenBucket(20, 1), []int{1, 1, 1}, 90 * time.Millisecond, time.Second},
+		{"refill after capped batch", NewTokenBucket(20, 2), []int{50, 1}, 40 * time.Millisecond, time.Second},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			start := time.Now()
+			for _, n := range tt.calls {
+				err := tt.bucket.Wait(context.Background(), n)
+				if err != nil {
+					t.Fatalf("Wait(%d) = %v", n, err)
+				}
+			}
+			elapsed := time.Since(start)
+			if elapsed < tt.minWait || elapsed > tt.maxWait {
+				t.Errorf("waited %s, want between %s and %s", elapsed, tt.minWait, tt.maxWait)
+			}
+		})
+	}
+}
+
+func TestTokenBucketWaitCancelled(t *testing.T) {
+	bucket := NewTokenBucket(0.1, 1)
+	err := bucket.Wait(context.Background(), 1)
+	if err != nil {
+		t.Fatalf("Wait() = %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	err = bucket.Wait(ctx, 1)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Wait() = %v, want context.DeadlineExceeded", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("cancelled Wait() returned after %s", elapsed)
+	}
+}
@@ -0,0 +1,315 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"runtime/debug"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	scraperRunsCounter = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "xsyn_pricefeed_scraper_runs_total",
+		Help: "How many times each scraper ran, partitioned by outcome (ok or error).",
+	}, []string{"scraper", "status"})
+	scraperDurationHistogram = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "xsyn_pricefeed_scraper_run_seconds",
+		Help:    "How long scraper runs took.",
+		Buckets: prometheus.ExponentialBuckets(0.1, 2, 12),
+	}, []string{"scraper"})
+	scraperLagGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "xsyn_pricefeed_scraper_lag_blocks",
+		Help: "How many blocks the cursor of each scraper is behind the chain's block height.",
+	}, []string{"scraper"})
+)
+
+func init() {
+	prometheus.MustRegister(scraperRunsCounter, scraperDurationHistogram, scraperLagGauge)
+}
+
+// Scraper is a unit of periodic work run by the Scheduler: an asset of a chain, the block height of a chain or the prices.
+type Scraper struct {
+	Name   string
+	Chain  string
+	Symbol string
+	// Interval is the wait between successful runs.
+	Interval time.Duration
+	// Jitter is the most added at random to every wait, so scrapers on the same interval don't hit the node together.
+	Jitter time.Duration
+	// Timeout cancels the context of a run that takes longer, when positive.
+	Timeout time.Duration
+	Run     func(ctx context.Context) error
+	// Lag reports how many blocks the scraper is behind. Scrapers that don't follow blocks leave it nil.
+	Lag func() (int64, error)
+
+	trigger chan struct{}
+}
+
+type ScraperStatus struct {
+	Name                string     `json:"name"`
+	Chain               string     `json:"chain,omitempty"`
+	Symbol              string     `json:"symbol,omitempty"`
+	Running             bool       `json:"running"`
+	Paused              bool       `json:"paused"`
+	Runs                int64      `json:"runs"`
+	ConsecutiveFailures int        `json:"consecutive_failures"`
+	LastRun             *time.Time `json:"last_run"`
+	LastDuration        string     `json:"last_duration,omitempty"`
+	LastSuccess         *time.Time `json:"last_success"`
+	LastError           string     `json:"last_error,omitempty"`
+	LastErrorAt         *time.Time `json:"last_error_at"`
+	NextRun             *time.Time `json:"next_run"`
+	Lag                 *int64     `json:"lag"`
+}
+
+// Scheduler runs every scraper in its own loop. A scraper that fails, by error or panic,
+// is retried with exponential backoff between MinBackoff and MaxBackoff.
+// A scraper that is behind and made progress on its last run is run again straight away, which is how it catches up.
+type Scheduler struct {
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+	// Paused is asked before every run whether the scraper is paused, when set. A paused scraper waits its interval instead.
+	Paused func(name string) (bool, error)
+
+	mu       sync.Mutex
+	scrapers []*Scraper
+	status   map[string]*ScraperStatus
+}
+
+func NewScheduler(minBackoff time.Duration, maxBackoff time.Duration) *Scheduler {
+	return &Scheduler{
+		MinBackoff: minBackoff,
+		MaxBackoff: maxBackoff,
+		status:     map[string]*ScraperStatus{},
+	}
+}
+
+func (s *Scheduler) Add(scraper *Scraper) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	scraper.trigger = make(chan struct{}, 1)
+	s.scrapers = append(s.scrapers, scraper)
+	s.status[scraper.Name] = &ScraperStatus{Name: scraper.Name, Chain: scraper.Chain, Symbol: scraper.Symbol}
+}
+
+// Run runs the scrapers until ctx is cancelled and they have all returned.
+func (s *Scheduler) Run(ctx context.Context) {
+	s.mu.Lock()
+	scrapers := append([]*Scraper{}, s.scrapers...)
+	s.mu.Unlock()
+
+	log.Info().Int("scrapers", len(scrapers)).Msg("start scheduler")
+	wg := &sync.WaitGroup{}
+	for _, scraper := range scrapers {
+		wg.Add(1)
+		go func(scraper *Scraper) {
+			defer wg.Done()
+			s.loop(ctx, scraper)
+		}(scraper)
+	}
+	wg.Wait()
+	log.Info().Msg("scheduler stopped")
+}
+
+// Trigger runs the scrapers of a chain early, unless they are backing off after a failure.
+func (s *Scheduler) Trigger(chain string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, scraper := range s.scrapers {
+		if scraper.Chain != chain {
+			continue
+		}
+		select {
+		case scraper.trigger <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// Statuses returns a copy of the status of every scraper, in the order they were added.
+func (s *Scheduler) Statuses() []*ScraperStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	result := []*ScraperStatus{}
+	for _, scraper := range s.scrapers {
+		status := *s.status[scraper.Name]
+		result = append(result, &status)
+	}
+	return result
+}
+
+func (s *Scheduler) update(scraper *Scraper, fn func(status *ScraperStatus)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	fn(s.status[scraper.Name])
+}
+
+func (s *Scheduler) loop(ctx context.Context, scraper *Scraper) {
+	failures := 0
+	wait := time.Duration(0)
+	for {
+		nextRun := time.Now().Add(wait)
+		s.update(scraper, func(status *ScraperStatus) { status.NextRun = &nextRun })
+
+		trigger := scraper.trigger
+		if failures > 0 {
+			trigger = nil
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			s.update(scraper, func(status *ScraperStatus) { status.NextRun = nil })
+			return
+		case <-timer.C:
+		case <-trigger:
+			timer.Stop()
+		}
+
+		if s.paused(scraper) {
+			wait = scraper.Interval + jitter(scraper.Jitter)
+			continue
+		}
+		lagBefore, lagErr := s.lag(scraper)
+		err := s.run(ctx, scraper)
+		if ctx.Err() != nil {
+			continue
+		}
+		if err != nil {
+			failures++
+			wait = s.backoff(failures) + jitter(scraper.Jitter)
+			log.Err(err).Str("scraper", scraper.Name).Int("failures", failures).Str("retry_in", wait.Round(time.Millisecond).String()).Msg("scraper failed")
+			continue
+		}
+		failures = 0
+		wait = scraper.Interval + jitter(scraper.Jitter)
+		lagAfter, err := s.lag(scraper)
+		if lagErr == nil && err == nil && lagAfter > 0 && lagAfter < lagBefore {
+			wait = 0
+		}
+	}
+}
+
+// run runs the scraper once with its timeout, turning a panic into an error so the loop survives it.
+func (s *Scheduler) run(ctx context.Context, scraper *Scraper) (err error) {
+	if scraper.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, scraper.Timeout)
+		defer cancel()
+	}
+	start := time.Now()
+	s.update(scraper, func(status *ScraperStatus) {
+		status.Running = true
+		status.LastRun = &start
+	})
+	defer func() {
+		if r := recover(); r != nil {
+			log.Error().Str("scraper", scraper.Name).Str("stack", string(debug.Stack())).Msg("scraper panicked")
+			err = fmt.Errorf("panic: %v", r)
+		}
+		elapsed := time.Since(start)
+		scraperDurationHistogram.WithLabelValues(scraper.Name).Observe(elapsed.Seconds())
+		outcome := "ok"
+		if err != nil {
+			outcome = "error"
+		}
+		scraperRunsCounter.WithLabelValues(scraper.Name, outcome).Inc()
+		now := time.Now()
+		s.update(scraper, func(status *ScraperStatus) {
+			status.Running = false
+			status.Runs++
+			status.LastDuration = elapsed.Round(time.Millisecond).String()
+			if err != nil {
+				status.ConsecutiveFailures++
+				status.LastError = err.Error()
+				status.LastErrorAt = &now
+				return
+			}
+			status.ConsecutiveFailures = 0
+			status.LastSuccess = &now
+		})
+	}()
+	return scraper.Run(ctx)
+}
+
+// paused records whether the scraper is paused. A scraper whose state can't be read runs.
+func (s *Scheduler) paused(scraper *Scraper) bool {
+	if s.Paused == nil {
+		return false
+	}
+	paused, err := s.Paused(scraper.Name)
+	if err != nil {
+		log.Warn().Err(err).Str("scraper", scraper.Name).Msg("check scraper paused")
+		paused = false
+	}
+	s.update(scraper, func(status *ScraperStatus) { status.Paused = paused })
+	return paused
+}
+
+func (s *Scheduler) lag(scraper *Scraper) (int64, error) {
+	if scraper.Lag == nil {
+		return 0, nil
+	}
+	lag, err := scraper.Lag()
+	if err != nil {
+		return 0, fmt.Errorf("get lag: %w", err)
+	}
+	scraperLagGauge.WithLabelValues(scraper.Name).Set(float64(lag))
+	s.update(scraper, func(status *ScraperStatus) { status.Lag = &lag })
+	return lag, nil
+}
+
+func (s *Scheduler) backoff(failures int) time.Duration {
+	wait := s.MinBackoff
+	for i := 1; i < failures && wait < s.MaxBackoff; i++ {
+		wait *= 2
+	}
+	if wait > s.MaxBackoff {
+		wait = s.MaxBackoff
+	}
+	return wait
+}
+
+func jitter(max time.Duration) time.Duration {
+	if max <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(max)))
+}
+
+type StatusResponse struct {
+	Leader   *LeaderStatus    `json:"leader"`
+	Scrapers []*ScraperStatus `json:"scrapers"`
+	// Nodes are the RPC endpoints of every chain, and of the prices.
+	Nodes map[string][]*EndpointStatus `json:"nodes"`
+}
+
+// Status reports the leader state of this replica and the last run, last error and lag of every scraper.
+// Scrapers only run on the leader.
+func (c *Controller) Status(w http.ResponseWriter, r *http.Request) {
+	leader := c.Leader.Status()
+	current, err := Get(KeyLeader)
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	leader.Leader = current
+	nodes := map[string][]*EndpointStatus{"prices": c.Node.Status()}
+	for _, chain := range c.Chains.Chains {
+		nodes[chain.Name] = chain.Node.Status()
+	}
+	result := &StatusResponse{leader, c.Scheduler.Statuses(), nodes}
+	err = json.NewEncoder(w).Encode(result)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
@@ -0,0 +1,277 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/shopspring/decimal"
+)
+
+var ErrUnknownChain = errors.New("unknown chain")
+var ErrUnknownSymbol = errors.New("unknown symbol")
+var ErrNoPrice = errors.New("no price recorded")
+var ErrInvalidAddress = errors.New("invalid address")
+var ErrInvalidCursor = errors.New("invalid cursor")
+var ErrCursorMismatch = errors.New("cursor belongs to another order or other filters")
+
+// ErrNoPoolPrice is returned for the SUPS price at blocks before the pool was initialised.
+var ErrNoPoolPrice = errors.New("pool has no price")
+
+// DefaultSUPSUSDCents stands in for the SUPS price when the pool returns nothing.
+var DefaultSUPSUSDCents = decimal.NewFromFloat(0.8)
+
+// Service is what the REST and gRPC APIs share: both only translate requests and responses around it.
+type Service struct {
+	*EthClient
+	Chains *ChainRegistry
+	Stream *StreamBroker
+}
+
+// Prices reads the current prices from the chain.
+func (s *Service) Prices(ctx context.Context) (*PriceResponse, error) {
+	supsusd, err := s.SUPSUSD(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("get supsusd price: %w", err)
+	}
+	ethusd, err := s.ETHUSD(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("get ethusd price: %w", err)
+	}
+	bnbusd, err := s.BNBUSD(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("get bnbusd price: %w", err)
+	}
+	result := &PriceResponse{time.Now().Unix(), supsusd, ethusd, bnbusd}
+	if result.SUPSUSD.IsZero() {
+		result.SUPSUSD = DefaultSUPSUSDCents
+	}
+	return result, nil
+}
+
+// PriceAt returns the last prices recorded at or before at, or ErrNoPrice.
+func (s *Service) PriceAt(ctx context.Context, at time.Time) (*PriceResponse, error) {
+	result, err := PriceAt(ctx, at)
+	if err != nil {
+		return nil, err
+	}
+	if result == nil {
+		return nil, ErrNoPrice
+	}
+	return result, nil
+}
+
+const DefaultTransfersLimit = 100
+const MaxTransfersLimit = 1000
+
+// TransferQuery is a page request for the transfers of an asset. MinAmount is in whole tokens,
+// Limit defaults to DefaultTransfersLimit and is capped at MaxTransfersLimit, and Cursor is the
+// NextCursor of the previous page. See TransferFilter for the other fields.
+type TransferQuery struct {
+	SinceBlock int64
+	UntilBlock int64
+	From       *common.Address
+	To         *common.Address
+	Address    *common.Address
+	MinAmount  *decimal.Decimal
+	SinceTime  int64
+	UntilTime  int64
+	Ascending  bool
+	Limit      int
+	Cursor     string
+}
+
+// TransferPage is a page of transfers. NextCursor is empty on the last page.
+type TransferPage struct {
+	Transfers  []*TransferAPIResponse
+	NextCursor string
+}
+
+// EncodeTransferCursor makes an opaque cursor out of a position in the transfers filter selects.
+func EncodeTransferCursor(cursor *TransferCursor, filter *TransferFilter) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(fmt.Sprintf("%d.%d.%s", cursor.Block, cursor.LogIndex, filter.fingerprint())))
+}
+
+// DecodeTransferCursor reads a cursor made by EncodeTransferCursor. A cursor made for another order or
+// other filters fails with ErrCursorMismatch, as its position means nothing in the transfers filter selects.
+func DecodeTransferCursor(cursor string, filter *TransferFilter) (*TransferCursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	parts := strings.Split(string(raw), ".")
+	if len(parts) != 3 {
+		return nil, ErrInvalidCursor
+	}
+	result := &TransferCursor{}
+	result.Block, err = strconv.ParseUint(parts[0], 10, 64)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	index, err := strconv.ParseUint(parts[1], 10, 32)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	result.LogIndex = uint(index)
+	if parts[2] != filter.fingerprint() {
+		return nil, ErrCursorMismatch
+	}
+	return result, nil
+}
+
+// fingerprint is a short digest of the asset, filters and order of f, leaving out the position and page size.
+func (f *TransferFilter) fingerprint() string {
+	minAmount := ""
+	if f.MinAmount != nil {
+		minAmount = f.MinAmount.String()
+	}
+	key := fmt.Sprintf("%d|%s|%d|%d|%s|%s|%s|%s|%d|%d|%t",
+		f.ChainID, strings.ToUpper(f.Symbol), f.SinceBlock, f.UntilBlock,
+		strings.ToLower(f.From), strings.ToLower(f.To), strings.ToLower(f.Address), minAmount,
+		f.SinceTime, f.UntilTime, f.Ascending)
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:6])
+}
+
+// TransferFilter resolves a query against an asset.
+func (s *Service) TransferFilter(chainNameOrID string, symbol string, query *TransferQuery) (*Chain, *TransferFilter, error) {
+	chain, asset, err := s.Chains.LookupAsset(chainNameOrID, symbol)
+	if err != nil {
+		return nil, nil, err
+	}
+	filter := &TransferFilter{
+		ChainID:    chain.ID,
+		Symbol:     asset.Symbol,
+		SinceBlock: query.SinceBlock,
+		UntilBlock: query.UntilBlock,
+		SinceTime:  query.SinceTime,
+		UntilTime:  query.UntilTime,
+		Ascending:  query.Ascending,
+	}
+	if query.From != nil {
+		filter.From = query.From.Hex()
+	}
+	if query.To != nil {
+		filter.To = query.To.Hex()
+	}
+	if query.Address != nil {
+		filter.Address = query.Address.Hex()
+	}
+	if query.MinAmount != nil {
+		minAmount := query.MinAmount.Shift(int32(asset.Decimals))
+		filter.MinAmount = &minAmount
+	}
+	if query.Cursor != "" {
+		after, err := DecodeTransferCursor(query.Cursor, filter)
+		if err != nil {
+			return nil, nil, err
+		}
+		filter.After = after
+	}
+	return chain, filter, nil
+}
+
+// Transfers returns a page of the transfers of an asset.
+func (s *Service) Transfers(ctx context.Context, chainNameOrID string, symbol string, query *TransferQuery) (*TransferPage, error) {
+	chain, filter, err := s.TransferFilter(chainNameOrID, symbol, query)
+	if err != nil {
+		return nil, err
+	}
+	limit := query.Limit
+	if limit <= 0 {
+		limit = DefaultTransfersLimit
+	}
+	if limit > MaxTransfersLimit {
+		limit = MaxTransfersLimit
+	}
+	filter.Limit = limit + 1
+
+	blockheight, err := GetInt(KeyBlockHeight(chain.ID), int(chain.BaseBlock))
+	if err != nil {
+		return nil, err
+	}
+	records, err := TransferRecords(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+	result := &TransferPage{Transfers: []*TransferAPIResponse{}}
+	if len(records) > limit {
+		records = records[:limit]
+		last := records[limit-1]
+		result.NextCursor = EncodeTransferCursor(&TransferCursor{last.Block, last.LogIndex}, filter)
+	}
+	for _, record := range records {
+		result.Transfers = append(result.Transfers, record.Response(blockheight))
+	}
+	return result, nil
+}
+
+// ExportTransfers calls fn with every transfer matching query, in order, without loading them all.
+// Unlike Transfers, there is no page size unless query.Limit is set.
+func (s *Service) ExportTransfers(ctx context.Context, chainNameOrID string, symbol string, query *TransferQuery, fn func(*TransferAPIResponse) error) error {
+	chain, filter, err := s.TransferFilter(chainNameOrID, symbol, query)
+	if err != nil {
+		return err
+	}
+	filter.Limit = query.Limit
+	blockheight, err := GetInt(KeyBlockHeight(chain.ID), int(chain.BaseBlock))
+	if err != nil {
+		return err
+	}
+	return StreamTransferRecords(ctx, filter, func(record *TransferRecord) error {
+		return fn(record.Response(blockheight))
+	})
+}
+
+// WatchTransfers calls send with the transfers on chains, from or to addresses, as they are indexed,
+// after replaying the logged ones after lastID. It returns when ctx is done or send fails.
+func (s *Service) WatchTransfers(ctx context.Context, chainNamesOrIDs []string, addresses []string, lastID int64, send func(id int64, transfer *TransferAPIResponse) error) error {
+	filter := StreamFilter{Transfers: true}
+	for _, name := range chainNamesOrIDs {
+		chain, ok := s.Chains.Lookup(name)
+		if !ok {
+			return ErrUnknownChain
+		}
+		filter.Chains = append(filter.Chains, chain.ID)
+	}
+	for _, addr := range addresses {
+		if !common.IsHexAddress(addr) {
+			return fmt.Errorf("%w %q", ErrInvalidAddress, addr)
+		}
+		filter.Addresses = append(filter.Addresses, common.HexToAddress(addr))
+	}
+	return s.watch(ctx, filter, lastID, func(event *StreamEvent) error {
+		transfer := &TransferAPIResponse{}
+		err := json.Unmarshal(event.Payload, transfer)
+		if err != nil {
+			return fmt.Errorf("decode transfer event: %w", err)
+		}
+		return send(event.ID, transfer)
+	})
+}
+
+// WatchPrices calls send with the prices as they are recorded, after replaying the logged ones after lastID.
+func (s *Service) WatchPrices(ctx context.Context, lastID int64, send func(id int64, price *PriceResponse) error) error {
+	return s.watch(ctx, StreamFilter{Prices: true}, lastID, func(event *StreamEvent) error {
+		price := &PriceResponse{}
+		err := json.Unmarshal(event.Payload, price)
+		if err != nil {
+			return fmt.Errorf("decode price event: %w", err)
+		}
+		return send(event.ID, price)
+	})
+}
+
+func (s *Service) watch(ctx context.Context, filter StreamFilter, lastID int64, send func(*StreamEvent) error) error {
+	sub := s.Stream.Subscribe(filter)
+	defer s.Stream.Unsubscribe(sub)
+	return s.Stream.Follow(ctx, sub, lastID, send, func() error { return nil })
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"encoding/base64"
+	"errors"
+	"testing"
+
+	"github.com/shopspring/decimal"
+)
+
+func TestTransferCursorRoundTrip(t *testing.T) {
+	filter := &TransferFilter{ChainID: 1, Symbol: "SUPS", SinceBlock: 100}
+	tests := []*TransferCursor{
+		{0, 0},
+		{15537393, 12},
+		{^uint64(0), 4095},
+	}
+	for _, tt := range tests {
+		encoded := EncodeTransferCursor(tt, filter)
+		got, err := DecodeTransferCursor(encoded, filter)
+		if err != nil {
+			t.Fatalf("DecodeTransferCursor(%s) = %v", encoded, err)
+		}
+		if *got != *tt {
+			t.Errorf("DecodeTransferCursor(EncodeTransferCursor(%v)) = %v", *tt, *got)
+		}
+	}
+}
+
+func TestTransferCursorSamePageAfterPosition(t *testing.T) {
+	filter := &TransferFilter{ChainID: 1, Symbol: "SUPS", From: "0xAbC"}
+	next := &TransferFilter{ChainID: 1, Symbol: "sups", From: "0xabc", After: &TransferCursor{10, 1}, Limit: 50}
+	_, err := DecodeTransferCursor(EncodeTransferCursor(&TransferCursor{10, 1}, filter), next)
+	if err != nil {
+		t.Errorf("DecodeTransferCursor() = %v, want the cursor to ignore position, page size and case", err)
+	}
+}
+
+func TestDecodeTransferCursorMismatch(t *testing.T) {
+	minAmount := decimal.NewFromInt(5)
+	filter := &TransferFilter{ChainID: 1, Symbol: "SUPS", SinceBlock: 100}
+	cursor := EncodeTransferCursor(&TransferCursor{200, 3}, filter)
+	tests := []struct {
+		name   string
+		filter *TransferFilter
+	}{
+		{"other order", &TransferFilter{ChainID: 1, Symbol: "SUPS", SinceBlock: 100, Ascending: true}},
+		{"other asset", &TransferFilter{ChainID: 1, Symbol: "ETH", SinceBlock: 100}},
+		{"other chain", &TransferFilter{ChainID: 5, Symbol: "SUPS", SinceBlock: 100}},
+		{"other since_block", &TransferFilter{ChainID: 1, Symbol: "SUPS", SinceBlock: 150}},
+		{"added address", &TransferFilter{ChainID: 1, Symbol: "SUPS", SinceBlock: 100, Address: "0xabc"}},
+		{"added min_amount", &TransferFilter{ChainID: 1, Symbol: "SUPS", SinceBlock: 100, MinAmount: &minAmount}},
+		{"added until_time", &TransferFilter{ChainID: 1, Symbol: "SUPS", SinceBlock: 100, UntilTime: 1800000000}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := DecodeTransferCursor(cursor, tt.filter)
+			if !errors.Is(err, ErrCursorMismatch) {
+				t.Errorf("DecodeTransferCursor() = %v, %v, want ErrCursorMismatch", got, err)
+			}
+		})
+	}
+}
+
+func TestDecodeTransferCursorMalformed(t *testing.T) {
+	filter := &TransferFilter{ChainID: 1, Symbol: "SUPS"}
+	fingerprint := filter.fingerprint()
+	raw := func(s string) string { return base64.RawURLEncoding.EncodeToString([]byte(s)) }
+	tests := []struct {
+		name   string
+		cursor string
+	}{
+		{"empty", ""},
+		{"not base64", "!!!"},
+		{"padded base64", base64.URLEncoding.EncodeToString([]byte("12.3." + fingerprint))},
+		{"no separator", raw("12")},
+		{"no fingerprint", raw("12.3")},
+		{"missing log index", raw("12.." + fingerprint)},
+		{"missing block", raw(".3." + fingerprint)},
+		{"negative block", raw("-1.3." + fingerprint)},
+		{"letters", raw("a.b." + fingerprint)},
+		{"trailing garbage", raw("12.3x." + fingerprint)},
+		{"extra field", raw("12.3.4." + fingerprint)},
+		{"block overflow", raw("18446744073709551616.0." + fingerprint)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := DecodeTransferCursor(tt.cursor, filter)
+			if !errors.Is(err, ErrInvalidCursor) {
+				t.Errorf("DecodeTransferCursor(%q) = %v, %v, want ErrInvalidCursor", tt.cursor, got, err)
+			}
+		})
+	}
+}
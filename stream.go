@@ -0,0 +1,425 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/gorilla/websocket"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	streamClientsGauge = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "xsyn_pricefeed_stream_clients",
+		Help: "How many clients are connected to /api/stream over SSE or WebSocket.",
+	})
+	streamDroppedCounter = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "xsyn_pricefeed_stream_dropped_total",
+		Help: "How many stream clients were disconnected for falling too far behind.",
+	})
+)
+
+func init() {
+	prometheus.MustRegister(streamClientsGauge, streamDroppedCounter)
+}
+
+// ErrStreamDropped ends the stream of a client that fell too far behind.
+var ErrStreamDropped = errors.New("client too slow")
+
+const StreamEventPrice = "price"
+const StreamEventTransfer = "transfer"
+
+const DefaultStreamBufferSize = 256
+const StreamHeartbeat = 15 * time.Second
+const StreamReplayBatchSize = 1000
+
+// StreamEvent is a row of the stream_events log. IDs increase, so clients resume after the last ID they saw.
+type StreamEvent struct {
+	ID          int64
+	Kind        string
+	ChainID     *int64
+	FromAddress *string
+	ToAddress   *string
+	Payload     []byte
+	CreatedAt   time.Time
+}
+
+// StreamFilter picks the events a client wants. Empty Chains or Addresses match every transfer,
+// and Addresses match either side of one.
+type StreamFilter struct {
+	Prices    bool
+	Transfers bool
+	Chains    []int64
+	Addresses []common.Address
+}
+
+func (f *StreamFilter) Matches(event *StreamEvent) bool {
+	switch event.Kind {
+	case StreamEventPrice:
+		return f.Prices
+	case StreamEventTransfer:
+		if !f.Transfers {
+			return false
+		}
+	default:
+		return false
+	}
+	if len(f.Chains) > 0 {
+		found := false
+		for _, chainID := range f.Chains {
+			if event.ChainID != nil && *event.ChainID == chainID {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if len(f.Addresses) == 0 {
+		return true
+	}
+	for _, addr := range f.Addresses {
+		if (event.FromAddress != nil && *event.FromAddress == addr.Hex()) || (event.ToAddress != nil && *event.ToAddress == addr.Hex()) {
+			return true
+		}
+	}
+	return false
+}
+
+// StreamMessage is what clients receive for an event.
+type StreamMessage struct {
+	ID    int64           `json:"id"`
+	Event string          `json:"event"`
+	Data  json.RawMessage `json:"data"`
+}
+
+func (e *StreamEvent) Message() *StreamMessage {
+	return &StreamMessage{e.ID, e.Kind, e.Payload}
+}
+
+// StreamSubscription receives the live events matching Filter on C. When the client can't keep up
+// and C fills, the subscription is dropped and Dropped is closed; the client reconnects and resumes.
+type StreamSubscription struct {
+	Filter  StreamFilter
+	C       chan *StreamEvent
+	Dropped chan struct{}
+}
+
+// StreamBroker tails the stream_events log and fans events out to the subscriptions of this replica.
+// Events are written by the leader, so every replica polls the log rather than relying on memory.
+type StreamBroker struct {
+	PollInterval time.Duration
+	BufferSize   int
+
+	mu   sync.Mutex
+	subs map[*StreamSubscription]struct{}
+}
+
+func NewStreamBroker(pollInterval time.Duration) *StreamBroker {
+	return &StreamBroker{
+		PollInterval: pollInterval,
+		BufferSize:   DefaultStreamBufferSize,
+		subs:         map[*StreamSubscription]struct{}{},
+	}
+}
+
+// Run polls for new events until ctx is done.
+func (b *StreamBroker) Run(ctx context.Context) {
+	last, err := LatestStreamEventID(ctx)
+	for err != nil {
+		log.Warn().Err(err).Msg("get latest stream event")
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(b.PollInterval):
+		}
+		last, err = LatestStreamEventID(ctx)
+	}
+
+	ticker := time.NewTicker(b.PollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		for {
+			events, err := StreamEventsAfter(ctx, last, nil, StreamReplayBatchSize)
+			if err != nil {
+				log.Warn().Err(err).Msg("poll stream events")
+				break
+			}
+			for _, event := range events {
+				b.publish(event)
+				last = event.ID
+			}
+			if len(events) < StreamReplayBatchSize {
+				break
+			}
+		}
+	}
+}
+
+func (b *StreamBroker) Subscribe(filter StreamFilter) *StreamSubscription {
+	sub := &StreamSubscription{
+		Filter:  filter,
+		C:       make(chan *StreamEvent, b.BufferSize),
+		Dropped: make(chan struct{}),
+	}
+	b.mu.Lock()
+	b.subs[sub] = struct{}{}
+	b.mu.Unlock()
+	streamClientsGauge.Inc()
+	return sub
+}
+
+func (b *StreamBroker) Unsubscribe(sub *StreamSubscription) {
+	b.mu.Lock()
+	_, ok := b.subs[sub]
+	delete(b.subs, sub)
+	b.mu.Unlock()
+	if ok {
+		streamClientsGauge.Dec()
+	}
+}
+
+// publish never blocks on a client: one whose buffer is full is dropped.
+func (b *StreamBroker) publish(event *StreamEvent) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for sub := range b.subs {
+		if !sub.Filter.Matches(event) {
+			continue
+		}
+		select {
+		case sub.C <- event:
+		default:
+			delete(b.subs, sub)
+			close(sub.Dropped)
+			streamClientsGauge.Dec()
+			streamDroppedCounter.Inc()
+		}
+	}
+}
+
+// Follow replays the logged events after lastID matching the subscription, then its live events,
+// calling send for each in order and skipping any seen twice. It returns when ctx is done,
+// send fails or the subscription is dropped. heartbeat is called when nothing was sent for a while.
+func (b *StreamBroker) Follow(ctx context.Context, sub *StreamSubscription, lastID int64, send func(*StreamEvent) error, heartbeat func() error) error {
+	if lastID > 0 {
+		for {
+			events, err := StreamEventsAfter(ctx, lastID, &sub.Filter, StreamReplayBatchSize)
+			if err != nil {
+				return err
+			}
+			for _, event := range events {
+				err = send(event)
+				if err != nil {
+					return err
+				}
+				lastID = event.ID
+			}
+			if len(events) < StreamReplayBatchSize {
+				break
+			}
+		}
+	}
+
+	ticker := time.NewTicker(StreamHeartbeat)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-sub.Dropped:
+			return ErrStreamDropped
+		case <-ticker.C:
+			err := heartbeat()
+			if err != nil {
+				return err
+			}
+		case event := <-sub.C:
+			if event.ID <= lastID {
+				continue
+			}
+			err := send(event)
+			if err != nil {
+				return err
+			}
+			lastID = event.ID
+		}
+	}
+}
+
+// transferStreamEvent is the log row of a freshly indexed transfer.
+func transferStreamEvent(transfer *Transfer) (*StreamEvent, error) {
+	payload, err := json.Marshal(transferPayload(transfer))
+	if err != nil {
+		return nil, err
+	}
+	from := transfer.FromAddress.Hex()
+	to := transfer.ToAddress.Hex()
+	return &StreamEvent{
+		Kind:        StreamEventTransfer,
+		ChainID:     &transfer.ChainID,
+		FromAddress: &from,
+		ToAddress:   &to,
+		Payload:     payload,
+	}, nil
+}
+
+// streamRequest reads the filter and resume point of /api/stream: ?topics=prices,transfers (both by default),
+// ?chains= and ?addresses= as comma separated lists, and the Last-Event-ID header or ?last_event_id=.
+func (c *Controller) streamRequest(r *http.Request) (StreamFilter, int64, error) {
+	query := r.URL.Query()
+	filter := StreamFilter{Prices: true, Transfers: true}
+	if topics := query.Get("topics"); topics != "" {
+		filter.Prices = false
+		filter.Transfers = false
+		for _, topic := range strings.Split(topics, ",") {
+			switch strings.TrimSpace(topic) {
+			case "prices":
+				filter.Prices = true
+			case "transfers":
+				filter.Transfers = true
+			default:
+				return filter, 0, fmt.Errorf("unknown topic %q", topic)
+			}
+		}
+	}
+	if chains := query.Get("chains"); chains != "" {
+		for _, name := range strings.Split(chains, ",") {
+			chain, ok := c.Chains.Lookup(strings.TrimSpace(name))
+			if !ok {
+				return filter, 0, fmt.Errorf("unknown chain %q", name)
+			}
+			filter.Chains = append(filter.Chains, chain.ID)
+		}
+	}
+	if addresses := query.Get("addresses"); addresses != "" {
+		for _, addr := range strings.Split(addresses, ",") {
+			addr = strings.TrimSpace(addr)
+			if !common.IsHexAddress(addr) {
+				return filter, 0, fmt.Errorf("invalid address %q", addr)
+			}
+			filter.Addresses = append(filter.Addresses, common.HexToAddress(addr))
+		}
+	}
+	lastIDStr := r.Header.Get("Last-Event-ID")
+	if lastIDStr == "" {
+		lastIDStr = query.Get("last_event_id")
+	}
+	lastID := int64(0)
+	if lastIDStr != "" {
+		var err error
+		lastID, err = strconv.ParseInt(lastIDStr, 10, 64)
+		if err != nil || lastID < 0 {
+			return filter, 0, fmt.Errorf("invalid last event id")
+		}
+	}
+	return filter, lastID, nil
+}
+
+// StreamSSE sends prices and new transfers as server-sent events, with the event ID to resume from.
+func (c *Controller) StreamSSE(w http.ResponseWriter, r *http.Request) {
+	filter, lastID, err := c.streamRequest(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	sub := c.Stream.Subscribe(filter)
+	defer c.Stream.Unsubscribe(sub)
+	err = c.Stream.Follow(r.Context(), sub, lastID, func(event *StreamEvent) error {
+		_, err := fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", event.ID, event.Kind, event.Payload)
+		if err != nil {
+			return err
+		}
+		flusher.Flush()
+		return nil
+	}, func() error {
+		_, err := fmt.Fprint(w, ": heartbeat\n\n")
+		if err != nil {
+			return err
+		}
+		flusher.Flush()
+		return nil
+	})
+	if err != nil {
+		log.Debug().Err(err).Msg("sse stream closed")
+	}
+}
+
+var upgrader = websocket.Upgrader{
+	ReadBufferSize:  1024,
+	WriteBufferSize: 1024,
+	CheckOrigin:     func(r *http.Request) bool { return true },
+}
+
+const StreamWriteTimeout = 10 * time.Second
+
+// StreamWebSocket sends the /api/stream events as JSON messages over a WebSocket, pinging the client
+// instead of sending heartbeats. The connection is closed when the client stops answering.
+func (c *Controller) StreamWebSocket(w http.ResponseWriter, r *http.Request) {
+	filter, lastID, err := c.streamRequest(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	ws, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+	defer ws.Close()
+
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+	ws.SetReadLimit(1024)
+	ws.SetReadDeadline(time.Now().Add(2 * StreamHeartbeat))
+	ws.SetPongHandler(func(string) error {
+		return ws.SetReadDeadline(time.Now().Add(2 * StreamHeartbeat))
+	})
+	go func() {
+		defer cancel()
+		for {
+			_, _, err := ws.ReadMessage()
+			if err != nil {
+				return
+			}
+		}
+	}()
+
+	sub := c.Stream.Subscribe(filter)
+	defer c.Stream.Unsubscribe(sub)
+	err = c.Stream.Follow(ctx, sub, lastID, func(event *StreamEvent) error {
+		ws.SetWriteDeadline(time.Now().Add(StreamWriteTimeout))
+		return ws.WriteJSON(event.Message())
+	}, func() error {
+		return ws.WriteControl(websocket.PingMessage, nil, time.Now().Add(StreamWriteTimeout))
+	})
+	if err != nil {
+		log.Debug().Err(err).Msg("websocket stream closed")
+		ws.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseTryAgainLater, err.Error()), time.Now().Add(StreamWriteTimeout))
+	}
+}
@@ -2,94 +2,41 @@ package main
 
 import (
 	"context"
-
-	"github.com/ethereum/go-ethereum/core/types"
-	"github.com/ethereum/go-ethereum/ethclient"
+	"time"
 )
 
+// Subscriber records the block height of every chain as new heads arrive and runs its scrapers early.
 type Subscriber struct {
-	Mainnet *ethclient.Client
-	Goerli  *ethclient.Client
-	Tickers *Tickers
+	Chains       *ChainRegistry
+	Scheduler    *Scheduler
+	PollInterval time.Duration
 }
 
-func (s *Subscriber) Start() {
+// Start follows the heads of every chain until ctx is cancelled.
+func (s *Subscriber) Start(ctx context.Context) {
 	log.Info().Msg("start subscribers")
-	go func() {
-		ctx := context.Background()
-		log.Info().Str("chain", "mainnet").Msg("start header listener")
-		mainnetHead := make(chan *types.Header)
-		headSub, err := s.Mainnet.SubscribeNewHead(ctx, mainnetHead)
-		if err != nil {
-			log.Err(err).Msg("subscribe head")
-			return
-		}
-		defer headSub.Unsubscribe()
-		for {
-			select {
-			case err := <-headSub.Err():
-				log.Err(err).Msg("receive head")
-				continue
-			case head := <-mainnetHead:
-				log.Info().Int64("number", head.Number.Int64()).Msg("receive mainnet head")
-				err = SetInt(KeyBlockHeightMainnet, int(head.Number.Int64()))
-				if err != nil {
-					log.Err(err).Msg("set head")
-					continue
-				}
-
-				if s.Tickers.ScrapeMainnetSUPS {
-					err = s.Tickers.TickGoerliSUPS()
-					if err != nil {
-						log.Err(err).Msg("tick goerli SUPS")
-					}
-				}
+	for _, chain := range s.Chains.Chains {
+		tracker := NewHeadTracker(chain, s.PollInterval)
+		heads := tracker.Subscribe()
+		go tracker.Run(ctx)
+		go s.StartChain(ctx, chain, heads)
+	}
+}
 
-				if s.Tickers.ScrapeMainnetETH {
-					err = s.Tickers.TickGoerliETH()
-					if err != nil {
-						log.Err(err).Msg("tick goerli ETH")
-					}
-				}
-			}
-		}
-	}()
-	go func() {
-		ctx := context.Background()
-		log.Info().Str("chain", "goerli").Msg("start header listener")
-		testnetHead := make(chan *types.Header)
-		headSub, err := s.Goerli.SubscribeNewHead(ctx, testnetHead)
-		if err != nil {
-			log.Err(err).Msg("subscribe head")
+func (s *Subscriber) StartChain(ctx context.Context, chain *Chain, heads <-chan *Head) {
+	log.Info().Str("chain", chain.Name).Msg("start header listener")
+	for {
+		select {
+		case <-ctx.Done():
 			return
-		}
-		defer headSub.Unsubscribe()
-		for {
-			select {
-			case err := <-headSub.Err():
-				log.Err(err).Msg("receive head")
+		case head := <-heads:
+			log.Info().Str("chain", chain.Name).Uint64("number", head.Number).Str("source", head.Source).Msg("receive head")
+			err := SetInt(KeyBlockHeight(chain.ID), int(head.Number))
+			if err != nil {
+				log.Err(err).Msg("set head")
 				continue
-			case head := <-testnetHead:
-				log.Info().Int64("number", head.Number.Int64()).Msg("receive goerli head")
-				err = SetInt(KeyBlockHeightGoerli, int(head.Number.Int64()))
-				if err != nil {
-					log.Err(err).Msg("set head")
-					continue
-				}
-				if s.Tickers.ScrapeGoerliSUPS {
-					err = s.Tickers.TickGoerliSUPS()
-					if err != nil {
-						log.Err(err).Msg("tick goerli SUPS")
-					}
-				}
-
-				if s.Tickers.ScrapeGoerliETH {
-					err = s.Tickers.TickGoerliETH()
-					if err != nil {
-						log.Err(err).Msg("tick goerli ETH")
-					}
-				}
 			}
+			s.Scheduler.Trigger(chain.Name)
 		}
-	}()
+	}
 }
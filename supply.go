@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"xsyn-pricefeed/erc20"
+
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/go-chi/chi/v5"
+	"github.com/shopspring/decimal"
+)
+
+type ExcludedBalance struct {
+	Address string          `json:"address"`
+	Balance decimal.Decimal `json:"balance"`
+}
+
+// Supply of a token, read from the contract at Block so the figures add up.
+// Amounts are in whole tokens and the USD figures are nil when the token has no price.
+type Supply struct {
+	Chain         int64              `json:"chain"`
+	Symbol        string             `json:"symbol"`
+	Contract      string             `json:"contract"`
+	Block         uint64             `json:"block"`
+	Total         decimal.Decimal    `json:"total"`
+	Excluded      []*ExcludedBalance `json:"excluded"`
+	ExcludedTotal decimal.Decimal    `json:"excluded_total"`
+	Circulating   decimal.Decimal    `json:"circulating"`
+	PriceUSD      *decimal.Decimal   `json:"price_usd"`
+	MarketCapUSD  *decimal.Decimal   `json:"market_cap_usd"`
+	FDVUSD        *decimal.Decimal   `json:"fdv_usd"`
+}
+
+// TokenSupply reads the total supply of an asset and the balances of its excluded addresses.
+func TokenSupply(ctx context.Context, chain *Chain, asset *Asset) (*Supply, error) {
+	caller, err := erc20.NewErc20Caller(asset.ContractAddress(), chain.Node)
+	if err != nil {
+		return nil, fmt.Errorf("erc20 caller: %w", err)
+	}
+	block, err := chain.Node.BlockNumber(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("block number: %w", err)
+	}
+	opts := &bind.CallOpts{Context: ctx, BlockNumber: new(big.Int).SetUint64(block)}
+
+	total, err := caller.TotalSupply(opts)
+	if err != nil {
+		return nil, fmt.Errorf("total supply: %w", err)
+	}
+	exp := -int32(asset.Decimals)
+	result := &Supply{
+		Chain:         chain.ID,
+		Symbol:        asset.Symbol,
+		Contract:      asset.ContractAddress().Hex(),
+		Block:         block,
+		Total:         decimal.NewFromBigInt(total, exp),
+		Excluded:      []*ExcludedBalance{},
+		ExcludedTotal: decimal.Zero,
+	}
+	for _, addr := range asset.SupplyExcludedAddresses() {
+		balance, err := caller.BalanceOf(opts, addr)
+		if err != nil {
+			return nil, fmt.Errorf("balance of %s: %w", addr.Hex(), err)
+		}
+		amount := decimal.NewFromBigInt(balance, exp)
+		result.Excluded = append(result.Excluded, &ExcludedBalance{addr.Hex(), amount})
+		result.ExcludedTotal = result.ExcludedTotal.Add(amount)
+	}
+	result.Circulating = result.Total.Sub(result.ExcludedTotal)
+	return result, nil
+}
+
+// SetPrice fills in the market cap (circulating supply) and fully diluted valuation (total supply) at priceUSD.
+func (s *Supply) SetPrice(priceUSD decimal.Decimal) {
+	marketCap := s.Circulating.Mul(priceUSD).Round(2)
+	fdv := s.Total.Mul(priceUSD).Round(2)
+	s.PriceUSD = &priceUSD
+	s.MarketCapUSD = &marketCap
+	s.FDVUSD = &fdv
+}
+
+// supply reads the supply of the token in the symbol URL param, on the chain in ?chain= or the first chain indexing it.
+func (c *Controller) supply(w http.ResponseWriter, r *http.Request) (*Supply, bool) {
+	symbol := chi.URLParam(r, "symbol")
+	var chain *Chain
+	var asset *Asset
+	ok := false
+	if chainParam := r.URL.Query().Get("chain"); chainParam != "" {
+		chain, ok = c.Chains.Lookup(chainParam)
+		if ok {
+			asset, ok = chain.Asset(symbol)
+			ok = ok && !asset.Native()
+		}
+	} else {
+		chain, asset, ok = c.Chains.Token(symbol)
+	}
+	if !ok {
+		http.Error(w, "unknown token", http.StatusNotFound)
+		return nil, false
+	}
+
+	result, err := TokenSupply(r.Context(), chain, asset)
+	if err != nil {
+		log.Err(err).Str("symbol", asset.Symbol).Msg("get supply")
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return nil, false
+	}
+	price, ok, err := c.USDPrice(r.Context(), chain.ID, asset.Symbol)
+	if err != nil {
+		log.Err(err).Str("symbol", asset.Symbol).Msg("get price")
+	} else if ok {
+		result.SetPrice(price)
+	}
+	return result, true
+}
+
+// Supply returns the total, excluded and circulating supply of a token, with its market cap and FDV when it has a price.
+func (c *Controller) Supply(w http.ResponseWriter, r *http.Request) {
+	result, ok := c.supply(w, r)
+	if !ok {
+		return
+	}
+	err := json.NewEncoder(w).Encode(result)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+// CirculatingSupply returns the circulating supply as a plain number, the format aggregators expect.
+func (c *Controller) CirculatingSupply(w http.ResponseWriter, r *http.Request) {
+	result, ok := c.supply(w, r)
+	if !ok {
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Write([]byte(result.Circulating.String()))
+}
+
+func (c *Controller) TotalSupply(w http.ResponseWriter, r *http.Request) {
+	result, ok := c.supply(w, r)
+	if !ok {
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Write([]byte(result.Total.String()))
+}
@@ -3,332 +3,128 @@ package main
 import (
 	"context"
 	"fmt"
-	"sync"
+	"strings"
 	"time"
-
-	"github.com/ethereum/go-ethereum/common"
-	"github.com/ethereum/go-ethereum/ethclient"
 )
 
 type Tickers struct {
-	ScrapeMainnetETH  bool
-	ScrapeMainnetSUPS bool
-	ScrapeGoerliETH   bool
-	ScrapeGoerliSUPS  bool
-
 	*EthClient
-	Mainnet        *ethclient.Client
-	Goerli         *ethclient.Client
-	SUPSAddr       common.Address
-	GoerliSUPSAddr common.Address
+	Chains *ChainRegistry
 }
 
 const BaseMainnetBlock = 15879854
 const BaseGoerliBlock = 7859764
 
-func (t *Tickers) CatchUpMainnetETH() error {
-	iter := 0
-	for {
-		log.Info().Int("tick", iter).Str("chain", "mainnet").Str("symbol", "eth").Msg("fast forwarding...")
-		if !t.ScrapeMainnetETH {
-			break
-		}
-		blockHeightMainnet, err := GetInt(KeyBlockHeightMainnet, BaseMainnetBlock)
-		if err != nil {
-			return fmt.Errorf("get BlockHeight: %w", err)
-		}
-		lastBlockMainnetETH, err := GetInt(KeyLastBlockMainnetEth, BaseMainnetBlock)
-		if err != nil {
-			return fmt.Errorf("get LastBlock: %w", err)
-		}
-		if lastBlockMainnetETH == blockHeightMainnet {
-			break
-		}
-
-		err = t.TickMainnetEth()
-		if err != nil {
-			return fmt.Errorf("speedup tickblock: %w", err)
-		}
-		iter++
-	}
-	return nil
+// ScheduleOptions configure the scrapers of Tickers.
+type ScheduleOptions struct {
+	// AssetInterval is the wait between scrapes of an asset and between block height refreshes of a chain.
+	AssetInterval time.Duration
+	PriceInterval time.Duration
+	Jitter        time.Duration
+	Timeout       time.Duration
 }
-func (t *Tickers) CatchUpGoerliETH() error {
-	iter := 0
-	for {
-		if !t.ScrapeGoerliETH {
-			break
-		}
-		log.Info().Int("tick", iter).Str("chain", "goerli").Str("symbol", "eth").Msg("fast forwarding...")
-		blockHeightGoerli, err := GetInt(KeyBlockHeightGoerli, BaseGoerliBlock)
-		if err != nil {
-			return fmt.Errorf("get BlockHeight: %w", err)
-		}
-		lastBlockGoerliETH, err := GetInt(KeyLastBlockGoerliEth, BaseGoerliBlock)
-		if err != nil {
-			return fmt.Errorf("get LastBlock: %w", err)
-		}
-		if lastBlockGoerliETH == blockHeightGoerli {
-			break
-		}
 
-		err = t.TickGoerliETH()
-		if err != nil {
-			return fmt.Errorf("speedup tickblock: %w", err)
+// Scrapers returns the jobs to schedule: the block height of every chain, every asset of every chain and the prices.
+func (t *Tickers) Scrapers(opts ScheduleOptions) []*Scraper {
+	result := []*Scraper{}
+	for _, chain := range t.Chains.Chains {
+		chain := chain
+		result = append(result, &Scraper{
+			Name:     fmt.Sprintf("%s_block_height", chain.Name),
+			Chain:    chain.Name,
+			Interval: opts.AssetInterval,
+			Jitter:   opts.Jitter,
+			Timeout:  opts.Timeout,
+			Run: func(ctx context.Context) error {
+				return t.TickBlockHeight(ctx, chain)
+			},
+		})
+		for _, asset := range chain.Assets {
+			asset := asset
+			result = append(result, &Scraper{
+				Name:     fmt.Sprintf("%s_%s", chain.Name, strings.ToLower(asset.Symbol)),
+				Chain:    chain.Name,
+				Symbol:   asset.Symbol,
+				Interval: opts.AssetInterval,
+				Jitter:   opts.Jitter,
+				Timeout:  opts.Timeout,
+				Run: func(ctx context.Context) error {
+					return t.TickAsset(ctx, chain, asset)
+				},
+				Lag: func() (int64, error) {
+					return AssetLag(chain, asset)
+				},
+			})
 		}
-		iter++
 	}
-	return nil
+	result = append(result, &Scraper{
+		Name:     "prices",
+		Interval: opts.PriceInterval,
+		Jitter:   opts.Jitter,
+		Timeout:  opts.Timeout,
+		Run:      t.TickPrice,
+	})
+	return result
 }
-func (t *Tickers) CatchUpGoerliSUPS() error {
-	iter := 0
-	for {
-		if !t.ScrapeGoerliSUPS {
-			break
-		}
-		log.Info().Int("tick", iter).Str("chain", "goerli").Str("symbol", "sups").Msg("fast forwarding...")
-		blockHeightGoerli, err := GetInt(KeyBlockHeightGoerli, BaseGoerliBlock)
-		if err != nil {
-			return fmt.Errorf("get BlockHeight: %w", err)
-		}
-		lastBlockGoerliSUPS, err := GetInt(KeyLastBlockGoerliSups, BaseGoerliBlock)
-		if err != nil {
-			return fmt.Errorf("get LastBlock: %w", err)
-		}
-		if lastBlockGoerliSUPS == blockHeightGoerli {
-			break
-		}
-
-		err = t.TickGoerliSUPS()
-		if err != nil {
-			return fmt.Errorf("speedup tickblock: %w", err)
-		}
-		iter++
-	}
-	return nil
-}
-func (t *Tickers) CatchUpMainnetSUPS() error {
-	iter := 0
-	for {
-		if !t.ScrapeMainnetSUPS {
-			break
-		}
-		log.Info().Int("tick", iter).Str("chain", "mainnet").Str("symbol", "sups").Msg("fast forwarding...")
-		blockHeightMainnet, err := GetInt(KeyBlockHeightMainnet, BaseMainnetBlock)
-		if err != nil {
-			return fmt.Errorf("get BlockHeight: %w", err)
-		}
-		lastBlockMainnetSUPS, err := GetInt(KeyLastBlockMainnetSups, BaseMainnetBlock)
-		if err != nil {
-			return fmt.Errorf("get LastBlock: %w", err)
-		}
-		if lastBlockMainnetSUPS == blockHeightMainnet {
-			break
-		}
 
-		err = t.TickMainnetSUPS()
-		if err != nil {
-			return fmt.Errorf("speedup tickblock: %w", err)
-		}
-		iter++
-	}
-	return nil
-}
-func (t *Tickers) CatchUp() error {
-	messages := make(chan string)
-	wg := &sync.WaitGroup{}
-
-	wgResult := &sync.WaitGroup{}
-	wg.Add(1)
-	go func(wg *sync.WaitGroup) {
-		worker := "mainnet_eth"
-		log.Info().Str("worker", worker).Msg("start catchup worker")
-		defer wg.Done()
-		err := t.CatchUpMainnetETH()
-		if err != nil {
-			log.Err(err).Msg("catch up mainnet eth")
-		}
-		messages <- worker
-	}(wg)
-
-	wg.Add(1)
-	go func(wg *sync.WaitGroup) {
-		worker := "goerli_eth"
-		log.Info().Str("worker", worker).Msg("start catchup worker")
-		defer wg.Done()
-		err := t.CatchUpGoerliETH()
-		if err != nil {
-			log.Err(err).Msg("catch up goerli eth")
-		}
-		messages <- worker
-	}(wg)
-
-	wg.Add(1)
-	go func(wg *sync.WaitGroup) {
-		worker := "goerli_sups"
-		log.Info().Str("worker", worker).Msg("start catchup worker")
-		defer wg.Done()
-		err := t.CatchUpGoerliSUPS()
-		if err != nil {
-			log.Err(err).Msg("catch up goerli sups")
-		}
-		messages <- worker
-	}(wg)
-
-	wg.Add(1)
-	go func(wg *sync.WaitGroup) {
-		worker := "mainnet_sups"
-		log.Info().Str("worker", worker).Msg("start catchup worker")
-		defer wg.Done()
-		err := t.CatchUpMainnetSUPS()
-		if err != nil {
-			log.Err(err).Msg("catch up mainnet sups")
-		}
-		messages <- worker
-	}(wg)
-
-	wgResult.Add(1)
-	done := make(chan struct{})
-	go func() {
-		defer close(done)
-		for msg := range messages {
-			log.Info().Str("worker", msg).Msg("catchup worker finished")
-		}
-	}()
-	wg.Wait()
-	close(messages)
-	wgResult.Wait()
-	return nil
-}
-
-func (t *Tickers) Start() {
-	err := t.TickBlockHeightMainnet()
-	if err != nil {
-		log.Err(err).Msg("tick block height mainnet")
-		return
-	}
-	err = t.TickBlockHeightGoerli()
+// AssetLag is how many blocks the cursor of an asset is behind the chain's block height.
+func AssetLag(chain *Chain, asset *Asset) (int64, error) {
+	blockHeight, err := GetInt(KeyBlockHeight(chain.ID), int(chain.BaseBlock))
 	if err != nil {
-		log.Err(err).Msg("tick block height goerli")
+		return 0, fmt.Errorf("get BlockHeight: %w", err)
 	}
-	err = t.TickPrice()
+	lastBlock, err := GetInt(KeyLastBlock(chain.ID, asset.Symbol), int(chain.BaseBlock))
 	if err != nil {
-		log.Err(err).Msg("tick price")
-		return
+		return 0, fmt.Errorf("get LastBlock: %w", err)
 	}
-	go func() {
-		err = t.CatchUp()
-		if err != nil {
-			log.Err(err).Msg("catching up")
-		}
-	}()
-
-	log.Info().Msg("starting tickers")
-	tickerFast := time.NewTicker(12 * time.Second)
-	tickerMedium := time.NewTicker(20 * time.Second)
-	tickerSlow := time.NewTicker(60 * time.Second)
-
-	for {
-		select {
-		case <-tickerFast.C:
-			log.Info().Str("type", "medium").Msg("running ticker")
-
-			if t.ScrapeMainnetSUPS {
-				err = t.TickMainnetSUPS()
-				if err != nil {
-					log.Err(err).Msg("tick mainnet ETH")
-				}
-			}
-
-			if t.ScrapeMainnetETH {
-				err = t.TickMainnetEth()
-				if err != nil {
-					log.Err(err).Msg("tick mainnet ETH")
-				}
-			}
-
-			if t.ScrapeGoerliSUPS {
-				err = t.TickGoerliSUPS()
-				if err != nil {
-					log.Err(err).Msg("tick goerli SUPS")
-				}
-			}
-
-			if t.ScrapeGoerliETH {
-				err = t.TickGoerliETH()
-				if err != nil {
-					log.Err(err).Msg("tick goerli ETH")
-				}
-			}
-
-			log.Info().Str("type", "fast").Msg("running ticker")
-			err := t.TickBlockHeightMainnet()
-			if err != nil {
-				log.Err(err).Msg("tick block height mainnet")
-			}
-			err = t.TickBlockHeightGoerli()
-			if err != nil {
-				log.Err(err).Msg("tick block height goerli")
-			}
-		case <-tickerMedium.C:
-		case <-tickerSlow.C:
-			log.Info().Str("type", "slow").Msg("running ticker")
-			err := t.TickPrice()
-			if err != nil {
-				log.Err(err).Msg("tick price")
-			}
-		}
+	if lastBlock >= blockHeight {
+		return 0, nil
 	}
+	return int64(blockHeight - lastBlock), nil
 }
 
-func (t *Tickers) TickMainnetEth() error {
-	blockHeightMainnet, err := GetInt(KeyBlockHeightMainnet, BaseMainnetBlock)
+// TickAsset scrapes the next range of an asset from its cursor.
+func (t *Tickers) TickAsset(ctx context.Context, chain *Chain, asset *Asset) error {
+	blockHeight, err := GetInt(KeyBlockHeight(chain.ID), int(chain.BaseBlock))
 	if err != nil {
 		return fmt.Errorf("start ticker: %w", err)
 	}
-	lastBlockETHMainnet, err := GetInt(KeyLastBlockMainnetEth, BaseMainnetBlock)
+	cursor := KeyLastBlock(chain.ID, asset.Symbol)
+	lastBlock, err := GetInt(cursor, int(chain.BaseBlock))
 	if err != nil {
 		return fmt.Errorf("start ticker: %w", err)
 	}
-	toBlock, err := t.TickEth(t.Mainnet, lastBlockETHMainnet, blockHeightMainnet, 1)
-	if err != nil {
-		return fmt.Errorf("tick eth Mainnet: %w", err)
+	if asset.Native() {
+		err = t.TickEth(ctx, chain, asset, cursor, lastBlock, blockHeight)
+	} else {
+		err = t.TickSUPS(ctx, chain, asset, cursor, lastBlock, blockHeight)
 	}
-	err = SetInt(KeyLastBlockMainnetEth, int(toBlock))
 	if err != nil {
-		return fmt.Errorf("set latest block sups Mainnet: %w", err)
+		return fmt.Errorf("tick %s %s: %w", chain.Name, strings.ToLower(asset.Symbol), err)
 	}
 	return nil
 }
-func (t *Tickers) TickGoerliETH() error {
-	blockHeightGoerli, err := GetInt(KeyBlockHeightGoerli, BaseGoerliBlock)
-	if err != nil {
-		return fmt.Errorf("start ticker: %w", err)
-	}
-	lastBlockETHGoerli, err := GetInt(KeyLastBlockGoerliEth, BaseGoerliBlock)
-	if err != nil {
-		return fmt.Errorf("start ticker: %w", err)
-	}
-	toBlock, err := t.TickEth(t.Goerli, lastBlockETHGoerli, blockHeightGoerli, 5)
+
+// TickEth scrapes the next range of native transfers and commits them together with the cursor.
+func (t *Tickers) TickEth(ctx context.Context, chain *Chain, asset *Asset, cursor KVKey, lastBlock int, blockHeight int) error {
+	scrapeRange, err := GetInt(KeyScrapeRangeEth, DefaultScrapeRangeEth)
 	if err != nil {
-		return fmt.Errorf("tick eth goerli: %w", err)
+		return fmt.Errorf("get scrape range: %w", err)
 	}
-	err = SetInt(KeyLastBlockGoerliEth, int(toBlock))
+
+	scrapeRangeLookback, err := GetInt(KeyScrapeRangeLookbackEth, DefaultScrapeRangeLookbackEth)
 	if err != nil {
-		return fmt.Errorf("set latest block sups Goerli: %w", err)
+		return fmt.Errorf("get scrape range: %w", err)
 	}
-	return nil
-}
 
-func (t *Tickers) TickEth(client *ethclient.Client, lastBlock int, blockHeight int, chainID int64) (int64, error) {
-	scrapeRange, err := GetInt(KeyScrapeRangeEth, 500)
+	concurrency, err := GetInt(KeyScrapeConcurrencyEth, DefaultScrapeConcurrencyEth)
 	if err != nil {
-		return 0, fmt.Errorf("get scrape range: %w", err)
+		return fmt.Errorf("get scrape concurrency: %w", err)
 	}
 
-	scrapeRangeLookback, err := GetInt(KeyScrapeRangeLookbackEth, 5)
+	batchSize, err := GetInt(KeyScrapeBatchSizeEth, DefaultScrapeBatchSizeEth)
 	if err != nil {
-		return 0, fmt.Errorf("get scrape range: %w", err)
+		return fmt.Errorf("get scrape batch size: %w", err)
 	}
 
 	fromBlock := int64(lastBlock - scrapeRangeLookback)
@@ -342,85 +138,59 @@ func (t *Tickers) TickEth(client *ethclient.Client, lastBlock int, blockHeight i
 
 	log.Info().
 		Int64("from_block", fromBlock).
-		Int64("chain_id", chainID).
-		Str("symbol", "eth").
+		Int64("chain_id", chain.ID).
+		Str("symbol", asset.Symbol).
 		Msg("scraping transfers")
 
-	whitelisted, err := WhitelistedAddresses(int(chainID))
+	whitelisted, err := WatchedAddresses(chain.ID, fromBlock, toBlock)
 	if err != nil {
-		return 0, fmt.Errorf("scrape transfers: %w", err)
+		return fmt.Errorf("scrape transfers: %w", err)
 	}
 
-	total, err := ScrapeETH(client, fromBlock, toBlock, whitelisted, chainID)
+	transfers, err := ScrapeETH(ctx, chain.Node, fromBlock, toBlock, whitelisted, chain.ID, asset.Symbol, BlockFetchOptions{concurrency, batchSize})
+	if err != nil {
+		return fmt.Errorf("scrape transfers: %w", err)
+	}
+	scraped := &ScrapedRange{ChainID: chain.ID, Symbol: asset.Symbol, FromBlock: fromBlock, ToBlock: toBlock - 1}
+	err = CanonicalHashes(ctx, chain.Node, scraped, transfers, nil)
+	if err != nil {
+		return fmt.Errorf("scrape transfers: %w", err)
+	}
+	total, err := CommitTransfers(transfers, nil, scraped, cursor, lastBlock, int(toBlock))
 	if err != nil {
-		return 0, fmt.Errorf("scrape transfers: %w", err)
+		return fmt.Errorf("save transfers: %w", err)
 	}
 	log.Info().
 		Int64("last_block", int64(lastBlock)).
 		Int64("from_block", fromBlock).
 		Int64("to_block", toBlock).
 		Int("block_height", blockHeight).
-		Int64("chain_id", chainID).
+		Int64("chain_id", chain.ID).
 		Int("total", total).
-		Str("symbol", "eth").
+		Str("symbol", asset.Symbol).
 		Msg("scraped transfers")
-	return toBlock, nil
-}
-
-func (t *Tickers) TickGoerliSUPS() error {
-	blockHeightGoerli, err := GetInt(KeyBlockHeightGoerli, BaseGoerliBlock)
-	if err != nil {
-		return fmt.Errorf("start ticker: %w", err)
-	}
-	lastBlockSUPSGoerli, err := GetInt(KeyLastBlockGoerliSups, BaseGoerliBlock)
-	if err != nil {
-		return fmt.Errorf("start ticker: %w", err)
-	}
-
-	toBlock, err := t.TickSUPS(t.Goerli, lastBlockSUPSGoerli, blockHeightGoerli, 5, 18, t.GoerliSUPSAddr)
-	if err != nil {
-		return fmt.Errorf("tick sups Goerli: %w", err)
-	}
-	err = SetInt(KeyLastBlockGoerliSups, int(toBlock))
-	if err != nil {
-		return fmt.Errorf("set latest block sups Goerli: %w", err)
-	}
 	return nil
 }
-func (t *Tickers) TickMainnetSUPS() error {
-	blockHeightMainnet, err := GetInt(KeyBlockHeightMainnet, BaseMainnetBlock)
-	if err != nil {
-		return fmt.Errorf("start ticker: %w", err)
-	}
-	lastBlockSUPSMainnet, err := GetInt(KeyLastBlockMainnetSups, BaseMainnetBlock)
-	if err != nil {
-		return fmt.Errorf("start ticker: %w", err)
-	}
 
-	toBlock, err := t.TickSUPS(t.Mainnet, lastBlockSUPSMainnet, blockHeightMainnet, 1, 18, t.SUPSAddr)
-	if err != nil {
-		return fmt.Errorf("tick sups mainnet: %w", err)
-	}
-	err = SetInt(KeyLastBlockMainnetSups, int(toBlock))
+// TickSUPS scrapes the next range of token transfers and commits them together with the cursor.
+func (t *Tickers) TickSUPS(ctx context.Context, chain *Chain, asset *Asset, cursor KVKey, lastBlock int, blockHeight int) error {
+	tokenAddr := asset.ContractAddress()
+	limit, err := LogRangeLimit()
 	if err != nil {
-		return fmt.Errorf("set latest block sups mainnet: %w", err)
+		return err
 	}
-	return nil
-}
-
-func (t *Tickers) TickSUPS(client *ethclient.Client, lastBlock int, blockHeight int, chainID int64, decimals int, tokenAddr common.Address) (int64, error) {
-	scrapeRange, err := GetInt(KeyScrapeRangeSups, 5000)
+	scrapeRange, err := logRanges.Size(chain.ID, tokenAddr, limit)
 	if err != nil {
-		return 0, fmt.Errorf("get scrape range: %w", err)
+		return fmt.Errorf("get scrape range: %w", err)
 	}
 
-	scrapeRangeLookback, err := GetInt(KeyScrapeRangeLookbackSups, 50)
+	scrapeRangeLookback, err := GetInt(KeyScrapeRangeLookbackSups, DefaultScrapeRangeLookbackSups)
 	if err != nil {
-		return 0, fmt.Errorf("get scrape range: %w", err)
+		return fmt.Errorf("get scrape range: %w", err)
 	}
 
 	fromBlock := int64(lastBlock - scrapeRangeLookback)
-	toBlock := int64(lastBlock + scrapeRange)
+	toBlock := int64(lastBlock) + scrapeRange
 	if toBlock > int64(blockHeight) {
 		toBlock = int64(blockHeight)
 	}
@@ -428,59 +198,67 @@ func (t *Tickers) TickSUPS(client *ethclient.Client, lastBlock int, blockHeight
 		fromBlock = 0
 	}
 
-	log.Info().Int64("from_block", fromBlock).Int64("chain_id", chainID).Str("symbol", "sups").Msg("scraping transfers")
+	log.Info().Int64("from_block", fromBlock).Int64("chain_id", chain.ID).Str("symbol", asset.Symbol).Str("mode", string(asset.IndexMode)).Msg("scraping transfers")
+
+	watched, err := WatchedAddresses(chain.ID, fromBlock, toBlock)
+	if err != nil {
+		return fmt.Errorf("scrape transfers: %w", err)
+	}
 
-	total, err := ScrapeSUPS(client, fromBlock, toBlock, chainID, tokenAddr, "SUPS", decimals)
+	transfers, err := ScrapeSUPS(ctx, chain.Node, fromBlock, toBlock, chain.ID, asset, watched)
 	if err != nil {
-		return 0, fmt.Errorf("scrape transfers: %w", err)
+		return fmt.Errorf("scrape transfers: %w", err)
+	}
+	approvals, err := ScrapeApprovals(ctx, chain.Node, fromBlock, toBlock, chain.ID, asset, watched)
+	if err != nil {
+		return fmt.Errorf("scrape approvals: %w", err)
+	}
+	scraped := &ScrapedRange{ChainID: chain.ID, Symbol: asset.Symbol, FromBlock: fromBlock, ToBlock: toBlock}
+	err = CanonicalHashes(ctx, chain.Node, scraped, transfers, approvals)
+	if err != nil {
+		return fmt.Errorf("scrape transfers: %w", err)
+	}
+	total, err := CommitTransfers(transfers, approvals, scraped, cursor, lastBlock, int(toBlock))
+	if err != nil {
+		return fmt.Errorf("save transfers: %w", err)
 	}
 	log.Info().
 		Int64("last_block", int64(lastBlock)).
 		Int64("from_block", fromBlock).
 		Int64("to_block", toBlock).
 		Int("block_height", blockHeight).
-		Int64("chain_id", chainID).
+		Int64("chain_id", chain.ID).
 		Int("total", total).
-		Str("symbol", "sups").
+		Int("approvals", len(approvals)).
+		Str("symbol", asset.Symbol).
 		Msg("scraped transfers")
-	return toBlock, nil
-}
-func (t *Tickers) TickBlockHeightGoerli() error {
-	height, err := t.Goerli.BlockNumber(context.TODO())
-	if err != nil {
-		return fmt.Errorf("block height: %w", err)
-	}
-	err = SetInt(KeyBlockHeightGoerli, int(height))
-	if err != nil {
-		return fmt.Errorf("set block height: %w", err)
-	}
-	log.Info().Int("block_height_goerli", int(height)).Msg("scraping block height")
 	return nil
 }
-func (t *Tickers) TickBlockHeightMainnet() error {
-	height, err := t.Mainnet.BlockNumber(context.TODO())
+
+func (t *Tickers) TickBlockHeight(ctx context.Context, chain *Chain) error {
+	height, err := chain.Node.BlockNumber(ctx)
 	if err != nil {
 		return fmt.Errorf("block height: %w", err)
 	}
-	err = SetInt(KeyBlockHeightMainnet, int(height))
+	err = SetInt(KeyBlockHeight(chain.ID), int(height))
 	if err != nil {
 		return fmt.Errorf("set block height: %w", err)
 	}
-	log.Info().Int("block_height_mainnet", int(height)).Msg("scraping block height")
+	log.Info().Str("chain", chain.Name).Int("block_height", int(height)).Msg("scraping block height")
 	return nil
 }
 
-func (t *Tickers) TickPrice() error {
+func (t *Tickers) TickPrice(ctx context.Context) error {
 	log.Info().Msg("scraping prices")
-	supsusd, err := t.SUPSUSD()
+	supsusd, err := t.SUPSUSD(ctx)
 	if err != nil {
 		return fmt.Errorf("get supsusd price: %w", err)
 	}
-	ethusd, err := t.ETHUSD()
+	ethusd, err := t.ETHUSD(ctx)
 	if err != nil {
 		return fmt.Errorf("get ethusd price: %w", err)
 	}
-	bnbusd, err := t.BNBUSD()
+	bnbusd, err := t.BNBUSD(ctx)
 	if err != nil {
 		return fmt.Errorf("get ethusd price: %w", err)
 	}
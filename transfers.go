@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"math/big"
+	"sort"
 	"strings"
 
 	"xsyn-pricefeed/erc20"
@@ -13,7 +14,6 @@ import (
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/core/types"
 	"github.com/ethereum/go-ethereum/crypto"
-	"github.com/ethereum/go-ethereum/ethclient"
 	"github.com/shopspring/decimal"
 )
 
@@ -27,23 +27,24 @@ func ContainsAddress(wantAddr common.Address, whitelistedAddr []common.Address)
 	return false
 }
 
-func ScrapeETH(client *ethclient.Client, fromBlock int64, toBlock int64, whitelistedAddr []common.Address, chainID int64) (int, error) {
-	total := 0
-	for blockNumber := fromBlock; blockNumber < toBlock; blockNumber++ {
-		block, err := client.BlockByNumber(context.TODO(), big.NewInt(blockNumber))
-		if err != nil {
-			return 0, fmt.Errorf("scrape eth get block: %w", err)
-		}
-		timestamp := block.Time()
-		txes := block.Transactions()
+func ScrapeETH(ctx context.Context, node *Node, fromBlock int64, toBlock int64, whitelistedAddr []common.Address, chainID int64, symbol string, opts BlockFetchOptions) ([]*Transfer, error) {
+	transfers := []*Transfer{}
+	blocks, err := FetchBlocks(ctx, node, fromBlock, toBlock, chainID, opts)
+	if err != nil {
+		return nil, fmt.Errorf("scrape eth get blocks: %w", err)
+	}
+	for _, block := range blocks {
+		blockTimes.Remember(chainID, block.Hash, block.Header.Time)
+		timestamp := block.Header.Time
+		txes := block.Transactions
 		for i, tx := range txes {
 			if tx.To() != nil && ContainsAddress(*tx.To(), whitelistedAddr) {
 				msg, err := tx.AsMessage(types.LatestSignerForChainID(big.NewInt(chainID)), nil)
 				if err != nil {
 					log.Err(err).
-						Uint64("block", block.NumberU64()).
+						Uint64("block", block.Header.Number.Uint64()).
 						Int64("chain_id", chainID).
-						Str("symbol", "ETH").
+						Str("symbol", symbol).
 						Int("decimals", 18).
 						Str("tx_id", tx.Hash().Hex()).
 						Msg("eth native transfer")
@@ -55,9 +56,10 @@ func ScrapeETH(client *ethclient.Client, fromBlock int64, toBlock int64, whiteli
 				}
 
 				result := &Transfer{
-					Block:       block.Number().Uint64(),
+					Block:       block.Header.Number.Uint64(),
+					BlockHash:   block.Hash,
 					LogIndex:    uint(i),
-					Symbol:      "ETH",
+					Symbol:      symbol,
 					Decimals:    18,
 					ChainID:     chainID,
 					TxID:        tx.Hash(),
@@ -67,88 +69,161 @@ func ScrapeETH(client *ethclient.Client, fromBlock int64, toBlock int64, whiteli
 					CreatedAt:   timestamp,
 				}
 
-				err = AddTransfer(result)
-				if err != nil {
-					log.Warn().Err(err).
-						Uint64("block", result.Block).
-						Int64("chain_id", result.ChainID).
-						Str("contract", result.Contract.Hex()).
-						Str("symbol", result.Symbol).
-						Int("decimals", result.Decimals).
-						Str("tx_id", result.TxID.Hex()).
-						Str("from_address", result.FromAddress.Hex()).
-						Str("to_address", result.ToAddress.Hex()).
-						Str("amount", result.Amount.String()).
-						Msg("insert transfer")
-					continue
-				}
-				total++
+				transfers = append(transfers, result)
 			}
 		}
 	}
-	return total, nil
+	return transfers, nil
+}
+
+type IndexMode string
+
+// IndexModeAll indexes every transfer of a token.
+const IndexModeAll IndexMode = "all"
+
+// IndexModeWatched only indexes transfers from or to whitelisted addresses,
+// filtering on the indexed from/to topics so the node does the work.
+const IndexModeWatched IndexMode = "watched"
+
+func ParseIndexMode(mode string) (IndexMode, error) {
+	switch IndexMode(mode) {
+	case IndexModeAll, IndexModeWatched:
+		return IndexMode(mode), nil
+	}
+	return "", fmt.Errorf("unknown index mode %q (all or watched)", mode)
 }
-func ScrapeSUPS(client *ethclient.Client, fromBlock int64, toBlock int64, chainID int64, tokenAddr common.Address, tokenSymbol string, tokenDecimals int) (int, error) {
-	total := 0
-	query := ethereum.FilterQuery{
-		FromBlock: big.NewInt(fromBlock),
-		ToBlock:   big.NewInt(toBlock),
+
+func addressTopics(addrs []common.Address) []common.Hash {
+	result := []common.Hash{}
+	for _, addr := range addrs {
+		result = append(result, common.BytesToHash(addr.Bytes()))
+	}
+	return result
+}
+
+// ScrapeSUPS collects the ERC-20 transfers of the asset in [fromBlock, toBlock].
+// In IndexModeWatched only transfers from or to one of watched are returned.
+func ScrapeSUPS(ctx context.Context, client *Node, fromBlock int64, toBlock int64, chainID int64, asset *Asset, watched []common.Address) ([]*Transfer, error) {
+	tokenAddr := asset.ContractAddress()
+	transfers := []*Transfer{}
+	transferTopic := common.HexToHash("0xddf252ad1be2c89b69c2b068fc378daa952ba7f163c4a11628f55a4df523b3ef") // Transfer(address,address,uint256)
+	queries := []ethereum.FilterQuery{{
 		Addresses: []common.Address{tokenAddr},
-		Topics: [][]common.Hash{
-			{common.HexToHash("0xddf252ad1be2c89b69c2b068fc378daa952ba7f163c4a11628f55a4df523b3ef")}, // Transfer(address,address,uint256)
-		},
+		Topics:    [][]common.Hash{{transferTopic}},
+	}}
+	if asset.IndexMode == IndexModeWatched {
+		if len(watched) == 0 {
+			return transfers, nil
+		}
+		// Topics are ANDed across positions, so from and to need a query each.
+		topics := addressTopics(watched)
+		queries = []ethereum.FilterQuery{{
+			Addresses: []common.Address{tokenAddr},
+			Topics:    [][]common.Hash{{transferTopic}, topics},
+		}, {
+			Addresses: []common.Address{tokenAddr},
+			Topics:    [][]common.Hash{{transferTopic}, nil, topics},
+		}}
 	}
 	contractAbi, err := abi.JSON(strings.NewReader(string(erc20.Erc20ABI)))
 	if err != nil {
-		return 0, fmt.Errorf("contract abi: %w", err)
+		return nil, fmt.Errorf("contract abi: %w", err)
 	}
-	logs, err := client.FilterLogs(context.Background(), query)
-	if err != nil {
-		return 0, fmt.Errorf("dial eth node: %w", err)
+	logs := []types.Log{}
+	seen := map[string]bool{}
+	for _, query := range queries {
+		result, err := FilterLogsAdaptive(ctx, client, query, fromBlock, toBlock, chainID, tokenAddr)
+		if err != nil {
+			return nil, fmt.Errorf("filter logs: %w", err)
+		}
+		for _, vLog := range result {
+			key := fmt.Sprintf("%s:%d", vLog.TxHash.Hex(), vLog.Index)
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+			logs = append(logs, vLog)
+		}
 	}
+	sort.Slice(logs, func(i, j int) bool {
+		if logs[i].BlockNumber != logs[j].BlockNumber {
+			return logs[i].BlockNumber < logs[j].BlockNumber
+		}
+		return logs[i].Index < logs[j].Index
+	})
+
 	logTransferSig := []byte("Transfer(address,address,uint256)")
 	logTransferSigHash := crypto.Keccak256Hash(logTransferSig)
 
+	hashes := []common.Hash{}
+	for _, vLog := range logs {
+		hashes = append(hashes, vLog.BlockHash)
+	}
+	times, err := blockTimes.Times(ctx, client, chainID, hashes)
+	if err != nil {
+		return nil, fmt.Errorf("get block times: %w", err)
+	}
+
 	for _, vLog := range logs {
 		switch vLog.Topics[0].Hex() {
 		case logTransferSigHash.Hex():
 			ev, err := contractAbi.Unpack("Transfer", vLog.Data)
 			if err != nil {
-				return 0, fmt.Errorf("unpack log: %w", err)
+				return nil, fmt.Errorf("unpack log: %w", err)
 			}
 			from := common.HexToAddress(vLog.Topics[1].Hex())
 			to := common.HexToAddress(vLog.Topics[2].Hex())
 			amtBig := ev[0].(*big.Int)
 			amt := decimal.NewFromBigInt(amtBig, 0)
-			block, err := client.BlockByHash(context.TODO(), vLog.BlockHash)
-			if err != nil {
-				log.Err(err).Msg("get block")
-				continue
-			}
-			result := &Transfer{vLog.BlockNumber, vLog.Index, chainID, tokenAddr, tokenSymbol, tokenDecimals, vLog.TxHash, from, to, amt, block.Time()}
-			err = AddTransfer(result)
-			if err != nil {
-				log.Warn().Err(err).
-					Uint64("block", result.Block).
-					Int64("chain_id", result.ChainID).
-					Str("contract", result.Contract.Hex()).
-					Str("symbol", result.Symbol).
-					Int("decimals", result.Decimals).
-					Str("tx_id", result.TxID.Hex()).
-					Str("from_address", result.FromAddress.Hex()).
-					Str("to_address", result.ToAddress.Hex()).
-					Str("amount", result.Amount.String()).
-					Msg("insert transfer")
-				continue
-			}
-			total++
+			result := &Transfer{vLog.BlockNumber, vLog.BlockHash, vLog.Index, chainID, tokenAddr, asset.Symbol, asset.Decimals, vLog.TxHash, from, to, amt, times[vLog.BlockHash]}
+			transfers = append(transfers, result)
 		}
 	}
-	return total, nil
+	return transfers, nil
+}
+
+// CanonicalHashes fills in scraped.Canonical for the heights of the range that have indexed transfers or approvals.
+// Heights the fresh transfers or approvals are at take their hash from those; the node is asked for the others.
+func CanonicalHashes(ctx context.Context, client *Node, scraped *ScrapedRange, fresh []*Transfer, approvals []*Approval) error {
+	heights, err := IndexedBlocks(ctx, scraped)
+	if err != nil {
+		return err
+	}
+	known := map[uint64]common.Hash{}
+	for _, transfer := range fresh {
+		if transfer.BlockHash != (common.Hash{}) {
+			known[transfer.Block] = transfer.BlockHash
+		}
+	}
+	for _, approval := range approvals {
+		if approval.BlockHash != (common.Hash{}) {
+			known[approval.Block] = approval.BlockHash
+		}
+	}
+	canonical := map[uint64]common.Hash{}
+	missing := []uint64{}
+	for _, height := range heights {
+		hash, ok := known[height]
+		if !ok {
+			missing = append(missing, height)
+			continue
+		}
+		canonical[height] = hash
+	}
+	fetched, err := BlockHashes(ctx, client, missing)
+	if err != nil {
+		return fmt.Errorf("get canonical hashes: %w", err)
+	}
+	for height, hash := range fetched {
+		canonical[height] = hash
+	}
+	scraped.Canonical = canonical
+	return nil
 }
 
 type Transfer struct {
 	Block       uint64
+	BlockHash   common.Hash
 	LogIndex    uint
 	ChainID     int64
 	Contract    common.Address
@@ -0,0 +1,253 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/shopspring/decimal"
+)
+
+const PriceSourceChainlink = "chainlink"
+const PriceSourceUniswap = "uniswap"
+const PriceSourceHistory = "prices"
+
+// PriceSourceUnavailable marks a transfer the recorded prices have no price for at its block time, so it isn't
+// tried again. Prices are never recorded after the fact, so that gap is final.
+const PriceSourceUnavailable = "unavailable"
+
+const DefaultValuationBatchSize = 500
+
+// PricedAssets are the symbols the price contracts and the prices table cover, on the chains where they are
+// the coins that trade at that price. The same symbols on testnets are worthless and aren't priced.
+var PricedAssets = map[int64][]string{
+	1:  {"SUPS", "ETH"},
+	56: {"SUPS", "BNB"},
+}
+
+// Priced reports whether symbol on chainID trades at the price the service records.
+func Priced(chainID int64, symbol string) bool {
+	for _, priced := range PricedAssets[chainID] {
+		if strings.EqualFold(priced, symbol) {
+			return true
+		}
+	}
+	return false
+}
+
+// TransferValuation is the dollar value of a transfer at its block time. Price and Value are nil when
+// Source is PriceSourceUnavailable.
+type TransferValuation struct {
+	TransferID string
+	Price      *decimal.Decimal
+	Value      *decimal.Decimal
+	Source     string
+}
+
+// USD returns the recorded price of symbol in dollars.
+func (p *PriceResponse) USD(symbol string) (decimal.Decimal, bool) {
+	var cents decimal.Decimal
+	switch strings.ToUpper(symbol) {
+	case "SUPS":
+		cents = p.SUPSUSD
+	case "ETH":
+		cents = p.ETHUSD
+	case "BNB":
+		cents = p.BNBUSD
+	default:
+		return decimal.Zero, false
+	}
+	if !cents.IsPositive() {
+		return decimal.Zero, false
+	}
+	return cents.Div(decimal.NewFromInt(100)), true
+}
+
+// missingStateErrors are substrings of the errors nodes return for state they don't keep, like a pruned node
+// asked about an old block. Asking again won't help, so those blocks are priced from the recorded prices.
+var missingStateErrors = []string{
+	"missing trie node",
+	"header not found",
+	"historical state",
+	"state not available",
+	"state is not available",
+	"pruned",
+}
+
+func IsMissingStateError(err error) bool {
+	if err == nil {
+		return false
+	}
+	msg := strings.ToLower(err.Error())
+	for _, s := range missingStateErrors {
+		if strings.Contains(msg, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// USDPriceReader reads prices pinned to a block of the price chain. EthClient is one.
+type USDPriceReader interface {
+	USDPriceAt(ctx context.Context, symbol string, block uint64) (decimal.Decimal, bool, error)
+}
+
+// ValuationStore holds the transfers to value and the recorded prices.
+type ValuationStore interface {
+	UnvaluedTransfers(ctx context.Context, chainID int64, symbol string, limit int) ([]*TransferRecord, error)
+	PriceAt(ctx context.Context, at time.Time) (*PriceResponse, error)
+	SetTransferValuations(ctx context.Context, valuations []*TransferValuation) error
+}
+
+// dbValuationStore is the ValuationStore of the database.
+type dbValuationStore struct{}
+
+func (dbValuationStore) UnvaluedTransfers(ctx context.Context, chainID int64, symbol string, limit int) ([]*TransferRecord, error) {
+	return UnvaluedTransfers(ctx, chainID, symbol, limit)
+}
+
+func (dbValuationStore) PriceAt(ctx context.Context, at time.Time) (*PriceResponse, error) {
+	return PriceAt(ctx, at)
+}
+
+func (dbValuationStore) SetTransferValuations(ctx context.Context, valuations []*TransferValuation) error {
+	return SetTransferValuations(ctx, valuations)
+}
+
+// Valuer prices transfers of PricedAssets at their block time. Transfers on the price chain are priced by
+// reading the contracts pinned to their block, which needs an archive node; transfers on other chains, from
+// before the contracts were deployed or the pool was initialised, or at blocks whose state the node doesn't
+// have, are priced from the last recorded prices at or before the block time, if they are at most MaxPriceAge old.
+// Any other failed read leaves the transfer unvalued until it succeeds.
+// Valuations are stored the first time they are computed and never recomputed, so reports are reproducible.
+type Valuer struct {
+	Prices      USDPriceReader
+	Chains      *ChainRegistry
+	MaxPriceAge time.Duration
+	BatchSize   int
+	// Store defaults to the database.
+	Store ValuationStore
+}
+
+func (v *Valuer) store() ValuationStore {
+	if v.Store == nil {
+		return dbValuationStore{}
+	}
+	return v.Store
+}
+
+// Run values one batch of unvalued transfers per priced asset. An asset that fails is logged and skipped,
+// so it doesn't hold the others back; Run then returns an error naming how many failed.
+func (v *Valuer) Run(ctx context.Context) error {
+	batchSize := v.BatchSize
+	if batchSize <= 0 {
+		batchSize = DefaultValuationBatchSize
+	}
+	failed := 0
+	var lastErr error
+	for _, chain := range v.Chains.Chains {
+		for _, symbol := range PricedAssets[chain.ID] {
+			asset, ok := chain.Asset(symbol)
+			if !ok {
+				continue
+			}
+			records, err := v.store().UnvaluedTransfers(ctx, chain.ID, asset.Symbol, batchSize)
+			if err == nil {
+				_, err = v.Value(ctx, records)
+			}
+			if err != nil {
+				log.Err(err).Int64("chain_id", chain.ID).Str("symbol", asset.Symbol).Msg("value transfers")
+				failed++
+				lastErr = err
+			}
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("value transfers of %d assets: %w", failed, lastErr)
+	}
+	return nil
+}
+
+// Value fills in the valuation of records that have none and stores it. It returns how many were valued.
+// When a price can't be read, the records valued before it are still stored and the error is returned.
+func (v *Valuer) Value(ctx context.Context, records []*TransferRecord) (int, error) {
+	valuations := []*TransferValuation{}
+	valued := []*TransferRecord{}
+	pinned := map[string]*TransferValuation{}
+	var priceErr error
+	for _, record := range records {
+		if record.PriceSource != nil || !Priced(record.ChainID, record.Symbol) {
+			continue
+		}
+		key := fmt.Sprintf("%d:%d:%s", record.ChainID, record.Block, record.Symbol)
+		valuation, ok := pinned[key]
+		if !ok {
+			valuation, priceErr = v.price(ctx, record)
+			if priceErr != nil {
+				break
+			}
+			pinned[key] = valuation
+		}
+		result := &TransferValuation{TransferID: record.ID.String(), Price: valuation.Price, Source: valuation.Source}
+		if valuation.Price != nil {
+			value := record.Amount.Shift(-int32(record.Decimals)).Mul(*valuation.Price).Round(8)
+			result.Value = &value
+		}
+		valuations = append(valuations, result)
+		valued = append(valued, record)
+	}
+	if len(valuations) > 0 {
+		err := v.store().SetTransferValuations(ctx, valuations)
+		if err != nil {
+			return 0, err
+		}
+		for i, record := range valued {
+			record.USDPrice = valuations[i].Price
+			record.USDValue = valuations[i].Value
+			record.PriceSource = &valuations[i].Source
+		}
+	}
+	if priceErr != nil {
+		return len(valuations), fmt.Errorf("value transfers: %w", priceErr)
+	}
+	return len(valuations), nil
+}
+
+// price finds the price of a transfer's symbol at its block. Only the price valuation fields are set.
+// Only a definitive answer is returned: a transient error reading the contracts is returned rather than falling
+// back, so a node outage can't turn into a stored valuation from a worse source. Reads that can never succeed,
+// because the contracts or the block's state aren't there, fall back to the recorded prices.
+func (v *Valuer) price(ctx context.Context, record *TransferRecord) (*TransferValuation, error) {
+	if record.ChainID == PriceChainID && v.Prices != nil {
+		price, ok, err := v.Prices.USDPriceAt(ctx, record.Symbol, record.Block)
+		if IsMissingStateError(err) {
+			log.Warn().Err(err).Str("symbol", record.Symbol).Uint64("block", record.Block).Msg("no state to price transfer, using recorded prices")
+		} else if err != nil && !errors.Is(err, bind.ErrNoCode) {
+			return nil, fmt.Errorf("read %s price at block %d: %w", record.Symbol, record.Block, err)
+		}
+		if err == nil && ok && price.IsPositive() {
+			source := PriceSourceChainlink
+			if strings.ToUpper(record.Symbol) == "SUPS" {
+				source = PriceSourceUniswap
+			}
+			return &TransferValuation{Price: &price, Source: source}, nil
+		}
+	}
+
+	at := time.Unix(record.Timestamp, 0)
+	prices, err := v.store().PriceAt(ctx, at)
+	if err != nil {
+		return nil, err
+	}
+	if prices == nil || at.Sub(time.Unix(prices.Time, 0)) > v.MaxPriceAge {
+		return &TransferValuation{Source: PriceSourceUnavailable}, nil
+	}
+	price, ok := prices.USD(record.Symbol)
+	if !ok {
+		return &TransferValuation{Source: PriceSourceUnavailable}, nil
+	}
+	return &TransferValuation{Price: &price, Source: PriceSourceHistory}, nil
+}
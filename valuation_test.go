@@ -0,0 +1,179 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/shopspring/decimal"
+)
+
+type testPriceReader struct {
+	prices map[string]decimal.Decimal
+	errs   map[string]error
+	reads  int
+}
+
+func (r *testPriceReader) USDPriceAt(ctx context.Context, symbol string, block uint64) (decimal.Decimal, bool, error) {
+	r.reads++
+	if err, ok := r.errs[strings.ToUpper(symbol)]; ok {
+		return decimal.Zero, false, err
+	}
+	price, ok := r.prices[strings.ToUpper(symbol)]
+	return price, ok, nil
+}
+
+type testValuationStore struct {
+	unvalued map[string][]*TransferRecord
+	prices   *PriceResponse
+	stored   map[string]*TransferValuation
+}
+
+func (s *testValuationStore) UnvaluedTransfers(ctx context.Context, chainID int64, symbol string, limit int) ([]*TransferRecord, error) {
+	return s.unvalued[fmt.Sprintf("%d:%s", chainID, symbol)], nil
+}
+
+func (s *testValuationStore) PriceAt(ctx context.Context, at time.Time) (*PriceResponse, error) {
+	return s.prices, nil
+}
+
+func (s *testValuationStore) SetTransferValuations(ctx context.Context, valuations []*TransferValuation) error {
+	for _, valuation := range valuations {
+		s.stored[valuation.TransferID] = valuation
+	}
+	return nil
+}
+
+func testRecord(id byte, chainID int64, symbol string, block uint64, minedAt time.Time) *TransferRecord {
+	return &TransferRecord{
+		ID:        uuid.UUID{id},
+		Block:     block,
+		ChainID:   chainID,
+		Symbol:    symbol,
+		Decimals:  18,
+		Amount:    decimal.New(2, 18),
+		Timestamp: minedAt.Unix(),
+	}
+}
+
+func TestValuerRunSkipsFailingAsset(t *testing.T) {
+	minedAt := time.Unix(1700000000, 0)
+	eth := testRecord(1, 1, "ETH", 100, minedAt)
+	bnb := testRecord(2, 56, "BNB", 200, minedAt)
+	store := &testValuationStore{
+		unvalued: map[string][]*TransferRecord{"1:ETH": {eth}, "56:BNB": {bnb}},
+		prices:   &PriceResponse{Time: minedAt.Add(-time.Minute).Unix(), ETHUSD: decimal.NewFromInt(200000), BNBUSD: decimal.NewFromInt(30000)},
+		stored:   map[string]*TransferValuation{},
+	}
+	valuer := &Valuer{
+		Prices:      &testPriceReader{errs: map[string]error{"ETH": errors.New("connection refused")}},
+		Chains:      &ChainRegistry{Chains: []*Chain{{ID: 1, Assets: []*Asset{{Symbol: "ETH"}}}, {ID: 56, Assets: []*Asset{{Symbol: "BNB"}}}}},
+		MaxPriceAge: time.Hour,
+		Store:       store,
+	}
+
+	err := valuer.Run(context.Background())
+	if err == nil {
+		t.Fatal("Run() = nil, want the ETH price error")
+	}
+	if _, ok := store.stored[eth.ID.String()]; ok {
+		t.Error("ETH transfer valued despite its price read failing")
+	}
+	valuation, ok := store.stored[bnb.ID.String()]
+	if !ok {
+		t.Fatal("BNB transfer not valued after the ETH asset failed")
+	}
+	if valuation.Source != PriceSourceHistory || !valuation.Value.Equal(decimal.NewFromInt(600)) {
+		t.Errorf("BNB valuation = %s %v, want %s 600", valuation.Source, valuation.Value, PriceSourceHistory)
+	}
+}
+
+func TestValuerPrice(t *testing.T) {
+	minedAt := time.Unix(1700000000, 0)
+	recorded := &PriceResponse{Time: minedAt.Add(-time.Minute).Unix(), SUPSUSD: decimal.NewFromInt(2), ETHUSD: decimal.NewFromInt(200000)}
+	tests := []struct {
+		name   string
+		record *TransferRecord
+		reader *testPriceReader
+		prices *PriceResponse
+		source string
+		price  string
+		err    bool
+	}{
+		{
+			name:   "pinned read",
+			record: testRecord(1, 1, "ETH", 100, minedAt),
+			reader: &testPriceReader{prices: map[string]decimal.Decimal{"ETH": decimal.NewFromInt(1900)}},
+			prices: recorded,
+			source: PriceSourceChainlink,
+			price:  "1900",
+		},
+		{
+			name:   "transient read error",
+			record: testRecord(1, 1, "ETH", 100, minedAt),
+			reader: &testPriceReader{errs: map[string]error{"ETH": errors.New("connection refused")}},
+			prices: recorded,
+			err:    true,
+		},
+		{
+			name:   "missing trie node",
+			record: testRecord(1, 1, "ETH", 100, minedAt),
+			reader: &testPriceReader{errs: map[string]error{"ETH": errors.New("missing trie node 5f3c (path ) <nil>")}},
+			prices: recorded,
+			source: PriceSourceHistory,
+			price:  "2000",
+		},
+		{
+			name:   "missing state and no recorded price",
+			record: testRecord(1, 1, "ETH", 100, minedAt),
+			reader: &testPriceReader{errs: map[string]error{"ETH": errors.New("header not found")}},
+			source: PriceSourceUnavailable,
+		},
+		{
+			name:   "pool not initialised",
+			record: testRecord(1, 1, "SUPS", 100, minedAt),
+			reader: &testPriceReader{},
+			prices: recorded,
+			source: PriceSourceHistory,
+			price:  "0.02",
+		},
+		{
+			name:   "recorded price too old",
+			record: testRecord(1, 56, "SUPS", 100, minedAt.Add(2*time.Hour)),
+			reader: &testPriceReader{},
+			prices: recorded,
+			source: PriceSourceUnavailable,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			valuer := &Valuer{Prices: tt.reader, MaxPriceAge: time.Hour, Store: &testValuationStore{prices: tt.prices}}
+			got, err := valuer.price(context.Background(), tt.record)
+			if tt.err {
+				if err == nil {
+					t.Errorf("price() = %s, want an error", got.Source)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("price() = %v", err)
+			}
+			if got.Source != tt.source {
+				t.Errorf("source = %s, want %s", got.Source, tt.source)
+			}
+			if tt.price == "" {
+				if got.Price != nil {
+					t.Errorf("price = %s, want none", got.Price)
+				}
+				return
+			}
+			if got.Price == nil || !got.Price.Equal(decimal.RequireFromString(tt.price)) {
+				t.Errorf("price = %v, want %s", got.Price, tt.price)
+			}
+		})
+	}
+}
@@ -0,0 +1,687 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/go-chi/chi/v5"
+	"github.com/gofrs/uuid"
+	"github.com/prometheus/client_golang/prometheus"
+	"golang.org/x/sync/errgroup"
+)
+
+var (
+	webhookDeliveriesCounter = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "xsyn_pricefeed_webhook_deliveries_total",
+		Help: "How many webhook delivery attempts were made, partitioned by result (delivered, failed or dead).",
+	}, []string{"result"})
+	webhookDurationHistogram = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    "xsyn_pricefeed_webhook_delivery_seconds",
+		Help:    "Latency of webhook delivery attempts.",
+		Buckets: prometheus.ExponentialBuckets(0.01, 2, 12),
+	})
+)
+
+func init() {
+	prometheus.MustRegister(webhookDeliveriesCounter, webhookDurationHistogram)
+}
+
+type DeliveryStatus string
+
+const DeliveryPending DeliveryStatus = "pending"
+const DeliveryDelivered DeliveryStatus = "delivered"
+const DeliveryDead DeliveryStatus = "dead"
+const DeliveryCancelled DeliveryStatus = "cancelled"
+
+const WebhookEventTransfer = "transfer"
+const DefaultWebhookBatchSize = 100
+
+// WebhookSignatureHeader carries "t=<unix time>,v1=<hex HMAC-SHA256 of "<unix time>.<body>" keyed with the secret>".
+const WebhookSignatureHeader = "X-Pricefeed-Signature"
+
+// ErrWebhookTarget is returned for webhook URLs that aren't public http(s) URLs.
+var ErrWebhookTarget = errors.New("webhook target not allowed")
+
+// Webhook subscribes a URL to new transfers. Nil filters match everything, and Address matches either side of a transfer.
+type Webhook struct {
+	ID               uuid.UUID
+	URL              string
+	Secret           string
+	ChainID          *int64
+	Symbol           *string
+	Address          *string
+	MinConfirmations int64
+	CreatedAt        time.Time
+	DeletedAt        *time.Time
+}
+
+func (w *Webhook) Matches(transfer *Transfer) bool {
+	if w.ChainID != nil && *w.ChainID != transfer.ChainID {
+		return false
+	}
+	if w.Symbol != nil && *w.Symbol != transfer.Symbol {
+		return false
+	}
+	if w.Address != nil && *w.Address != transfer.FromAddress.Hex() && *w.Address != transfer.ToAddress.Hex() {
+		return false
+	}
+	return true
+}
+
+// WebhookDelivery is an outbox row: one event for one webhook, written in the transaction that indexed the transfer.
+type WebhookDelivery struct {
+	ID             uuid.UUID
+	WebhookID      uuid.UUID
+	TransferID     uuid.UUID
+	Event          string
+	ChainID        int64
+	Block          uint64
+	Payload        []byte
+	Status         DeliveryStatus
+	Attempts       int
+	NextAttemptAt  time.Time
+	LastStatusCode *int
+	LastError      *string
+	DeliveredAt    *time.Time
+	CreatedAt      time.Time
+}
+
+// DueWebhookDelivery is a delivery ready to be sent, with the webhook it goes to.
+type DueWebhookDelivery struct {
+	WebhookDelivery
+	URL    string
+	Secret string
+}
+
+type WebhookAttempt struct {
+	DeliveryID uuid.UUID
+	Attempt    int
+	StatusCode *int
+	Error      *string
+	DurationMS int64
+	CreatedAt  time.Time
+}
+
+type WebhookEvent struct {
+	ID        string               `json:"id"`
+	Event     string               `json:"event"`
+	WebhookID string               `json:"webhook_id"`
+	Attempt   int                  `json:"attempt"`
+	Data      *TransferAPIResponse `json:"data"`
+}
+
+func NewWebhookSecret() (string, error) {
+	raw := make([]byte, 32)
+	_, err := rand.Read(raw)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(raw), nil
+}
+
+// SignWebhook returns the signature header value for body sent at ts.
+func SignWebhook(secret string, ts time.Time, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "%d.", ts.Unix())
+	mac.Write(body)
+	return fmt.Sprintf("t=%d,v1=%s", ts.Unix(), hex.EncodeToString(mac.Sum(nil)))
+}
+
+// sharedAddressSpace is the carrier-grade NAT range, internal to some clouds.
+var sharedAddressSpace = &net.IPNet{IP: net.IPv4(100, 64, 0, 0), Mask: net.CIDRMask(10, 32)}
+
+// checkWebhookIP refuses the addresses a webhook must never reach: loopback, private, shared, link-local
+// (which includes the cloud metadata endpoints), unspecified and multicast ones.
+func checkWebhookIP(ip net.IP) error {
+	if ip.IsLoopback() || ip.IsPrivate() || sharedAddressSpace.Contains(ip) || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() || ip.IsUnspecified() {
+		return fmt.Errorf("%w: %s is not a public address", ErrWebhookTarget, ip)
+	}
+	return nil
+}
+
+// ValidateWebhookURL checks that rawurl is an http(s) URL whose host only resolves to public addresses.
+// The dispatcher checks the address again when it connects, since DNS can change in between.
+func ValidateWebhookURL(ctx context.Context, rawurl string) (*url.URL, error) {
+	target, err := url.Parse(rawurl)
+	if err != nil || (target.Scheme != "http" && target.Scheme != "https") || target.Hostname() == "" {
+		return nil, fmt.Errorf("%w: not an http(s) url", ErrWebhookTarget)
+	}
+	if ip := net.ParseIP(target.Hostname()); ip != nil {
+		return target, checkWebhookIP(ip)
+	}
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, target.Hostname())
+	if err != nil {
+		return nil, fmt.Errorf("%w: resolve %s: %v", ErrWebhookTarget, target.Hostname(), err)
+	}
+	for _, addr := range addrs {
+		err = checkWebhookIP(addr.IP)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return target, nil
+}
+
+// NewWebhookClient returns the client deliveries are sent with. It refuses to connect to anything but public
+// addresses, whatever the URL resolves to at the time, doesn't use proxies and doesn't follow redirects.
+func NewWebhookClient(timeout time.Duration) *http.Client {
+	dialer := &net.Dialer{
+		Timeout: 30 * time.Second,
+		Control: func(network string, address string, c syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			ip := net.ParseIP(host)
+			if ip == nil {
+				return fmt.Errorf("%w: %s is not an ip address", ErrWebhookTarget, host)
+			}
+			return checkWebhookIP(ip)
+		},
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+	return &http.Client{
+		Timeout:   timeout,
+		Transport: transport,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+// transferPayload is the event data of a transfer, in the shape of /api/transfers without the confirmations,
+// which are filled in when it is sent.
+func transferPayload(transfer *Transfer) *TransferAPIResponse {
+	return &TransferAPIResponse{
+		TxHash:          transfer.TxID.Hex(),
+		LogIndex:        transfer.LogIndex,
+		Time:            time.Now().Unix(),
+		Chain:           transfer.ChainID,
+		BlockNumber:     transfer.Block,
+		FromAddress:     transfer.FromAddress.Hex(),
+		ToAddress:       transfer.ToAddress.Hex(),
+		ContractAddress: transfer.Contract.Hex(),
+		Value:           transfer.Amount.Shift(-int32(transfer.Decimals)).String(),
+		ValueInt:        transfer.Amount.String(),
+		Timestamp:       int64(transfer.CreatedAt),
+		ValueDecimals:   transfer.Decimals,
+		Symbol:          transfer.Symbol,
+	}
+}
+
+// WebhookDispatcher sends the due deliveries of the outbox. A delivery is due once its transfer has the
+// webhook's minimum confirmations; failures are retried after MinBackoff, doubling up to MaxBackoff,
+// and a delivery failing MaxAttempts times is dead until it is redelivered through the API.
+type WebhookDispatcher struct {
+	Chains      *ChainRegistry
+	Client      *http.Client
+	MaxAttempts int
+	MinBackoff  time.Duration
+	MaxBackoff  time.Duration
+	Concurrency int
+	BatchSize   int
+}
+
+// Run sends one batch of due deliveries per chain.
+func (d *WebhookDispatcher) Run(ctx context.Context) error {
+	concurrency := d.Concurrency
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	for _, chain := range d.Chains.Chains {
+		height, err := GetInt(KeyBlockHeight(chain.ID), int(chain.BaseBlock))
+		if err != nil {
+			return fmt.Errorf("get block height: %w", err)
+		}
+		deliveries, err := DueWebhookDeliveries(ctx, chain.ID, uint64(height), d.BatchSize)
+		if err != nil {
+			return err
+		}
+
+		g, gctx := errgroup.WithContext(ctx)
+		g.SetLimit(concurrency)
+		for _, delivery := range deliveries {
+			delivery := delivery
+			g.Go(func() error {
+				return d.deliver(gctx, delivery, uint64(height))
+			})
+		}
+		err = g.Wait()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// deliver sends a delivery once and records the attempt. Only failing to record it is an error.
+func (d *WebhookDispatcher) deliver(ctx context.Context, delivery *DueWebhookDelivery, height uint64) error {
+	attempt := &WebhookAttempt{DeliveryID: delivery.ID, Attempt: delivery.Attempts + 1}
+	start := time.Now()
+	statusCode, err := d.send(ctx, delivery, attempt.Attempt, height)
+	attempt.DurationMS = time.Since(start).Milliseconds()
+	webhookDurationHistogram.Observe(time.Since(start).Seconds())
+	if statusCode != 0 {
+		attempt.StatusCode = &statusCode
+	}
+
+	d.settle(&delivery.WebhookDelivery, attempt, err, time.Now())
+	webhookDeliveriesCounter.WithLabelValues(deliveryResult(delivery.Status)).Inc()
+	if err != nil {
+		log.Warn().Err(err).
+			Str("webhook", delivery.WebhookID.String()).
+			Str("delivery", delivery.ID.String()).
+			Int("attempt", delivery.Attempts).
+			Str("status", string(delivery.Status)).
+			Msg("deliver webhook")
+	}
+	return RecordWebhookAttempt(ctx, &delivery.WebhookDelivery, attempt)
+}
+
+// settle applies the outcome of an attempt to the delivery: it is delivered when err is nil, and otherwise
+// retried after the backoff, or dead once it failed MaxAttempts times.
+func (d *WebhookDispatcher) settle(delivery *WebhookDelivery, attempt *WebhookAttempt, err error, now time.Time) {
+	delivery.Attempts = attempt.Attempt
+	delivery.LastStatusCode = attempt.StatusCode
+	delivery.LastError = nil
+	if err == nil {
+		delivery.Status = DeliveryDelivered
+		delivery.DeliveredAt = &now
+		return
+	}
+	msg := err.Error()
+	attempt.Error = &msg
+	delivery.LastError = &msg
+	delivery.NextAttemptAt = now.Add(d.backoff(delivery.Attempts))
+	if delivery.Attempts >= d.MaxAttempts {
+		delivery.Status = DeliveryDead
+	}
+}
+
+// deliveryResult labels an attempt in webhookDeliveriesCounter by the status it left its delivery in.
+func deliveryResult(status DeliveryStatus) string {
+	switch status {
+	case DeliveryDelivered:
+		return "delivered"
+	case DeliveryDead:
+		return "dead"
+	default:
+		return "failed"
+	}
+}
+
+func (d *WebhookDispatcher) send(ctx context.Context, delivery *DueWebhookDelivery, attempt int, height uint64) (int, error) {
+	data := &TransferAPIResponse{}
+	err := json.Unmarshal(delivery.Payload, data)
+	if err != nil {
+		return 0, fmt.Errorf("decode payload: %w", err)
+	}
+	data.Confirmations = int(height) - int(data.BlockNumber)
+	body, err := json.Marshal(&WebhookEvent{
+		ID:        delivery.ID.String(),
+		Event:     delivery.Event,
+		WebhookID: delivery.WebhookID.String(),
+		Attempt:   attempt,
+		Data:      data,
+	})
+	if err != nil {
+		return 0, fmt.Errorf("encode event: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, delivery.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "xsyn-pricefeed")
+	req.Header.Set("X-Pricefeed-Event", delivery.Event)
+	req.Header.Set("X-Pricefeed-Delivery", delivery.ID.String())
+	req.Header.Set(WebhookSignatureHeader, SignWebhook(delivery.Secret, time.Now(), body))
+	resp, err := d.Client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return resp.StatusCode, nil
+}
+
+func (d *WebhookDispatcher) backoff(attempts int) time.Duration {
+	result := d.MinBackoff
+	for i := 1; i < attempts && result < d.MaxBackoff; i++ {
+		result *= 2
+	}
+	if result > d.MaxBackoff {
+		result = d.MaxBackoff
+	}
+	return result
+}
+
+const DefaultDeliveriesLimit = 100
+
+const MaxDeliveriesLimit = 1000
+
+type CreateWebhookRequest struct {
+	URL              string  `json:"url"`
+	Secret           string  `json:"secret"`
+	Chain            *string `json:"chain"`
+	Symbol           *string `json:"symbol"`
+	Address          *string `json:"address"`
+	MinConfirmations int64   `json:"min_confirmations"`
+}
+
+// WebhookResponse only includes the secret when the webhook is created.
+type WebhookResponse struct {
+	ID               string    `json:"id"`
+	URL              string    `json:"url"`
+	Secret           string    `json:"secret,omitempty"`
+	Chain            *int64    `json:"chain"`
+	Symbol           *string   `json:"symbol"`
+	Address          *string   `json:"address"`
+	MinConfirmations int64     `json:"min_confirmations"`
+	CreatedAt        time.Time `json:"created_at"`
+}
+
+func webhookResponse(webhook *Webhook) *WebhookResponse {
+	return &WebhookResponse{
+		ID:               webhook.ID.String(),
+		URL:              webhook.URL,
+		Chain:            webhook.ChainID,
+		Symbol:           webhook.Symbol,
+		Address:          webhook.Address,
+		MinConfirmations: webhook.MinConfirmations,
+		CreatedAt:        webhook.CreatedAt,
+	}
+}
+
+type WebhookAttemptResponse struct {
+	Attempt    int       `json:"attempt"`
+	StatusCode *int      `json:"status_code"`
+	Error      *string   `json:"error"`
+	DurationMS int64     `json:"duration_ms"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+type DeliveryResponse struct {
+	ID             string                    `json:"id"`
+	Event          string                    `json:"event"`
+	Chain          int64                     `json:"chain"`
+	Block          uint64                    `json:"block_number"`
+	Payload        json.RawMessage           `json:"payload"`
+	Status         DeliveryStatus            `json:"status"`
+	Attempts       int                       `json:"attempts"`
+	NextAttemptAt  time.Time                 `json:"next_attempt_at"`
+	LastStatusCode *int                      `json:"last_status_code"`
+	LastError      *string                   `json:"last_error"`
+	DeliveredAt    *time.Time                `json:"delivered_at"`
+	CreatedAt      time.Time                 `json:"created_at"`
+	Log            []*WebhookAttemptResponse `json:"log,omitempty"`
+}
+
+func deliveryResponse(delivery *WebhookDelivery) *DeliveryResponse {
+	return &DeliveryResponse{
+		ID:             delivery.ID.String(),
+		Event:          delivery.Event,
+		Chain:          delivery.ChainID,
+		Block:          delivery.Block,
+		Payload:        delivery.Payload,
+		Status:         delivery.Status,
+		Attempts:       delivery.Attempts,
+		NextAttemptAt:  delivery.NextAttemptAt,
+		LastStatusCode: delivery.LastStatusCode,
+		LastError:      delivery.LastError,
+		DeliveredAt:    delivery.DeliveredAt,
+		CreatedAt:      delivery.CreatedAt,
+	}
+}
+
+// CreateWebhook subscribes a URL to new transfers matching the optional chain, symbol and address filters.
+// A secret is generated when none is given and is only returned here.
+func (c *Controller) CreateWebhook(w http.ResponseWriter, r *http.Request) {
+	req := &CreateWebhookRequest{}
+	err := json.NewDecoder(r.Body).Decode(req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	target, err := ValidateWebhookURL(r.Context(), req.URL)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if req.MinConfirmations < 0 {
+		http.Error(w, "invalid min_confirmations", http.StatusBadRequest)
+		return
+	}
+	webhook := &Webhook{URL: target.String(), Secret: req.Secret, MinConfirmations: req.MinConfirmations}
+	if webhook.Secret == "" {
+		webhook.Secret, err = NewWebhookSecret()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+	if req.Chain != nil {
+		chain, ok := c.Chains.Lookup(*req.Chain)
+		if !ok {
+			http.Error(w, "unknown chain", http.StatusBadRequest)
+			return
+		}
+		webhook.ChainID = &chain.ID
+		if req.Symbol != nil {
+			asset, ok := chain.Asset(*req.Symbol)
+			if !ok {
+				http.Error(w, "unknown symbol", http.StatusBadRequest)
+				return
+			}
+			webhook.Symbol = &asset.Symbol
+		}
+	} else if req.Symbol != nil {
+		symbol := strings.ToUpper(*req.Symbol)
+		webhook.Symbol = &symbol
+	}
+	if req.Address != nil {
+		if !common.IsHexAddress(*req.Address) {
+			http.Error(w, "invalid address", http.StatusBadRequest)
+			return
+		}
+		address := common.HexToAddress(*req.Address).Hex()
+		webhook.Address = &address
+	}
+
+	webhook, err = CreateWebhook(webhook)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	result := webhookResponse(webhook)
+	result.Secret = webhook.Secret
+
+	w.WriteHeader(http.StatusCreated)
+	err = json.NewEncoder(w).Encode(result)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+func (c *Controller) Webhooks(w http.ResponseWriter, r *http.Request) {
+	webhooks, err := Webhooks()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	result := []*WebhookResponse{}
+	for _, webhook := range webhooks {
+		result = append(result, webhookResponse(webhook))
+	}
+
+	err = json.NewEncoder(w).Encode(result)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+// webhook looks up the webhook named in the URL, writing the error response when it can't.
+func (c *Controller) webhook(w http.ResponseWriter, r *http.Request) (*Webhook, bool) {
+	id, err := uuid.FromString(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "invalid id", http.StatusBadRequest)
+		return nil, false
+	}
+	webhook, err := WebhookByID(id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return nil, false
+	}
+	if webhook == nil {
+		http.Error(w, "unknown webhook", http.StatusNotFound)
+		return nil, false
+	}
+	return webhook, true
+}
+
+func (c *Controller) Webhook(w http.ResponseWriter, r *http.Request) {
+	webhook, ok := c.webhook(w, r)
+	if !ok {
+		return
+	}
+	err := json.NewEncoder(w).Encode(webhookResponse(webhook))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+// DeleteWebhook stops a webhook and cancels its pending deliveries.
+func (c *Controller) DeleteWebhook(w http.ResponseWriter, r *http.Request) {
+	webhook, ok := c.webhook(w, r)
+	if !ok {
+		return
+	}
+	_, err := DeleteWebhook(webhook.ID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// WebhookDeliveries is the delivery log of a webhook, newest first, optionally filtered by ?status=.
+func (c *Controller) WebhookDeliveries(w http.ResponseWriter, r *http.Request) {
+	webhook, ok := c.webhook(w, r)
+	if !ok {
+		return
+	}
+	limit := DefaultDeliveriesLimit
+	if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
+		var err error
+		limit, err = strconv.Atoi(limitStr)
+		if err != nil || limit < 1 {
+			http.Error(w, "invalid limit", http.StatusBadRequest)
+			return
+		}
+		if limit > MaxDeliveriesLimit {
+			limit = MaxDeliveriesLimit
+		}
+	}
+	deliveries, err := WebhookDeliveries(webhook.ID, DeliveryStatus(r.URL.Query().Get("status")), limit)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	result := []*DeliveryResponse{}
+	for _, delivery := range deliveries {
+		result = append(result, deliveryResponse(delivery))
+	}
+
+	err = json.NewEncoder(w).Encode(result)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+// WebhookDelivery returns a delivery with the log of its attempts.
+func (c *Controller) WebhookDelivery(w http.ResponseWriter, r *http.Request) {
+	webhook, ok := c.webhook(w, r)
+	if !ok {
+		return
+	}
+	id, err := uuid.FromString(chi.URLParam(r, "delivery"))
+	if err != nil {
+		http.Error(w, "invalid delivery", http.StatusBadRequest)
+		return
+	}
+	delivery, err := WebhookDeliveryByID(webhook.ID, id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if delivery == nil {
+		http.Error(w, "unknown delivery", http.StatusNotFound)
+		return
+	}
+	attempts, err := WebhookAttempts(delivery.ID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	result := deliveryResponse(delivery)
+	result.Log = []*WebhookAttemptResponse{}
+	for _, attempt := range attempts {
+		result.Log = append(result.Log, &WebhookAttemptResponse{attempt.Attempt, attempt.StatusCode, attempt.Error, attempt.DurationMS, attempt.CreatedAt})
+	}
+
+	err = json.NewEncoder(w).Encode(result)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+// RedeliverWebhook sends a dead or delivered delivery again.
+func (c *Controller) RedeliverWebhook(w http.ResponseWriter, r *http.Request) {
+	webhook, ok := c.webhook(w, r)
+	if !ok {
+		return
+	}
+	id, err := uuid.FromString(chi.URLParam(r, "delivery"))
+	if err != nil {
+		http.Error(w, "invalid delivery", http.StatusBadRequest)
+		return
+	}
+	queued, err := RedeliverWebhook(webhook.ID, id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !queued {
+		http.Error(w, "no dead or delivered delivery with that id", http.StatusNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
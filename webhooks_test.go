@@ -0,0 +1,175 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestSignWebhook(t *testing.T) {
+	tests := []struct {
+		name   string
+		secret string
+		ts     time.Time
+		body   string
+		want   string
+	}{
+		{
+			name:   "event body",
+			secret: "whsec_test",
+			ts:     time.Unix(1700000000, 0),
+			body:   `{"id":"1"}`,
+			want:   "t=1700000000,v1=11bf4466ea17c3df3fd743af0b435368e16b7a05eb8eced85e8c4670767bdec5",
+		},
+		{
+			name: "empty secret and body",
+			ts:   time.Unix(0, 0),
+			want: "t=0,v1=b849d5a581847b281957065739df36df2463d1977ea8d6e1e4e6cf33fadc68c3",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := SignWebhook(tt.secret, tt.ts, []byte(tt.body))
+			if got != tt.want {
+				t.Errorf("SignWebhook() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWebhookBackoff(t *testing.T) {
+	d := &WebhookDispatcher{MinBackoff: 10 * time.Second, MaxBackoff: time.Minute}
+	tests := []struct {
+		attempts int
+		want     time.Duration
+	}{
+		{0, 10 * time.Second},
+		{1, 10 * time.Second},
+		{2, 20 * time.Second},
+		{3, 40 * time.Second},
+		{4, time.Minute},
+		{50, time.Minute},
+	}
+	for _, tt := range tests {
+		got := d.backoff(tt.attempts)
+		if got != tt.want {
+			t.Errorf("backoff(%d) = %s, want %s", tt.attempts, got, tt.want)
+		}
+	}
+}
+
+func TestWebhookSettle(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	d := &WebhookDispatcher{MaxAttempts: 3, MinBackoff: 10 * time.Second, MaxBackoff: time.Minute}
+	tests := []struct {
+		name     string
+		attempts int
+		err      error
+		status   DeliveryStatus
+		next     time.Time
+	}{
+		{"first attempt delivered", 0, nil, DeliveryDelivered, time.Time{}},
+		{"first attempt failed", 0, errors.New("unexpected status 500"), DeliveryPending, now.Add(10 * time.Second)},
+		{"second attempt failed", 1, errors.New("unexpected status 500"), DeliveryPending, now.Add(20 * time.Second)},
+		{"last attempt failed", 2, errors.New("unexpected status 500"), DeliveryDead, now.Add(40 * time.Second)},
+		{"redelivered dead delivery failed again", 3, errors.New("unexpected status 500"), DeliveryDead, now.Add(time.Minute)},
+		{"retry delivered", 2, nil, DeliveryDelivered, time.Time{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			statusCode := 500
+			delivery := &WebhookDelivery{Status: DeliveryPending, Attempts: tt.attempts}
+			attempt := &WebhookAttempt{Attempt: tt.attempts + 1, StatusCode: &statusCode}
+			d.settle(delivery, attempt, tt.err, now)
+			if delivery.Status != tt.status {
+				t.Errorf("status = %s, want %s", delivery.Status, tt.status)
+			}
+			if delivery.Attempts != tt.attempts+1 {
+				t.Errorf("attempts = %d, want %d", delivery.Attempts, tt.attempts+1)
+			}
+			if !delivery.NextAttemptAt.Equal(tt.next) {
+				t.Errorf("next attempt at = %s, want %s", delivery.NextAttemptAt, tt.next)
+			}
+			if tt.err == nil {
+				if delivery.DeliveredAt == nil || delivery.LastError != nil || attempt.Error != nil {
+					t.Errorf("delivered at = %v, last error = %v, attempt error = %v", delivery.DeliveredAt, delivery.LastError, attempt.Error)
+				}
+				return
+			}
+			if delivery.DeliveredAt != nil || delivery.LastError == nil || *delivery.LastError != tt.err.Error() || attempt.Error == nil {
+				t.Errorf("delivered at = %v, last error = %v, attempt error = %v", delivery.DeliveredAt, delivery.LastError, attempt.Error)
+			}
+		})
+	}
+}
+
+func TestCheckWebhookIP(t *testing.T) {
+	tests := []struct {
+		ip   string
+		want bool
+	}{
+		{"93.184.216.34", true},
+		{"2606:2800:220:1:248:1893:25c8:1946", true},
+		{"127.0.0.1", false},
+		{"::1", false},
+		{"10.1.2.3", false},
+		{"172.16.0.1", false},
+		{"192.168.1.1", false},
+		{"100.64.0.1", false},
+		{"169.254.169.254", false},
+		{"fe80::1", false},
+		{"fd00::1", false},
+		{"0.0.0.0", false},
+		{"224.0.0.1", false},
+	}
+	for _, tt := range tests {
+		err := checkWebhookIP(net.ParseIP(tt.ip))
+		if (err == nil) != tt.want {
+			t.Errorf("checkWebhookIP(%s) = %v, want allowed %t", tt.ip, err, tt.want)
+		}
+	}
+}
+
+func TestValidateWebhookURL(t *testing.T) {
+	tests := []struct {
+		url  string
+		want bool
+	}{
+		{"https://93.184.216.34/hook", true},
+		{"http://93.184.216.34:8080/hook", true},
+		{"ftp://93.184.216.34/hook", false},
+		{"https:///hook", false},
+		{"http://127.0.0.1/hook", false},
+		{"http://[::1]:8080/hook", false},
+		{"http://169.254.169.254/latest/meta-data", false},
+	}
+	for _, tt := range tests {
+		_, err := ValidateWebhookURL(context.Background(), tt.url)
+		if (err == nil) != tt.want {
+			t.Errorf("ValidateWebhookURL(%s) = %v, want allowed %t", tt.url, err, tt.want)
+		}
+		if err != nil && !errors.Is(err, ErrWebhookTarget) {
+			t.Errorf("ValidateWebhookURL(%s) = %v, want ErrWebhookTarget", tt.url, err)
+		}
+	}
+}
+
+func TestWebhookClientRefusesLocalTargets(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	resp, err := NewWebhookClient(time.Second).Post(server.URL, "application/json", nil)
+	if err == nil {
+		resp.Body.Close()
+		t.Fatal("webhook client reached a loopback server")
+	}
+	if !errors.Is(err, ErrWebhookTarget) {
+		t.Errorf("post to loopback = %v, want ErrWebhookTarget", err)
+	}
+}
@@ -0,0 +1,584 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/go-chi/chi/v5"
+	"github.com/gofrs/uuid"
+)
+
+// WhitelistedAddress is watched on its chain from StartBlock to EndBlock, both inclusive and open when nil.
+// Deleted addresses stay in the table with DeletedAt set and are no longer scraped.
+type WhitelistedAddress struct {
+	ID         uuid.UUID
+	Address    string
+	ChainID    int64
+	Note       string
+	StartBlock *int64
+	EndBlock   *int64
+	DeletedAt  *time.Time
+	CreatedAt  time.Time
+	UpdatedAt  time.Time
+}
+
+// Watches reports whether the address is watched anywhere in the inclusive block range.
+func (a *WhitelistedAddress) Watches(fromBlock int64, toBlock int64) bool {
+	if a.StartBlock != nil && *a.StartBlock > toBlock {
+		return false
+	}
+	if a.EndBlock != nil && *a.EndBlock < fromBlock {
+		return false
+	}
+	return true
+}
+
+// AuditTarget and AuditValue describe the address in admin_audit.
+func (a *WhitelistedAddress) AuditTarget() string {
+	return fmt.Sprintf("%d_%s", a.ChainID, a.Address)
+}
+
+func (a *WhitelistedAddress) AuditValue() *string {
+	raw, _ := json.Marshal(map[string]interface{}{"note": a.Note, "start_block": a.StartBlock, "end_block": a.EndBlock})
+	result := string(raw)
+	return &result
+}
+
+type RescanStatus string
+
+const RescanPending RescanStatus = "pending"
+const RescanRunning RescanStatus = "running"
+const RescanDone RescanStatus = "done"
+const RescanFailed RescanStatus = "failed"
+const RescanCancelled RescanStatus = "cancelled"
+
+const DefaultRescanChunkSize = 1000
+const DefaultRescanMaxAttempts = 5
+
+// RescanUpdateTimeout bounds saving the outcome of a rescan, which happens after its run's context may be done.
+const RescanUpdateTimeout = 10 * time.Second
+
+// Rescan scrapes a block range of one asset again. Addresses restrict it to transfers from or to them;
+// when nil, it covers the chain's whitelisted addresses for native coins and watched tokens,
+// and every transfer of tokens indexed in full.
+type Rescan struct {
+	ID         uuid.UUID
+	ChainID    int64
+	Symbol     string
+	FromBlock  int64
+	ToBlock    int64
+	Addresses  []string
+	Reason     string
+	Status     RescanStatus
+	Error      *string
+	Attempts   int
+	CreatedAt  time.Time
+	StartedAt  *time.Time
+	FinishedAt *time.Time
+}
+
+// WhitelistRescans are the rescans that fill in the history of a newly whitelisted address: for every
+// native coin and watched token of the chain, from the address's start block (or the chain's base block)
+// up to the asset's cursor, or its end block if that is earlier. The live scrapers cover the rest.
+func WhitelistRescans(chain *Chain, address *WhitelistedAddress) ([]*Rescan, error) {
+	result := []*Rescan{}
+	for _, asset := range chain.Assets {
+		if !asset.Native() && asset.IndexMode != IndexModeWatched {
+			continue
+		}
+		fromBlock := chain.BaseBlock
+		if address.StartBlock != nil {
+			fromBlock = *address.StartBlock
+		}
+		lastBlock, err := GetInt(KeyLastBlock(chain.ID, asset.Symbol), int(chain.BaseBlock))
+		if err != nil {
+			return nil, fmt.Errorf("get last block: %w", err)
+		}
+		toBlock := int64(lastBlock)
+		if address.EndBlock != nil && *address.EndBlock < toBlock {
+			toBlock = *address.EndBlock
+		}
+		if fromBlock > toBlock {
+			continue
+		}
+		result = append(result, &Rescan{
+			ChainID:   chain.ID,
+			Symbol:    asset.Symbol,
+			FromBlock: fromBlock,
+			ToBlock:   toBlock,
+			Addresses: []string{address.Address},
+			Reason:    fmt.Sprintf("whitelisted %s", address.Address),
+		})
+	}
+	return result, nil
+}
+
+// Rescanner works through the queued rescans, oldest first, one at a time. Each rescan is a backfill job,
+// so a run cancelled by the scheduler's timeout resumes at the first unfinished chunk on the next run.
+// A rescan failing MaxAttempts runs, or whose chain or asset is no longer configured, is marked failed
+// so it doesn't hold up the rest of the queue.
+type Rescanner struct {
+	Chains      *ChainRegistry
+	ChunkSize   int64
+	Concurrency int
+	MaxAttempts int
+}
+
+// Run works on the oldest unfinished rescan until it is done.
+func (r *Rescanner) Run(ctx context.Context) error {
+	rescan, err := NextRescan(ctx)
+	if err != nil {
+		return err
+	}
+	if rescan == nil {
+		return nil
+	}
+	err = r.rescan(ctx, rescan)
+	if err != nil {
+		msg := err.Error()
+		rescan.Error = &msg
+		rescan.Status = RescanRunning
+		// A run cut short by the scheduler's timeout made progress, it didn't fail.
+		if ctx.Err() == nil {
+			rescan.Attempts++
+		}
+		maxAttempts := r.MaxAttempts
+		if maxAttempts <= 0 {
+			maxAttempts = DefaultRescanMaxAttempts
+		}
+		if errors.Is(err, ErrUnknownChain) || errors.Is(err, ErrUnknownSymbol) || rescan.Attempts >= maxAttempts {
+			rescan.Status = RescanFailed
+		}
+	} else {
+		rescan.Error = nil
+		rescan.Status = RescanDone
+	}
+	updateCtx, cancel := context.WithTimeout(context.Background(), RescanUpdateTimeout)
+	defer cancel()
+	updateErr := UpdateRescan(updateCtx, rescan)
+	if updateErr != nil {
+		return updateErr
+	}
+	return err
+}
+
+func (r *Rescanner) rescan(ctx context.Context, rescan *Rescan) error {
+	chain, ok := r.Chains.ByID(rescan.ChainID)
+	if !ok {
+		return fmt.Errorf("rescan %s: %w %d", rescan.ID, ErrUnknownChain, rescan.ChainID)
+	}
+	asset, ok := chain.Asset(rescan.Symbol)
+	if !ok {
+		return fmt.Errorf("rescan %s: %w %s", rescan.ID, ErrUnknownSymbol, rescan.Symbol)
+	}
+
+	// A targeted rescan of a token indexed in full has nothing to add.
+	if rescan.Addresses != nil && !asset.Native() && asset.IndexMode != IndexModeWatched {
+		return nil
+	}
+	var addresses []common.Address
+	if rescan.Addresses != nil {
+		for _, addr := range rescan.Addresses {
+			addresses = append(addresses, common.HexToAddress(addr))
+		}
+	} else if asset.Native() || asset.IndexMode == IndexModeWatched {
+		var err error
+		addresses, err = WatchedAddresses(chain.ID, rescan.FromBlock, rescan.ToBlock)
+		if err != nil {
+			return fmt.Errorf("get whitelisted addresses: %w", err)
+		}
+	}
+
+	chunkSize := r.ChunkSize
+	if chunkSize <= 0 {
+		chunkSize = DefaultRescanChunkSize
+	}
+	b := &Backfill{
+		Job:         fmt.Sprintf("rescan_%s", rescan.ID),
+		FromBlock:   rescan.FromBlock,
+		ToBlock:     rescan.ToBlock,
+		ChunkSize:   chunkSize,
+		Concurrency: r.Concurrency,
+	}
+	opts := BlockFetchOptions{Concurrency: 1, BatchSize: 20}
+	b.Scrape = func(ctx context.Context, fromBlock int64, toBlock int64) ([]*Transfer, []*Approval, error) {
+		if asset.Native() {
+			transfers, err := ScrapeETH(ctx, chain.Node, fromBlock, toBlock+1, addresses, chain.ID, asset.Symbol, opts)
+			return transfers, nil, err
+		}
+		transfers, err := ScrapeSUPS(ctx, chain.Node, fromBlock, toBlock, chain.ID, asset, addresses)
+		if err != nil {
+			return nil, nil, err
+		}
+		approvals, err := ScrapeApprovals(ctx, chain.Node, fromBlock, toBlock, chain.ID, asset, addresses)
+		if err != nil {
+			return nil, nil, fmt.Errorf("scrape approvals: %w", err)
+		}
+		return transfers, approvals, nil
+	}
+	return b.Run(ctx)
+}
+
+// WhitelistRequest adds or annotates a whitelisted address. Blocks are inclusive, and the range is open when they are unset.
+type WhitelistRequest struct {
+	Address    string  `json:"address"`
+	Note       *string `json:"note"`
+	StartBlock *int64  `json:"start_block"`
+	EndBlock   *int64  `json:"end_block"`
+}
+
+type WhitelistedAddressResponse struct {
+	Address    string     `json:"address"`
+	Chain      int64      `json:"chain"`
+	Note       string     `json:"note"`
+	StartBlock *int64     `json:"start_block"`
+	EndBlock   *int64     `json:"end_block"`
+	DeletedAt  *time.Time `json:"deleted_at"`
+	CreatedAt  time.Time  `json:"created_at"`
+	UpdatedAt  time.Time  `json:"updated_at"`
+}
+
+func whitelistedAddressResponse(address *WhitelistedAddress) *WhitelistedAddressResponse {
+	return &WhitelistedAddressResponse{
+		Address:    address.Address,
+		Chain:      address.ChainID,
+		Note:       address.Note,
+		StartBlock: address.StartBlock,
+		EndBlock:   address.EndBlock,
+		DeletedAt:  address.DeletedAt,
+		CreatedAt:  address.CreatedAt,
+		UpdatedAt:  address.UpdatedAt,
+	}
+}
+
+// AddWhitelistResponse lists the rescans queued to fill in the history of the new address.
+type AddWhitelistResponse struct {
+	*WhitelistedAddressResponse
+	Rescans []*RescanResponse `json:"rescans"`
+}
+
+type RescanResponse struct {
+	ID         string       `json:"id"`
+	Chain      int64        `json:"chain"`
+	Symbol     string       `json:"symbol"`
+	FromBlock  int64        `json:"from_block"`
+	ToBlock    int64        `json:"to_block"`
+	Addresses  []string     `json:"addresses"`
+	Reason     string       `json:"reason"`
+	Status     RescanStatus `json:"status"`
+	Error      *string      `json:"error"`
+	Attempts   int          `json:"attempts"`
+	CreatedAt  time.Time    `json:"created_at"`
+	StartedAt  *time.Time   `json:"started_at"`
+	FinishedAt *time.Time   `json:"finished_at"`
+}
+
+func rescanResponse(rescan *Rescan) *RescanResponse {
+	return &RescanResponse{
+		ID:         rescan.ID.String(),
+		Chain:      rescan.ChainID,
+		Symbol:     rescan.Symbol,
+		FromBlock:  rescan.FromBlock,
+		ToBlock:    rescan.ToBlock,
+		Addresses:  rescan.Addresses,
+		Reason:     rescan.Reason,
+		Status:     rescan.Status,
+		Error:      rescan.Error,
+		Attempts:   rescan.Attempts,
+		CreatedAt:  rescan.CreatedAt,
+		StartedAt:  rescan.StartedAt,
+		FinishedAt: rescan.FinishedAt,
+	}
+}
+
+const DefaultRescansLimit = 50
+
+const MaxRescansLimit = 500
+
+func validWhitelistBlocks(startBlock *int64, endBlock *int64) bool {
+	if startBlock != nil && *startBlock < 0 {
+		return false
+	}
+	if endBlock != nil && *endBlock < 0 {
+		return false
+	}
+	return startBlock == nil || endBlock == nil || *startBlock <= *endBlock
+}
+
+// whitelistTarget resolves the {chain} and, when present, {address} of a whitelist route, writing the error if it can't.
+func (c *Controller) whitelistTarget(w http.ResponseWriter, r *http.Request) (*Chain, string, bool) {
+	chain, ok := c.Chains.Lookup(chi.URLParam(r, "chain"))
+	if !ok {
+		http.Error(w, "unknown chain", http.StatusNotFound)
+		return nil, "", false
+	}
+	address := chi.URLParam(r, "address")
+	if address == "" {
+		return chain, "", true
+	}
+	if !common.IsHexAddress(address) {
+		http.Error(w, "invalid address", http.StatusBadRequest)
+		return nil, "", false
+	}
+	return chain, common.HexToAddress(address).Hex(), true
+}
+
+// Whitelist lists the whitelisted addresses of a chain, with the deleted ones when ?deleted=true.
+func (c *Controller) Whitelist(w http.ResponseWriter, r *http.Request) {
+	chain, _, ok := c.whitelistTarget(w, r)
+	if !ok {
+		return
+	}
+	addresses, err := WhitelistedAddressRecords(r.Context(), chain.ID, r.URL.Query().Get("deleted") == "true")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	result := []*WhitelistedAddressResponse{}
+	for _, address := range addresses {
+		result = append(result, whitelistedAddressResponse(address))
+	}
+	err = json.NewEncoder(w).Encode(result)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+// AddWhitelist whitelists an address, or restores a deleted one, and queues the rescans of its history.
+func (c *Controller) AddWhitelist(w http.ResponseWriter, r *http.Request) {
+	chain, _, ok := c.whitelistTarget(w, r)
+	if !ok {
+		return
+	}
+	req := &WhitelistRequest{}
+	err := json.NewDecoder(r.Body).Decode(req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !common.IsHexAddress(req.Address) {
+		http.Error(w, "invalid address", http.StatusBadRequest)
+		return
+	}
+	if !validWhitelistBlocks(req.StartBlock, req.EndBlock) {
+		http.Error(w, "invalid start_block or end_block", http.StatusBadRequest)
+		return
+	}
+	address := &WhitelistedAddress{
+		Address:    common.HexToAddress(req.Address).Hex(),
+		ChainID:    chain.ID,
+		StartBlock: req.StartBlock,
+		EndBlock:   req.EndBlock,
+	}
+	if req.Note != nil {
+		address.Note = *req.Note
+	}
+	rescans, err := WhitelistRescans(chain, address)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	added, err := AddWhitelistedAddress(r.Context(), adminActor(r), address, rescans)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if added == nil {
+		http.Error(w, "address already whitelisted", http.StatusConflict)
+		return
+	}
+	result := &AddWhitelistResponse{whitelistedAddressResponse(added), []*RescanResponse{}}
+	for _, rescan := range rescans {
+		result.Rescans = append(result.Rescans, rescanResponse(rescan))
+	}
+	w.WriteHeader(http.StatusCreated)
+	err = json.NewEncoder(w).Encode(result)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+// UpdateWhitelist changes the note and block range of a whitelisted address. Only the fields given change.
+func (c *Controller) UpdateWhitelist(w http.ResponseWriter, r *http.Request) {
+	chain, addr, ok := c.whitelistTarget(w, r)
+	if !ok {
+		return
+	}
+	req := &WhitelistRequest{}
+	err := json.NewDecoder(r.Body).Decode(req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	address, err := WhitelistedAddressByAddress(r.Context(), chain.ID, addr)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if address == nil || address.DeletedAt != nil {
+		http.Error(w, "address not whitelisted", http.StatusNotFound)
+		return
+	}
+	if req.Note != nil {
+		address.Note = *req.Note
+	}
+	if req.StartBlock != nil {
+		address.StartBlock = req.StartBlock
+	}
+	if req.EndBlock != nil {
+		address.EndBlock = req.EndBlock
+	}
+	if !validWhitelistBlocks(address.StartBlock, address.EndBlock) {
+		http.Error(w, "invalid start_block or end_block", http.StatusBadRequest)
+		return
+	}
+	address, err = UpdateWhitelistedAddress(r.Context(), adminActor(r), address)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if address == nil {
+		http.Error(w, "address not whitelisted", http.StatusNotFound)
+		return
+	}
+	err = json.NewEncoder(w).Encode(whitelistedAddressResponse(address))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+// DeleteWhitelist soft-deletes a whitelisted address, so it is no longer scraped.
+func (c *Controller) DeleteWhitelist(w http.ResponseWriter, r *http.Request) {
+	chain, addr, ok := c.whitelistTarget(w, r)
+	if !ok {
+		return
+	}
+	deleted, err := DeleteWhitelistedAddress(r.Context(), adminActor(r), chain.ID, addr)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !deleted {
+		http.Error(w, "address not whitelisted", http.StatusNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Rescans lists the newest rescans, optionally filtered by ?status=.
+func (c *Controller) Rescans(w http.ResponseWriter, r *http.Request) {
+	limit := DefaultRescansLimit
+	if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
+		var err error
+		limit, err = strconv.Atoi(limitStr)
+		if err != nil || limit < 1 {
+			http.Error(w, "invalid limit", http.StatusBadRequest)
+			return
+		}
+		if limit > MaxRescansLimit {
+			limit = MaxRescansLimit
+		}
+	}
+	rescans, err := Rescans(r.Context(), RescanStatus(r.URL.Query().Get("status")), limit)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	result := []*RescanResponse{}
+	for _, rescan := range rescans {
+		result = append(result, rescanResponse(rescan))
+	}
+	err = json.NewEncoder(w).Encode(result)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+// RescanRequest queues a rescan of an inclusive block range. Addresses restrict it to their transfers.
+type RescanRequest struct {
+	Chain     string   `json:"chain"`
+	Symbol    string   `json:"symbol"`
+	FromBlock *int64   `json:"from_block"`
+	ToBlock   *int64   `json:"to_block"`
+	Addresses []string `json:"addresses"`
+	Reason    string   `json:"reason"`
+}
+
+// QueueRescan queues a rescan of a block range of an asset.
+func (c *Controller) QueueRescan(w http.ResponseWriter, r *http.Request) {
+	req := &RescanRequest{}
+	err := json.NewDecoder(r.Body).Decode(req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	chain, asset, err := c.Chains.LookupAsset(req.Chain, req.Symbol)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	blockHeight, err := GetInt(KeyBlockHeight(chain.ID), int(chain.BaseBlock))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if req.FromBlock == nil || req.ToBlock == nil || *req.FromBlock < 0 || *req.FromBlock > *req.ToBlock || *req.ToBlock > int64(blockHeight) {
+		http.Error(w, "from_block and to_block must be an ordered range up to the block height", http.StatusBadRequest)
+		return
+	}
+	rescan := &Rescan{ChainID: chain.ID, Symbol: asset.Symbol, FromBlock: *req.FromBlock, ToBlock: *req.ToBlock, Reason: req.Reason}
+	for _, addr := range req.Addresses {
+		if !common.IsHexAddress(addr) {
+			http.Error(w, fmt.Sprintf("invalid address %q", addr), http.StatusBadRequest)
+			return
+		}
+		rescan.Addresses = append(rescan.Addresses, common.HexToAddress(addr).Hex())
+	}
+	if rescan.Reason == "" {
+		rescan.Reason = "requested"
+	}
+	err = QueueRescan(r.Context(), adminActor(r), rescan)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+	err = json.NewEncoder(w).Encode(rescanResponse(rescan))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+// CancelRescan cancels a pending or running rescan. A running one stops after its current run.
+func (c *Controller) CancelRescan(w http.ResponseWriter, r *http.Request) {
+	id, err := uuid.FromString(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "invalid id", http.StatusBadRequest)
+		return
+	}
+	rescan, err := CancelRescan(r.Context(), adminActor(r), id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if rescan == nil {
+		http.Error(w, "no pending or running rescan with that id", http.StatusNotFound)
+		return
+	}
+	err = json.NewEncoder(w).Encode(rescanResponse(rescan))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}